@@ -4,8 +4,13 @@ Prifi-app starts a cothority node in either trustee, relay or client mode.
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
 
 	"io/ioutil"
 	"os/user"
@@ -77,6 +82,24 @@ func main() {
 			Aliases: []string{"c"},
 			Action:  startClient0,
 		},
+		{
+			Name:      "post",
+			Usage:     "posts a message to the bulletin board, through the running client",
+			ArgsUsage: "[message], read from stdin if absent",
+			Action:    postToBoard,
+		},
+		{
+			Name:   "read",
+			Usage:  "prints the messages of the bulletin board, as stored by the running client",
+			Action: readBoard,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "from",
+					Value: 0,
+					Usage: "number of the first message to print",
+				},
+			},
+		},
 	}
 	app.Flags = []cli.Flag{
 		cli.IntFlag{
@@ -234,6 +257,88 @@ func startClient0(c *cli.Context) error {
 	return nil
 }
 
+/**
+ * BULLETIN BOARD
+ */
+
+// boardURL returns the URL of the bulletin board of the client running on this host
+func boardURL(c *cli.Context) (string, error) {
+	dissentTomlConfig, err := readPriFiConfigFile(c)
+	if err != nil {
+		return "", err
+	}
+	if dissentTomlConfig.ClientAPIPort <= 0 {
+		return "", errors.New("ClientAPIPort is not set in " + c.GlobalString("prifi_config"))
+	}
+	return "http://127.0.0.1:" + strconv.Itoa(dissentTomlConfig.ClientAPIPort) + "/board", nil
+}
+
+// postToBoard sends a message to the bulletin board of the running client
+func postToBoard(c *cli.Context) error {
+	url, err := boardURL(c)
+	if err != nil {
+		log.Error("Could not find the client API:", err)
+		os.Exit(1)
+	}
+
+	var data []byte
+	if c.NArg() > 0 {
+		data = []byte(strings.Join(c.Args(), " "))
+	} else if data, err = ioutil.ReadAll(os.Stdin); err != nil {
+		log.Error("Could not read the message:", err)
+		os.Exit(1)
+	}
+
+	body, err := json.Marshal(&dissent_service.APIMessage{Data: data})
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Error("Could not reach the client:", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		reason, _ := ioutil.ReadAll(resp.Body)
+		log.Error("The client refused the message:", strings.TrimSpace(string(reason)))
+		os.Exit(1)
+	}
+	log.Info("Message of", len(data), "bytes queued for anonymous broadcast")
+	return nil
+}
+
+// readBoard prints the messages of the bulletin board stored by the running client
+func readBoard(c *cli.Context) error {
+	url, err := boardURL(c)
+	if err != nil {
+		log.Error("Could not find the client API:", err)
+		os.Exit(1)
+	}
+
+	resp, err := http.Get(url + "?from=" + strconv.Itoa(c.Int("from")))
+	if err != nil {
+		log.Error("Could not reach the client:", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		reason, _ := ioutil.ReadAll(resp.Body)
+		log.Error("Could not read the bulletin board:", strings.TrimSpace(string(reason)))
+		os.Exit(1)
+	}
+
+	entries := make([]*dissent_service.BoardEntry, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		log.Error("Could not decode the bulletin board:", err)
+		os.Exit(1)
+	}
+	for _, e := range entries {
+		fmt.Printf("#%d [round %d, slot %d] %s\n", e.Seq, e.Round, e.Slot, string(e.Data))
+	}
+	return nil
+}
+
 /**
  * COTHORITY
  */
@@ -0,0 +1,136 @@
+package protocols
+
+// This file contains the authentication of the protocol messages.
+//
+// Client0 is not trusted, and relays most messages, so every message is signed with the long-term
+// key of its sender (the key of its server identity). A signature covers the type and content of
+// the message, the session ID (the ID of this protocol instance), and a sequence number counted
+// on each link. The receiver rejects messages with an invalid signature, from another session,
+// or whose sequence number is not the next one on that link (i.e. replayed or reordered).
+//
+// Messages which Client0 forwards on behalf of another node, such as the PUBLIC_KEY messages in
+// ALL_PUBLIC_KEYS, keep the signature of their sender, and every receiver checks it.
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/kyber.v2/sign/schnorr"
+	"gopkg.in/dedis/onet.v2"
+	"gopkg.in/dedis/onet.v2/log"
+	"gopkg.in/dedis/onet.v2/network"
+)
+
+// signedMessage is implemented by every message embedding a MessageAuth
+type signedMessage interface {
+	auth() *MessageAuth
+}
+
+// authState holds the sequence numbers of each link, keyed by the public key of the peer
+type authState struct {
+	sync.Mutex
+	sessionID []byte
+	sendSeq   map[string]int
+	recvSeq   map[string]int
+}
+
+func newAuthState(sessionID []byte) *authState {
+	return &authState{
+		sessionID: sessionID,
+		sendSeq:   make(map[string]int),
+		recvSeq:   make(map[string]int),
+	}
+}
+
+// sessionID returns the ID of this protocol instance, which every node knows
+func (p *DissentProtocol) sessionID() []byte {
+	roundID := p.Token().RoundID
+	return append([]byte{}, roundID[:]...)
+}
+
+// signedData returns the bytes covered by the signature of a message
+func signedData(msg signedMessage) ([]byte, error) {
+	a := msg.auth()
+	signature := a.Signature
+	a.Signature = nil
+	data, err := network.Marshal(msg)
+	a.Signature = signature
+	return data, err
+}
+
+// signMessage returns a signed copy of a message; each recipient gets its own copy, as the sequence numbers differ
+func signMessage(msg interface{}, priv kyber.Scalar, sessionID []byte, seq int) (interface{}, error) {
+	v := reflect.Indirect(reflect.ValueOf(msg))
+	signed := reflect.New(v.Type())
+	signed.Elem().Set(v)
+
+	sm, ok := signed.Interface().(signedMessage)
+	if !ok {
+		return nil, errors.New("message does not embed a MessageAuth")
+	}
+	a := sm.auth()
+	a.SessionID = sessionID
+	a.Seq = seq
+
+	data, err := signedData(sm)
+	if err != nil {
+		return nil, err
+	}
+	if a.Signature, err = schnorr.Sign(config.CryptoSuite, priv, data); err != nil {
+		return nil, err
+	}
+	return sm, nil
+}
+
+// authenticate is called at the beginning of each handler; it checks the signature, session and sequence
+// number of a received message
+func (p *DissentProtocol) authenticate(sender *onet.TreeNode, msg signedMessage) error {
+	err := p.ms.auth.check(sender, msg)
+	if err != nil {
+		log.Error("Rejected a message from", sender.ServerIdentity, ":", err)
+	}
+	return err
+}
+
+// verifyForwarded checks the signature and session of a message relayed by Client0 on behalf of its
+// signer; the sequence number is covered by the signature, but belongs to the link between the signer
+// and Client0
+func verifyForwarded(signer *network.ServerIdentity, msg signedMessage, sessionID []byte) error {
+	a := msg.auth()
+	if !bytes.Equal(a.SessionID, sessionID) {
+		return errors.New("message from another session")
+	}
+	data, err := signedData(msg)
+	if err != nil {
+		return err
+	}
+	if err := schnorr.Verify(config.CryptoSuite, signer.Public, data, a.Signature); err != nil {
+		return errors.New("invalid signature: " + err.Error())
+	}
+	return nil
+}
+
+func (s *authState) check(sender *onet.TreeNode, msg signedMessage) error {
+	if err := verifyForwarded(sender.ServerIdentity, msg, s.sessionID); err != nil {
+		return err
+	}
+	a := msg.auth()
+
+	s.Lock()
+	defer s.Unlock()
+	link := sender.ServerIdentity.Public.String()
+	expected := s.recvSeq[link]
+	if a.Seq < expected {
+		return errors.New("replayed message, sequence number " + strconv.Itoa(a.Seq) + " but expected " + strconv.Itoa(expected))
+	}
+	if a.Seq > expected {
+		return errors.New("out-of-order message, sequence number " + strconv.Itoa(a.Seq) + " but expected " + strconv.Itoa(expected))
+	}
+	s.recvSeq[link]++
+	return nil
+}
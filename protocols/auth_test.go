@@ -0,0 +1,105 @@
+package protocols
+
+import (
+	"testing"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/onet.v2"
+	"gopkg.in/dedis/onet.v2/network"
+)
+
+func TestAuthenticate(t *testing.T) {
+	suite := config.CryptoSuite
+	sessionID := []byte("session")
+	pick := func() (kyber.Scalar, kyber.Point) {
+		priv := suite.Scalar().Pick(suite.RandomStream())
+		return priv, suite.Point().Mul(priv, nil)
+	}
+	senderPriv, senderPub := pick()
+	otherPriv, otherPub := pick()
+	sender := &onet.TreeNode{ServerIdentity: &network.ServerIdentity{Public: senderPub}}
+	other := &onet.TreeNode{ServerIdentity: &network.ServerIdentity{Public: otherPub}}
+
+	sign := func(msg *NEW_ROUND, priv kyber.Scalar, session []byte, seq int) *NEW_ROUND {
+		signed, err := signMessage(msg, priv, session, seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed.(*NEW_ROUND)
+	}
+	tampered := sign(&NEW_ROUND{RoundID: 0}, senderPriv, sessionID, 0)
+	tampered.RoundID = 1
+	unsigned := &NEW_ROUND{MessageAuth: MessageAuth{SessionID: sessionID}}
+
+	type arrival struct {
+		from *onet.TreeNode
+		msg  *NEW_ROUND
+		ok   bool
+	}
+	tests := []struct {
+		name     string
+		arrivals []arrival
+	}{
+		{"in sequence", []arrival{
+			{sender, sign(&NEW_ROUND{RoundID: 0}, senderPriv, sessionID, 0), true},
+			{sender, sign(&NEW_ROUND{RoundID: 1}, senderPriv, sessionID, 1), true},
+		}},
+		{"replayed", []arrival{
+			{sender, sign(&NEW_ROUND{RoundID: 0}, senderPriv, sessionID, 0), true},
+			{sender, sign(&NEW_ROUND{RoundID: 0}, senderPriv, sessionID, 0), false},
+		}},
+		{"out of order", []arrival{
+			{sender, sign(&NEW_ROUND{RoundID: 1}, senderPriv, sessionID, 1), false},
+			{sender, sign(&NEW_ROUND{RoundID: 0}, senderPriv, sessionID, 0), true},
+		}},
+		{"links counted separately", []arrival{
+			{sender, sign(&NEW_ROUND{RoundID: 0}, senderPriv, sessionID, 0), true},
+			{other, sign(&NEW_ROUND{RoundID: 0}, otherPriv, sessionID, 0), true},
+		}},
+		{"another session", []arrival{{sender, sign(&NEW_ROUND{}, senderPriv, []byte("another session"), 0), false}}},
+		{"signed by another node", []arrival{{sender, sign(&NEW_ROUND{}, otherPriv, sessionID, 0), false}}},
+		{"tampered", []arrival{{sender, tampered, false}}},
+		{"unsigned", []arrival{{sender, unsigned, false}}},
+		{"rejected message does not count", []arrival{
+			{sender, sign(&NEW_ROUND{}, otherPriv, sessionID, 0), false},
+			{sender, sign(&NEW_ROUND{}, senderPriv, sessionID, 0), true},
+		}},
+	}
+	for _, test := range tests {
+		s := newAuthState(sessionID)
+		for k, a := range test.arrivals {
+			if err := s.check(a.from, a.msg); (err == nil) != a.ok {
+				t.Errorf("%s: message %d got error %v", test.name, k, err)
+			}
+		}
+	}
+}
+
+func TestVerifyForwarded(t *testing.T) {
+	suite := config.CryptoSuite
+	sessionID := []byte("session")
+	priv := suite.Scalar().Pick(suite.RandomStream())
+	signer := &network.ServerIdentity{Public: suite.Point().Mul(priv, nil)}
+	outsider := &network.ServerIdentity{Public: suite.Point().Pick(suite.RandomStream())}
+
+	//the sequence number belongs to the link of the signer, not to the one of the receiver
+	signed, err := signMessage(&PUBLIC_KEY{Key: signer.Public}, priv, sessionID, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := signed.(*PUBLIC_KEY)
+	if err := verifyForwarded(signer, msg, sessionID); err != nil {
+		t.Error("valid message rejected:", err)
+	}
+	if verifyForwarded(outsider, msg, sessionID) == nil {
+		t.Error("message accepted from another signer")
+	}
+	if verifyForwarded(signer, msg, []byte("another session")) == nil {
+		t.Error("message accepted in another session")
+	}
+	msg.Key = outsider.Public
+	if verifyForwarded(signer, msg, sessionID) == nil {
+		t.Error("tampered message accepted")
+	}
+}
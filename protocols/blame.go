@@ -0,0 +1,571 @@
+package protocols
+
+// This file contains the accusation and blame protocol, used when DisruptionProtectionEnabled is set.
+//
+// A slot owner which sees one of its bits flipped from 0 to 1 sends an accusation, signed with
+// its pseudonym key, in its next slot. Client0 then asks every node to reveal its pad bits at
+// that position. Each node's ciphertext bit must equal the XOR of its pad bits; if a client
+// and a trustee disagree on their shared pad bit, the trustee reveals their shared secret with
+// a proof of correctness. The evidence is published to every node, which re-checks it. Client0 is
+// not trusted, so the evidence is made of the ciphertexts, pad bits and secret of the other nodes,
+// as signed by them. If no disruptor is found, Client0 tells every node that the blame failed.
+//
+// The pad bits and secrets would let Client0 find the owner of any slot, so the nodes only reveal
+// them when asked for: a BLAME_REQUEST carries the accusation, which every node checks against the
+// pseudonym keys, and a BLAME_SECRET_REQUEST carries the pad bits signed by the client, which must
+// disagree with the trustee's own bit. A trustee reveals at most one secret per blame.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/kyber.v2/proof/dleq"
+	"gopkg.in/dedis/kyber.v2/sign/schnorr"
+	"gopkg.in/dedis/onet.v2/log"
+)
+
+// blameHistoryLength is the number of past rounds Client0 keeps to be able to answer an accusation
+const blameHistoryLength = 10
+
+// accusationHeaderSize is the size of the round ID and bit position at the beginning of an accusation
+const accusationHeaderSize = 8
+
+var errSecretNeeded = errors.New("a client and a trustee disagree, their shared secret is needed")
+
+// blameState holds the pad bits revealed during a blame, on Client0
+type blameState struct {
+	evidence    BlameEvidence
+	clientBits  map[int]BLAME_BITS
+	trusteeBits map[int]BLAME_BITS
+}
+
+// blameAnswer is the blame a node revealed its pad bits for
+type blameAnswer struct {
+	roundID        int
+	bitPos         int
+	secretRevealed bool // only used by trustees
+}
+
+// getBit returns the bit at position pos in buf (most significant bit first)
+func getBit(buf []byte, pos int) byte {
+	return (buf[pos/8] >> uint(7-pos%8)) & 1
+}
+
+// padBit returns the bit at position bitPos of the pad derived from a shared secret for the given round
+func padBit(secret kyber.Point, roundID, bitPos int) (byte, error) {
+	pad := make([]byte, bitPos/8+1)
+	if err := xorPad(pad, secret, roundID); err != nil {
+		return 0, err
+	}
+	return getBit(pad, bitPos), nil
+}
+
+// accusationMessage is the message signed by the accuser
+func accusationMessage(roundID, bitPos int) []byte {
+	msg := make([]byte, accusationHeaderSize)
+	binary.BigEndian.PutUint32(msg[0:4], uint32(roundID))
+	binary.BigEndian.PutUint32(msg[4:8], uint32(bitPos))
+	return append([]byte("accusation"), msg...)
+}
+
+// newAccusation creates an accusation for the given bit, signed with our pseudonym key
+func (p *DissentProtocol) newAccusation(roundID, bitPos int) ([]byte, error) {
+	sig, err := schnorr.Sign(config.CryptoSuite, p.pseudonymPriv, accusationMessage(roundID, bitPos))
+	if err != nil {
+		return nil, err
+	}
+	accusation := make([]byte, accusationHeaderSize, accusationHeaderSize+len(sig))
+	binary.BigEndian.PutUint32(accusation[0:4], uint32(roundID))
+	binary.BigEndian.PutUint32(accusation[4:8], uint32(bitPos))
+	return append(accusation, sig...), nil
+}
+
+// verifyAccusation checks that an accusation found in a slot is signed by the owner of that slot, and
+// that it accuses a bit of that slot. It returns the accused round and bit position.
+func verifyAccusation(accusation []byte, slot int, pseudonymKeys []kyber.Point, payloadSize int) (int, int, error) {
+	sigSize := config.CryptoSuite.PointLen() + config.CryptoSuite.ScalarLen()
+	if len(accusation) < accusationHeaderSize+sigSize {
+		return -1, -1, errors.New("accusation too short")
+	}
+	if slot < 0 || slot >= len(pseudonymKeys) {
+		return -1, -1, errors.New("accusation in unknown slot " + strconv.Itoa(slot))
+	}
+	roundID := int(binary.BigEndian.Uint32(accusation[0:4]))
+	bitPos := int(binary.BigEndian.Uint32(accusation[4:8]))
+	sig := accusation[accusationHeaderSize : accusationHeaderSize+sigSize]
+
+	if err := schnorr.Verify(config.CryptoSuite, pseudonymKeys[slot], accusationMessage(roundID, bitPos), sig); err != nil {
+		return -1, -1, errors.New("invalid accusation signature: " + err.Error())
+	}
+	if bitPos < slot*payloadSize*8 || bitPos >= (slot+1)*payloadSize*8 {
+		return -1, -1, errors.New("accusation of bit " + strconv.Itoa(bitPos) + " outside of the accuser's slot")
+	}
+	return roundID, bitPos, nil
+}
+
+// checkMySlot is called on clients when a round output arrives; if our slot was corrupted, it prepares an accusation
+func (p *DissentProtocol) checkMySlot(roundID int, slots [][]byte) {
+	sent, ok := p.sentSlots[roundID]
+	if !ok || p.mySlot() < 0 || p.mySlot() >= len(slots) {
+		return
+	}
+	delete(p.sentSlots, roundID)
+
+	received := slots[p.mySlot()]
+	if bytes.Equal(sent, received) {
+		return
+	}
+
+	//we can only accuse a bit we sent as 0, otherwise our own ciphertext bit would look wrong
+	for pos := 0; pos < len(sent)*8; pos++ {
+		if getBit(sent, pos) == 0 && getBit(received, pos) == 1 {
+			bitPos := p.mySlot()*p.config.Toml.PayloadSize*8 + pos
+			accusation, err := p.newAccusation(roundID, bitPos)
+			if err != nil {
+				log.Error("Could not create an accusation:", err)
+				return
+			}
+			log.Lvl1("Our slot was disrupted in round", roundID, ", accusing bit", bitPos)
+			p.pendingAccusation = accusation
+			return
+		}
+	}
+	log.Error("Our slot was disrupted in round", roundID, ", but no bit was flipped from 0 to 1, cannot accuse.")
+}
+
+// keepForBlame stores a decoded round on Client0, forgetting the oldest ones
+func (p *DissentProtocol) keepForBlame(roundID int, round *roundState) {
+	p.pastRounds[roundID] = round
+	delete(p.pastRounds, roundID-blameHistoryLength)
+}
+
+// checkAccusations is called on Client0 on each decoded round; it starts a blame if a slot contains a valid accusation
+func (p *DissentProtocol) checkAccusations(slots [][]byte) {
+	for slot, content := range slots {
+		if len(content) == 0 || content[0] != slotAccusation {
+			continue
+		}
+		roundID, bitPos, err := verifyAccusation(content[slotHeaderSize:], slot, p.pseudonymKeys, p.config.Toml.PayloadSize)
+		if err != nil {
+			log.Error("Ignoring an invalid accusation in slot", slot, ":", err)
+			continue
+		}
+		round, ok := p.pastRounds[roundID]
+		if !ok || p.compactBit(round.layout, bitPos) < 0 || getBit(round.cleartext, p.compactBit(round.layout, bitPos)) != 1 {
+			log.Error("Ignoring an accusation for round", roundID, "bit", bitPos, ": round unknown or bit not set")
+			continue
+		}
+		if p.blame != nil {
+			log.Lvl2("A blame is already running, ignoring the accusation in slot", slot)
+			continue
+		}
+		p.startBlame(roundID, bitPos, slot, content[slotHeaderSize:])
+	}
+}
+
+// startBlame asks every node to reveal its pad bits for the accused bit
+func (p *DissentProtocol) startBlame(roundID, bitPos, slot int, accusation []byte) {
+	round := p.pastRounds[roundID]
+	evidence := BlameEvidence{
+		RoundID:           roundID,
+		BitPos:            bitPos,
+		Slot:              slot,
+		Accusation:        accusation,
+		ClientCiphers:     make([]CLIENT_CIPHER, p.nClients),
+		TrusteeCiphers:    make([]TRUSTEE_CIPHER, p.nTrustees),
+		RevealedTrusteeID: -1,
+	}
+	//the evidence needs the signed ciphertext of every node
+	for i := range evidence.ClientCiphers {
+		cipher, ok := round.clientSigned[i]
+		if !ok {
+			log.Error("Cannot blame round", roundID, ", the ciphertext of client", i, "is missing (excluded client)")
+			return
+		}
+		evidence.ClientCiphers[i] = *cipher
+	}
+	for j := range evidence.TrusteeCiphers {
+		cipher, ok := round.trusteeSigned[j]
+		if !ok {
+			log.Error("Cannot blame round", roundID, ", the ciphertext of trustee", j, "is missing")
+			return
+		}
+		evidence.TrusteeCiphers[j] = *cipher
+	}
+
+	log.Lvl1("Client0 : valid accusation for round", roundID, "bit", bitPos, ", starting the blame.")
+	if err := p.setState(StateBlame); err != nil {
+		return
+	}
+	p.blame = &blameState{
+		evidence:    evidence,
+		clientBits:  make(map[int]BLAME_BITS),
+		trusteeBits: make(map[int]BLAME_BITS),
+	}
+
+	message := &BLAME_REQUEST{RoundID: roundID, BitPos: bitPos, Slot: slot, Accusation: accusation}
+	for i := range p.ms.clients {
+		p.ms.SendToClient(i, message)
+	}
+	for i := range p.ms.trustees {
+		p.ms.SendToTrustee(i, message)
+	}
+}
+
+// checkBlameRequest is called on every node before revealing its pad bits; the request must carry a
+// valid accusation of that very bit
+func (p *DissentProtocol) checkBlameRequest(msg *BLAME_REQUEST) error {
+	roundID, bitPos, err := verifyAccusation(msg.Accusation, msg.Slot, p.pseudonymKeys, p.config.Toml.PayloadSize)
+	if err != nil {
+		return err
+	}
+	if roundID != msg.RoundID || bitPos != msg.BitPos {
+		return errors.New("the accusation is for round " + strconv.Itoa(roundID) + " bit " + strconv.Itoa(bitPos))
+	}
+	return nil
+}
+
+// checkSecretRequest is called on trustees before revealing a shared secret; the request must be for the
+// blame we answered, and carry the pad bits signed by the client, which disagree with our own bit
+func (p *DissentProtocol) checkSecretRequest(msg *BLAME_SECRET_REQUEST) error {
+	a := p.answeredBlame
+	if a == nil || a.roundID != msg.RoundID || a.bitPos != msg.BitPos {
+		return errors.New("we did not reveal our pad bits for this bit")
+	}
+	if a.secretRevealed {
+		return errors.New("a secret was already revealed in this blame")
+	}
+	node, ok := p.ms.clients[msg.ClientID]
+	if !ok || msg.ClientID < 0 || msg.ClientID >= len(p.sharedSecrets) {
+		return errors.New("unknown client " + strconv.Itoa(msg.ClientID))
+	}
+
+	bits := &msg.ClientBits
+	if err := verifyForwarded(node.ServerIdentity, bits, p.ms.auth.sessionID); err != nil {
+		return errors.New("the pad bits of client " + strconv.Itoa(msg.ClientID) + ": " + err.Error())
+	}
+	if bits.RoundID != msg.RoundID || bits.BitPos != msg.BitPos || len(bits.PadBits) != p.nTrustees {
+		return errors.New("the pad bits of client " + strconv.Itoa(msg.ClientID) + " are for another bit")
+	}
+	mine, err := padBit(p.sharedSecrets[msg.ClientID], msg.RoundID, msg.BitPos)
+	if err != nil {
+		return err
+	}
+	if bits.PadBits[p.myID] == mine {
+		return errors.New("client " + strconv.Itoa(msg.ClientID) + " agrees with our pad bit")
+	}
+	return nil
+}
+
+// revealPadBits returns our pad bit with each peer for the given round and position
+func (p *DissentProtocol) revealPadBits(roundID, bitPos int) ([]byte, error) {
+	bits := make([]byte, len(p.sharedSecrets))
+	for i, secret := range p.sharedSecrets {
+		bit, err := padBit(secret, roundID, bitPos)
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = bit
+	}
+	return bits, nil
+}
+
+// collectBlameBits stores the signed BLAME_BITS of a node, and returns true once every node revealed its bits
+func (b *blameState) collectBlameBits(role DissentRole, id int, bits BLAME_BITS, nClients, nTrustees int) bool {
+	switch role {
+	case Client:
+		b.clientBits[id] = bits
+	case Trustee:
+		b.trusteeBits[id] = bits
+	}
+	if len(b.clientBits) != nClients || len(b.trusteeBits) != nTrustees {
+		return false
+	}
+
+	b.evidence.ClientBits = make([]BLAME_BITS, nClients)
+	for i := range b.evidence.ClientBits {
+		b.evidence.ClientBits[i] = b.clientBits[i]
+	}
+	b.evidence.TrusteeBits = make([]BLAME_BITS, nTrustees)
+	for j := range b.evidence.TrusteeBits {
+		b.evidence.TrusteeBits[j] = b.trusteeBits[j]
+	}
+	return true
+}
+
+// blameBits holds the bits of a blame, once extracted from the signed messages of the evidence
+type blameBits struct {
+	roundID           int
+	bitPos            int
+	clientBits        [][]byte // clientBits[i][j] is the pad bit revealed by client i for trustee j
+	trusteeBits       [][]byte // trusteeBits[j][i] is the pad bit revealed by trustee j for client i
+	clientCipherBits  []byte
+	trusteeCipherBits []byte
+	revealedClientID  int
+	revealedTrusteeID int
+	revealedSecret    kyber.Point
+	revealedProof     *dleq.Proof
+}
+
+// extractBlameBits checks that every message of the evidence was signed by the node at its position
+// in this session and belongs to the accused round and bit, and returns the bits they contain
+func (p *DissentProtocol) extractBlameBits(e *BlameEvidence) (*blameBits, error) {
+	if len(e.ClientBits) != p.nClients || len(e.TrusteeBits) != p.nTrustees ||
+		len(e.ClientCiphers) != p.nClients || len(e.TrusteeCiphers) != p.nTrustees {
+		return nil, errors.New("malformed blame evidence")
+	}
+	sessionID := p.ms.auth.sessionID
+	b := &blameBits{
+		roundID:           e.RoundID,
+		bitPos:            e.BitPos,
+		clientBits:        make([][]byte, p.nClients),
+		trusteeBits:       make([][]byte, p.nTrustees),
+		clientCipherBits:  make([]byte, p.nClients),
+		trusteeCipherBits: make([]byte, p.nTrustees),
+		revealedClientID:  -1,
+		revealedTrusteeID: -1,
+	}
+
+	for i := 0; i < p.nClients; i++ {
+		node, ok := p.ms.clients[i]
+		if !ok {
+			return nil, errors.New("unknown client " + strconv.Itoa(i))
+		}
+		bits := &e.ClientBits[i]
+		if err := verifyForwarded(node.ServerIdentity, bits, sessionID); err != nil {
+			return nil, errors.New("the pad bits of client " + strconv.Itoa(i) + ": " + err.Error())
+		}
+		if bits.RoundID != e.RoundID || bits.BitPos != e.BitPos {
+			return nil, errors.New("the pad bits of client " + strconv.Itoa(i) + " are for another bit")
+		}
+		b.clientBits[i] = bits.PadBits
+
+		cipher := &e.ClientCiphers[i]
+		if err := verifyForwarded(node.ServerIdentity, cipher, sessionID); err != nil {
+			return nil, errors.New("the ciphertext of client " + strconv.Itoa(i) + ": " + err.Error())
+		}
+		layout, err := p.layoutFromMessage(&NEW_ROUND{Reservation: cipher.Reservation, OpenSlots: cipher.OpenSlots})
+		if err != nil || cipher.RoundID != e.RoundID || layout.reservation || len(cipher.Cipher) != p.layoutSize(layout) {
+			return nil, errors.New("the ciphertext of client " + strconv.Itoa(i) + " is not from the accused round")
+		}
+		pos := p.compactBit(layout, e.BitPos)
+		if pos < 0 {
+			return nil, errors.New("the ciphertext of client " + strconv.Itoa(i) + " does not carry the accused bit")
+		}
+		b.clientCipherBits[i] = getBit(cipher.Cipher, pos)
+	}
+
+	for j := 0; j < p.nTrustees; j++ {
+		node, ok := p.ms.trustees[j]
+		if !ok {
+			return nil, errors.New("unknown trustee " + strconv.Itoa(j))
+		}
+		bits := &e.TrusteeBits[j]
+		if err := verifyForwarded(node.ServerIdentity, bits, sessionID); err != nil {
+			return nil, errors.New("the pad bits of trustee " + strconv.Itoa(j) + ": " + err.Error())
+		}
+		if bits.RoundID != e.RoundID || bits.BitPos != e.BitPos {
+			return nil, errors.New("the pad bits of trustee " + strconv.Itoa(j) + " are for another bit")
+		}
+		b.trusteeBits[j] = bits.PadBits
+
+		cipher := &e.TrusteeCiphers[j]
+		if err := verifyForwarded(node.ServerIdentity, cipher, sessionID); err != nil {
+			return nil, errors.New("the ciphertext of trustee " + strconv.Itoa(j) + ": " + err.Error())
+		}
+		if cipher.RoundID != e.RoundID || e.BitPos < 0 || e.BitPos >= len(cipher.Cipher)*8 {
+			return nil, errors.New("the ciphertext of trustee " + strconv.Itoa(j) + " is not from the accused round")
+		}
+		b.trusteeCipherBits[j] = getBit(cipher.Cipher, e.BitPos)
+	}
+
+	if secret := e.RevealedSecret; secret != nil {
+		j := e.RevealedTrusteeID
+		node, ok := p.ms.trustees[j]
+		if !ok {
+			return nil, errors.New("the secret was revealed by an unknown trustee " + strconv.Itoa(j))
+		}
+		if err := verifyForwarded(node.ServerIdentity, secret, sessionID); err != nil {
+			return nil, errors.New("the secret revealed by trustee " + strconv.Itoa(j) + ": " + err.Error())
+		}
+		if secret.RoundID != e.RoundID {
+			return nil, errors.New("the secret was revealed for another round")
+		}
+		b.revealedClientID = secret.ClientID
+		b.revealedTrusteeID = j
+		b.revealedSecret = secret.Secret
+		b.revealedProof = secret.Proof
+	}
+	return b, nil
+}
+
+// disagreement returns the first client-trustee pair which revealed different pad bits
+func disagreement(b *blameBits) (int, int, bool) {
+	for i := range b.clientBits {
+		for j := range b.trusteeBits {
+			if b.clientBits[i][j] != b.trusteeBits[j][i] {
+				return i, j, true
+			}
+		}
+	}
+	return -1, -1, false
+}
+
+// findDisruptor returns the role and ID of the node which disrupted the accused bit, given the bits of the evidence
+func findDisruptor(b *blameBits, clientKeys, trusteeKeys []kyber.Point) (DissentRole, int, error) {
+	nClients, nTrustees := len(clientKeys), len(trusteeKeys)
+	if len(b.clientBits) != nClients || len(b.trusteeBits) != nTrustees ||
+		len(b.clientCipherBits) != nClients || len(b.trusteeCipherBits) != nTrustees {
+		return Client, -1, errors.New("malformed blame evidence")
+	}
+	for i := range b.clientBits {
+		if len(b.clientBits[i]) != nTrustees {
+			return Client, -1, errors.New("malformed blame evidence")
+		}
+	}
+	for j := range b.trusteeBits {
+		if len(b.trusteeBits[j]) != nClients {
+			return Client, -1, errors.New("malformed blame evidence")
+		}
+	}
+
+	//if a client and a trustee disagree, the revealed secret tells who lied
+	if i, j, found := disagreement(b); found {
+		if b.revealedClientID != i || b.revealedTrusteeID != j || b.revealedSecret == nil || b.revealedProof == nil {
+			return Client, -1, errSecretNeeded
+		}
+		suite := config.CryptoSuite
+		if err := b.revealedProof.Verify(suite, suite.Point().Base(), clientKeys[i], trusteeKeys[j], b.revealedSecret); err != nil {
+			return Trustee, j, nil
+		}
+		bit, err := padBit(b.revealedSecret, b.roundID, b.bitPos)
+		if err != nil {
+			return Client, -1, err
+		}
+		if bit != b.clientBits[i][j] {
+			return Client, i, nil
+		}
+		return Trustee, j, nil
+	}
+
+	//otherwise, someone's ciphertext bit is not the XOR of its pad bits
+	for i, bits := range b.clientBits {
+		var xor byte
+		for _, bit := range bits {
+			xor ^= bit
+		}
+		if xor != b.clientCipherBits[i] {
+			return Client, i, nil
+		}
+	}
+	for j, bits := range b.trusteeBits {
+		var xor byte
+		for _, bit := range bits {
+			xor ^= bit
+		}
+		if xor != b.trusteeCipherBits[j] {
+			return Trustee, j, nil
+		}
+	}
+	return Client, -1, errors.New("no disruptor found, the accusation is invalid")
+}
+
+// verifyBlameEvidence is called by every node on a BLAME_VERDICT, to check the verdict of Client0
+func (p *DissentProtocol) verifyBlameEvidence(e *BlameEvidence) error {
+	roundID, bitPos, err := verifyAccusation(e.Accusation, e.Slot, p.pseudonymKeys, p.config.Toml.PayloadSize)
+	if err != nil {
+		return err
+	}
+	if roundID != e.RoundID || bitPos != e.BitPos {
+		return errors.New("the evidence does not match the accusation")
+	}
+
+	b, err := p.extractBlameBits(e)
+	if err != nil {
+		return err
+	}
+	var output byte
+	for _, bit := range b.clientCipherBits {
+		output ^= bit
+	}
+	for _, bit := range b.trusteeCipherBits {
+		output ^= bit
+	}
+	if output != 1 {
+		return errors.New("the accused bit was not flipped")
+	}
+
+	role, id, err := findDisruptor(b, p.clientKeys, p.trusteeKeys)
+	if err != nil {
+		return err
+	}
+	if role != e.DisruptorRole || id != e.DisruptorID {
+		return errors.New("the evidence designates another disruptor")
+	}
+	return nil
+}
+
+// tryFinishBlame is called on Client0 once all bits are revealed; it either asks for a shared secret, or
+// publishes the verdict. If no disruptor can be found, every node is told that the blame failed.
+func (p *DissentProtocol) tryFinishBlame() error {
+	e := &p.blame.evidence
+
+	b, err := p.extractBlameBits(e)
+	role, id := Client, -1
+	if err == nil {
+		role, id, err = findDisruptor(b, p.clientKeys, p.trusteeKeys)
+	}
+	if err == errSecretNeeded {
+		i, j, _ := disagreement(b)
+		log.Lvl2("Client", i, "and trustee", j, "disagree, asking the trustee to reveal their secret.")
+		request := &BLAME_SECRET_REQUEST{RoundID: e.RoundID, BitPos: e.BitPos, ClientID: i, ClientBits: e.ClientBits[i]}
+		return p.ms.SendToTrustee(j, request)
+	}
+	if err != nil {
+		log.Error("Blame failed:", err)
+		p.blame = nil
+		//we are client 0, so we leave the blame when receiving our own BLAME_FAILED, as everyone
+		message := &BLAME_FAILED{RoundID: e.RoundID, Reason: err.Error()}
+		for i := range p.ms.clients {
+			p.ms.SendToClient(i, message)
+		}
+		for i := range p.ms.trustees {
+			p.ms.SendToTrustee(i, message)
+		}
+		return err
+	}
+
+	e.DisruptorRole = role
+	e.DisruptorID = id
+	log.Error("Client0 : the disruptor of round", e.RoundID, "is", roleName(role), id)
+
+	message := &BLAME_VERDICT{Evidence: *e}
+	for i := range p.ms.clients {
+		p.ms.SendToClient(i, message)
+	}
+	for i := range p.ms.trustees {
+		p.ms.SendToTrustee(i, message)
+	}
+
+	disruptor := p.ms.serverIdentity(role, id)
+	p.blame = nil
+	if p.blameHandler != nil && disruptor != nil {
+		go p.blameHandler(disruptor)
+	}
+	return nil
+}
+
+// roleName returns a printable name for a role
+func roleName(role DissentRole) string {
+	switch role {
+	case Client0:
+		return "client0"
+	case Trustee:
+		return "trustee"
+	}
+	return "client"
+}
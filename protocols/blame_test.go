@@ -0,0 +1,402 @@
+package protocols
+
+import (
+	"testing"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/kyber.v2/proof/dleq"
+	"gopkg.in/dedis/onet.v2"
+	"gopkg.in/dedis/onet.v2/network"
+)
+
+// honestBits returns the bits of a blame of 3 clients and 2 trustees where everyone is honest: each
+// ciphertext bit is the XOR of the pad bits, and each client-trustee pair agrees on its pad bit
+func honestBits() *blameBits {
+	b := &blameBits{
+		roundID:           5,
+		bitPos:            11,
+		clientBits:        [][]byte{{1, 0}, {0, 0}, {1, 1}},
+		trusteeBits:       [][]byte{{1, 0, 1}, {0, 0, 1}},
+		revealedClientID:  -1,
+		revealedTrusteeID: -1,
+	}
+	b.clientCipherBits = []byte{1, 0, 0}
+	b.trusteeCipherBits = []byte{0, 1}
+	return b
+}
+
+func TestFindDisruptor(t *testing.T) {
+	clientKeys := make([]kyber.Point, 3)
+	trusteeKeys := make([]kyber.Point, 2)
+	tests := []struct {
+		name   string
+		tamper func(b *blameBits)
+		role   DissentRole
+		id     int
+		err    bool
+	}{
+		{"nobody disrupted", func(b *blameBits) {}, Client, -1, true},
+		{"client ciphertext", func(b *blameBits) { b.clientCipherBits[1] ^= 1 }, Client, 1, false},
+		{"trustee ciphertext", func(b *blameBits) { b.trusteeCipherBits[1] ^= 1 }, Trustee, 1, false},
+		{"client and trustee ciphertexts", func(b *blameBits) {
+			b.clientCipherBits[2] ^= 1
+			b.trusteeCipherBits[0] ^= 1
+		}, Client, 2, false},
+		{"client lies on its pad bits to hide a flip", func(b *blameBits) {
+			b.clientCipherBits[0] ^= 1
+			b.clientBits[0][1] ^= 1
+		}, Client, -1, true},
+		{"missing client", func(b *blameBits) { b.clientBits = b.clientBits[:2] }, Client, -1, true},
+		{"missing pad bit", func(b *blameBits) { b.trusteeBits[1] = b.trusteeBits[1][:2] }, Client, -1, true},
+		{"missing ciphertext bit", func(b *blameBits) { b.trusteeCipherBits = b.trusteeCipherBits[:1] }, Client, -1, true},
+	}
+	for _, test := range tests {
+		b := honestBits()
+		test.tamper(b)
+		role, id, err := findDisruptor(b, clientKeys, trusteeKeys)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: found %s %d, expected an error", test.name, roleName(role), id)
+			}
+			continue
+		}
+		if err != nil || role != test.role || id != test.id {
+			t.Errorf("%s: found %s %d (%v), expected %s %d", test.name, roleName(role), id, err, roleName(test.role), test.id)
+		}
+	}
+
+	//a disagreement cannot be settled without the secret of that pair
+	b := honestBits()
+	b.clientCipherBits[0] ^= 1
+	b.clientBits[0][1] ^= 1
+	if _, _, err := findDisruptor(b, clientKeys, trusteeKeys); err != errSecretNeeded {
+		t.Errorf("disagreement: got %v, expected errSecretNeeded", err)
+	}
+}
+
+func TestFindDisruptorWithSecret(t *testing.T) {
+	suite := config.CryptoSuite
+	clientPriv := suite.Scalar().Pick(suite.RandomStream())
+	trusteePriv := suite.Scalar().Pick(suite.RandomStream())
+	clientKeys := []kyber.Point{suite.Point().Mul(clientPriv, nil)}
+	trusteeKeys := []kyber.Point{suite.Point().Mul(trusteePriv, nil)}
+	proof, _, secret, err := dleq.NewDLEQProof(suite, suite.Point().Base(), clientKeys[0], trusteePriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bit, err := padBit(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSecret := suite.Point().Pick(suite.RandomStream())
+
+	tests := []struct {
+		name        string
+		clientBit   byte
+		trusteeBit  byte
+		secret      kyber.Point
+		revealedFor int // the client the secret was revealed for
+		role        DissentRole
+		id          int
+		err         error
+	}{
+		{"client lied", bit ^ 1, bit, secret, 0, Client, 0, nil},
+		{"trustee lied", bit, bit ^ 1, secret, 0, Trustee, 0, nil},
+		{"trustee revealed a wrong secret", bit ^ 1, bit, otherSecret, 0, Trustee, 0, nil},
+		{"secret of another pair", bit ^ 1, bit, secret, 1, Client, -1, errSecretNeeded},
+		{"no secret", bit ^ 1, bit, nil, 0, Client, -1, errSecretNeeded},
+	}
+	for _, test := range tests {
+		b := &blameBits{
+			roundID:           5,
+			bitPos:            3,
+			clientBits:        [][]byte{{test.clientBit}},
+			trusteeBits:       [][]byte{{test.trusteeBit}},
+			clientCipherBits:  []byte{test.clientBit},
+			trusteeCipherBits: []byte{test.trusteeBit},
+			revealedClientID:  test.revealedFor,
+			revealedTrusteeID: 0,
+			revealedSecret:    test.secret,
+			revealedProof:     proof,
+		}
+		role, id, err := findDisruptor(b, clientKeys, trusteeKeys)
+		if err != test.err || (err == nil && (role != test.role || id != test.id)) {
+			t.Errorf("%s: found %s %d (%v), expected %s %d (%v)", test.name, roleName(role), id, err,
+				roleName(test.role), test.id, test.err)
+		}
+	}
+}
+
+// blameFixture is a session of 2 clients and 1 trustee where client 0 disrupted bit 3 of slot 1 in round 5
+type blameFixture struct {
+	p            *DissentProtocol
+	sessionID    []byte
+	clientPriv   []kyber.Scalar
+	trusteePriv  []kyber.Scalar
+	outsiderPriv kyber.Scalar // a key which is not in the session, e.g. Client0 forging a message
+}
+
+const (
+	fixtureRound  = 5
+	fixtureBitPos = 8 + 3 // PayloadSize is 1 byte, and the accuser owns slot 1
+)
+
+func newBlameFixture(t *testing.T) *blameFixture {
+	suite := config.CryptoSuite
+	pick := func() (kyber.Scalar, kyber.Point) {
+		priv := suite.Scalar().Pick(suite.RandomStream())
+		return priv, suite.Point().Mul(priv, nil)
+	}
+	f := &blameFixture{sessionID: []byte("session")}
+	clients := make(map[int]*onet.TreeNode)
+	trustees := make(map[int]*onet.TreeNode)
+	clientKeys := make([]kyber.Point, 2)
+	trusteeKeys := make([]kyber.Point, 1)
+	for i := range clientKeys {
+		priv, pub := pick()
+		f.clientPriv = append(f.clientPriv, priv)
+		clients[i] = &onet.TreeNode{ServerIdentity: &network.ServerIdentity{Public: pub}}
+		_, clientKeys[i] = pick()
+	}
+	for j := range trusteeKeys {
+		priv, pub := pick()
+		f.trusteePriv = append(f.trusteePriv, priv)
+		trustees[j] = &onet.TreeNode{ServerIdentity: &network.ServerIdentity{Public: pub}}
+		_, trusteeKeys[j] = pick()
+	}
+	f.outsiderPriv, _ = pick()
+
+	pseudonymPriv, pseudonymPub := pick()
+	_, otherPseudonym := pick()
+
+	f.p = &DissentProtocol{
+		nClients:      2,
+		nTrustees:     1,
+		config:        DissentProtocolConfig{Toml: &DissentTomlConfig{PayloadSize: 1}},
+		clientKeys:    clientKeys,
+		trusteeKeys:   trusteeKeys,
+		pseudonymPriv: pseudonymPriv,
+		pseudonymKeys: []kyber.Point{otherPseudonym, pseudonymPub},
+		ms:            MessageSender{clients: clients, trustees: trustees, auth: newAuthState(f.sessionID)},
+	}
+	return f
+}
+
+// sign returns a copy of a message signed by the given key for this session
+func (f *blameFixture) sign(t *testing.T, msg interface{}, priv kyber.Scalar) interface{} {
+	signed, err := signMessage(msg, priv, f.sessionID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// secret returns the secret of client 0 and trustee 0 with its proof, signed by the given key
+func (f *blameFixture) secret(t *testing.T, priv kyber.Scalar) *BLAME_SECRET {
+	suite := config.CryptoSuite
+	proof, _, secret, err := dleq.NewDLEQProof(suite, suite.Point().Base(), f.p.clientKeys[0], f.trusteePriv[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &BLAME_SECRET{RoundID: fixtureRound, ClientID: 0, Secret: secret, Proof: proof}
+	return f.sign(t, msg, priv).(*BLAME_SECRET)
+}
+
+// cipherWithBit returns a ciphertext of the full cell with the accused bit set to the given value
+func cipherWithBit(bit byte) []byte {
+	cipher := make([]byte, 2)
+	if bit == 1 {
+		flipBit(cipher, fixtureBitPos)
+	}
+	return cipher
+}
+
+// clientCipher returns the ciphertext of a client, signed by it, with the accused bit set to the given value
+func (f *blameFixture) clientCipher(t *testing.T, i int, bit byte) CLIENT_CIPHER {
+	msg := &CLIENT_CIPHER{RoundID: fixtureRound, OpenSlots: []int{0, 1}, Cipher: cipherWithBit(bit)}
+	return *f.sign(t, msg, f.clientPriv[i]).(*CLIENT_CIPHER)
+}
+
+// evidence returns the valid evidence against client 0: the pads of client 0, client 1 and the trustee
+// are 1, 0 and 1, so the honest output bit is 0, but client 0 sent 0 instead of 1
+func (f *blameFixture) evidence(t *testing.T) *BlameEvidence {
+	accusation, err := f.p.newAccusation(fixtureRound, fixtureBitPos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits := func(pads []byte, priv kyber.Scalar) BLAME_BITS {
+		msg := &BLAME_BITS{RoundID: fixtureRound, BitPos: fixtureBitPos, PadBits: pads}
+		return *f.sign(t, msg, priv).(*BLAME_BITS)
+	}
+	trusteeCipher := &TRUSTEE_CIPHER{RoundID: fixtureRound, Cipher: cipherWithBit(1)}
+	return &BlameEvidence{
+		RoundID:           fixtureRound,
+		BitPos:            fixtureBitPos,
+		Slot:              1,
+		Accusation:        accusation,
+		ClientBits:        []BLAME_BITS{bits([]byte{1}, f.clientPriv[0]), bits([]byte{0}, f.clientPriv[1])},
+		TrusteeBits:       []BLAME_BITS{bits([]byte{1, 0}, f.trusteePriv[0])},
+		ClientCiphers:     []CLIENT_CIPHER{f.clientCipher(t, 0, 0), f.clientCipher(t, 1, 0)},
+		TrusteeCiphers:    []TRUSTEE_CIPHER{*f.sign(t, trusteeCipher, f.trusteePriv[0]).(*TRUSTEE_CIPHER)},
+		RevealedTrusteeID: -1,
+		DisruptorRole:     Client,
+		DisruptorID:       0,
+	}
+}
+
+func TestVerifyBlameEvidence(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(f *blameFixture, e *BlameEvidence)
+		ok     bool
+	}{
+		{"valid", func(f *blameFixture, e *BlameEvidence) {}, true},
+		{"another disruptor", func(f *blameFixture, e *BlameEvidence) { e.DisruptorID = 1 }, false},
+		{"a trustee as disruptor", func(f *blameFixture, e *BlameEvidence) {
+			e.DisruptorRole, e.DisruptorID = Trustee, 0
+		}, false},
+		{"accusation of another bit", func(f *blameFixture, e *BlameEvidence) { e.BitPos++ }, false},
+		{"accusation in another slot", func(f *blameFixture, e *BlameEvidence) { e.Slot = 0 }, false},
+		{"pad bits modified after signing", func(f *blameFixture, e *BlameEvidence) {
+			e.ClientBits[1].PadBits = []byte{1}
+		}, false},
+		{"pad bits forged by Client0", func(f *blameFixture, e *BlameEvidence) {
+			forged := &BLAME_BITS{RoundID: fixtureRound, BitPos: fixtureBitPos, PadBits: []byte{0}}
+			e.ClientBits[0] = *f.sign(t, forged, f.outsiderPriv).(*BLAME_BITS)
+		}, false},
+		{"pad bits of another client", func(f *blameFixture, e *BlameEvidence) {
+			e.ClientBits[0], e.ClientBits[1] = e.ClientBits[1], e.ClientBits[0]
+		}, false},
+		{"ciphertext forged by Client0", func(f *blameFixture, e *BlameEvidence) {
+			forged := &CLIENT_CIPHER{RoundID: fixtureRound, OpenSlots: []int{0, 1}, Cipher: cipherWithBit(1)}
+			e.ClientCiphers[1] = *f.sign(t, forged, f.outsiderPriv).(*CLIENT_CIPHER)
+		}, false},
+		{"ciphertext of another session", func(f *blameFixture, e *BlameEvidence) {
+			cipher := &CLIENT_CIPHER{RoundID: fixtureRound, OpenSlots: []int{0, 1}, Cipher: cipherWithBit(0)}
+			other, err := signMessage(cipher, f.clientPriv[1], []byte("another session"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.ClientCiphers[1] = *other.(*CLIENT_CIPHER)
+		}, false},
+		{"ciphertext of another round", func(f *blameFixture, e *BlameEvidence) {
+			cipher := &CLIENT_CIPHER{RoundID: fixtureRound - 1, OpenSlots: []int{0, 1}, Cipher: cipherWithBit(0)}
+			e.ClientCiphers[1] = *f.sign(t, cipher, f.clientPriv[1]).(*CLIENT_CIPHER)
+		}, false},
+		{"layout without the accused slot", func(f *blameFixture, e *BlameEvidence) {
+			cipher := &CLIENT_CIPHER{RoundID: fixtureRound, OpenSlots: []int{0}, Cipher: []byte{0}}
+			e.ClientCiphers[1] = *f.sign(t, cipher, f.clientPriv[1]).(*CLIENT_CIPHER)
+		}, false},
+		{"bit not flipped", func(f *blameFixture, e *BlameEvidence) {
+			e.ClientCiphers[0] = f.clientCipher(t, 0, 1)
+		}, false},
+		{"missing pad bits", func(f *blameFixture, e *BlameEvidence) { e.TrusteeBits = nil }, false},
+		{"secret forged by Client0", func(f *blameFixture, e *BlameEvidence) {
+			e.RevealedTrusteeID = 0
+			e.RevealedSecret = f.secret(t, f.outsiderPriv)
+		}, false},
+		{"secret of an unknown trustee", func(f *blameFixture, e *BlameEvidence) {
+			e.RevealedTrusteeID = 1
+			e.RevealedSecret = f.secret(t, f.trusteePriv[0])
+		}, false},
+	}
+	for _, test := range tests {
+		f := newBlameFixture(t)
+		e := f.evidence(t)
+		test.tamper(f, e)
+		err := f.p.verifyBlameEvidence(e)
+		if test.ok && err != nil {
+			t.Errorf("%s: rejected: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
+
+func TestCheckBlameRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(f *blameFixture, r *BLAME_REQUEST)
+		ok     bool
+	}{
+		{"valid", func(f *blameFixture, r *BLAME_REQUEST) {}, true},
+		{"no accusation", func(f *blameFixture, r *BLAME_REQUEST) { r.Accusation = nil }, false},
+		{"another bit", func(f *blameFixture, r *BLAME_REQUEST) { r.BitPos++ }, false},
+		{"another round", func(f *blameFixture, r *BLAME_REQUEST) { r.RoundID++ }, false},
+		{"another slot", func(f *blameFixture, r *BLAME_REQUEST) { r.Slot = 0 }, false},
+		{"accusation forged by Client0", func(f *blameFixture, r *BLAME_REQUEST) {
+			f.p.pseudonymPriv = f.outsiderPriv
+			accusation, err := f.p.newAccusation(fixtureRound, fixtureBitPos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Accusation = accusation
+		}, false},
+	}
+	for _, test := range tests {
+		f := newBlameFixture(t)
+		e := f.evidence(t)
+		request := &BLAME_REQUEST{RoundID: e.RoundID, BitPos: e.BitPos, Slot: e.Slot, Accusation: e.Accusation}
+		test.tamper(f, request)
+		err := f.p.checkBlameRequest(request)
+		if test.ok && err != nil {
+			t.Errorf("%s: rejected: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
+
+func TestCheckSecretRequest(t *testing.T) {
+	suite := config.CryptoSuite
+	tests := []struct {
+		name   string
+		tamper func(f *blameFixture, r *BLAME_SECRET_REQUEST)
+		ok     bool
+	}{
+		{"valid", func(f *blameFixture, r *BLAME_SECRET_REQUEST) {}, true},
+		{"already revealed", func(f *blameFixture, r *BLAME_SECRET_REQUEST) { f.p.answeredBlame.secretRevealed = true }, false},
+		{"no blame answered", func(f *blameFixture, r *BLAME_SECRET_REQUEST) { f.p.answeredBlame = nil }, false},
+		{"another bit than the blame", func(f *blameFixture, r *BLAME_SECRET_REQUEST) { r.BitPos++ }, false},
+		{"unknown client", func(f *blameFixture, r *BLAME_SECRET_REQUEST) { r.ClientID = 2 }, false},
+		{"the bits of another client", func(f *blameFixture, r *BLAME_SECRET_REQUEST) { r.ClientID = 1 }, false},
+		{"client agrees", func(f *blameFixture, r *BLAME_SECRET_REQUEST) {
+			bits := &BLAME_BITS{RoundID: fixtureRound, BitPos: fixtureBitPos, PadBits: []byte{r.ClientBits.PadBits[0] ^ 1}}
+			r.ClientBits = *f.sign(t, bits, f.clientPriv[0]).(*BLAME_BITS)
+		}, false},
+		{"bits forged by Client0", func(f *blameFixture, r *BLAME_SECRET_REQUEST) {
+			r.ClientBits = *f.sign(t, &r.ClientBits, f.outsiderPriv).(*BLAME_BITS)
+		}, false},
+		{"bits of another round", func(f *blameFixture, r *BLAME_SECRET_REQUEST) {
+			bits := r.ClientBits
+			bits.RoundID--
+			r.ClientBits = *f.sign(t, &bits, f.clientPriv[0]).(*BLAME_BITS)
+		}, false},
+	}
+	for _, test := range tests {
+		//the fixture seen by trustee 0, which answered the blame of the fixture
+		f := newBlameFixture(t)
+		f.p.role = Trustee
+		f.p.myID = 0
+		f.p.sharedSecrets = []kyber.Point{suite.Point().Pick(suite.RandomStream()), suite.Point().Pick(suite.RandomStream())}
+		f.p.answeredBlame = &blameAnswer{roundID: fixtureRound, bitPos: fixtureBitPos}
+		mine, err := padBit(f.p.sharedSecrets[0], fixtureRound, fixtureBitPos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bits := &BLAME_BITS{RoundID: fixtureRound, BitPos: fixtureBitPos, PadBits: []byte{mine ^ 1}}
+		request := &BLAME_SECRET_REQUEST{RoundID: fixtureRound, BitPos: fixtureBitPos, ClientID: 0,
+			ClientBits: *f.sign(t, bits, f.clientPriv[0]).(*BLAME_BITS)}
+		test.tamper(f, request)
+		err = f.p.checkSecretRequest(request)
+		if test.ok && err != nil {
+			t.Errorf("%s: rejected: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
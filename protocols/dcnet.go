@@ -0,0 +1,293 @@
+package protocols
+
+// This file contains the DC-net: the computation of the pads, of the
+// ciphertexts, and the decoding of a round by Client0.
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/onet.v2/log"
+)
+
+// The first byte of each slot tells what the slot contains
+const (
+	slotEmpty byte = iota
+	slotData
+	slotAccusation
+	slotFragment // a fragment of a message, see framing.go
+	slotReplay   // a part of a packet replayed from a trace, see pcap.go
+	slotProbe    // a latency probe, see latency.go
+)
+
+// slotHeaderSize is the number of bytes of a slot which are not available for the payload
+const slotHeaderSize = 1
+
+// roundState holds the ciphertexts received by Client0 for one round
+type roundState struct {
+	clientCiphers  map[int][]byte
+	trusteeCiphers map[int][]byte
+	clientSigned   map[int]*CLIENT_CIPHER  // the signed messages, kept for the blame evidence
+	trusteeSigned  map[int]*TRUSTEE_CIPHER // the signed messages, kept for the blame evidence
+	layout         roundLayout
+	cleartext      []byte // set once the round is decoded
+	history        []byte // Client0's history for this round, see historyChain
+	clientKappas   map[int][]kyber.Scalar
+	trusteeSigmas  map[int][]kyber.Scalar
+}
+
+func newRoundState(history []byte) *roundState {
+	return &roundState{
+		clientCiphers:  make(map[int][]byte),
+		trusteeCiphers: make(map[int][]byte),
+		clientSigned:   make(map[int]*CLIENT_CIPHER),
+		trusteeSigned:  make(map[int]*TRUSTEE_CIPHER),
+		history:        history,
+		clientKappas:   make(map[int][]kyber.Scalar),
+		trusteeSigmas:  make(map[int][]kyber.Scalar),
+	}
+}
+
+// isComplete returns true when all clients and trustees sent their ciphertext
+func (r *roundState) isComplete(nClients, nTrustees int) bool {
+	return len(r.clientCiphers) == nClients && len(r.trusteeCiphers) == nTrustees
+}
+
+// dataQueue is a thread-safe FIFO of payloads waiting to be sent in a round
+type dataQueue struct {
+	sync.Mutex
+	items [][]byte
+}
+
+func (q *dataQueue) push(data []byte) {
+	q.Lock()
+	defer q.Unlock()
+	q.items = append(q.items, data)
+}
+
+func (q *dataQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.items)
+}
+
+// pop returns the next payload, or nil if there is none
+func (q *dataQueue) pop() []byte {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.items) == 0 {
+		return nil
+	}
+	data := q.items[0]
+	q.items = q.items[1:]
+	return data
+}
+
+// cellSize is the size of an upstream cell, i.e. one slot of PayloadSize bytes per client
+func (p *DissentProtocol) cellSize() int {
+	return p.nClients * p.config.Toml.PayloadSize
+}
+
+// mySlot returns the index of the slot owned by this client, as assigned by the shuffle
+func (p *DissentProtocol) mySlot() int {
+	return p.slot
+}
+
+// Slot returns the index of the slot owned by this client, or -1 before the shuffle
+func (p *DissentProtocol) Slot() int {
+	return p.mySlot()
+}
+
+// xorPad XORs into cell the pad derived from a shared secret for the given round
+func xorPad(cell []byte, secret kyber.Point, roundID int) error {
+	secretBytes, err := secret.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	seed := make([]byte, len(secretBytes)+8)
+	copy(seed, secretBytes)
+	binary.BigEndian.PutUint64(seed[len(secretBytes):], uint64(roundID))
+
+	config.CryptoSuite.XOF(seed).XORKeyStream(cell, cell)
+	return nil
+}
+
+// xorInto XORs src into dst
+func xorInto(dst, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
+
+// computePads returns the XOR of the pads shared with every peer for the given round, except the excluded ones
+func (p *DissentProtocol) computePads(roundID int, excluded map[int]bool) ([]byte, error) {
+	cell := make([]byte, p.cellSize())
+	for i, secret := range p.sharedSecrets {
+		if excluded[i] {
+			continue
+		}
+		if err := xorPad(cell, secret, roundID); err != nil {
+			return nil, err
+		}
+	}
+	return cell, nil
+}
+
+// SendUpstream enqueues some data to be sent anonymously in this client's slot
+func (p *DissentProtocol) SendUpstream(data []byte) error {
+	if !p.isClient() {
+		return errors.New("only clients can send upstream data")
+	}
+	if len(data) > MaxUpstreamPayload(p.config.Toml) {
+		return errors.New("payload of " + strconv.Itoa(len(data)) + " bytes does not fit in a slot of " +
+			strconv.Itoa(MaxUpstreamPayload(p.config.Toml)) + " bytes")
+	}
+	p.upstreamQueue.push(data)
+	return nil
+}
+
+// MaxUpstreamPayload returns the maximum size of the data passed to SendUpstream
+func MaxUpstreamPayload(toml *DissentTomlConfig) int {
+	if toml.EquivocationProtectionEnabled {
+		return toml.PayloadSize - equivocationTagSize - slotHeaderSize
+	}
+	return toml.PayloadSize - slotHeaderSize
+}
+
+// PendingUpstream returns the number of payloads waiting to be sent in our slot
+func (p *DissentProtocol) PendingUpstream() int {
+	return p.upstreamQueue.len()
+}
+
+// nextSlotContent returns what this client puts in its slot for the next round: a pending accusation,
+// a latency probe, some upstream data, a fragment of a message, a replayed packet, or nothing
+func (p *DissentProtocol) nextSlotContent() []byte {
+	slot := make([]byte, p.slotContentSize())
+	if p.pendingAccusation != nil {
+		slot[0] = slotAccusation
+		copy(slot[slotHeaderSize:], p.pendingAccusation)
+		p.pendingAccusation = nil
+	} else if probe := p.probeQueue.pop(); probe != nil {
+		slot[0] = slotProbe
+		copy(slot[slotHeaderSize:], probe)
+	} else if data := p.upstreamQueue.pop(); data != nil {
+		slot[0] = slotData
+		copy(slot[slotHeaderSize:], data)
+	} else if f := p.fragmentQueue.pop(); f != nil {
+		slot[0] = slotFragment
+		copy(slot[slotHeaderSize:], f)
+	} else if r := p.replayQueue.pop(); r != nil {
+		slot[0] = slotReplay
+		copy(slot[slotHeaderSize:], r)
+	}
+	return slot
+}
+
+// slotPayloads returns the data contained in each slot, or nil for slots which do not contain data
+func slotPayloads(slots [][]byte) [][]byte {
+	payloads := make([][]byte, len(slots))
+	for i, slot := range slots {
+		if len(slot) > 0 && slot[0] == slotData {
+			payloads[i] = slot[slotHeaderSize:]
+		}
+	}
+	return payloads
+}
+
+// SendDownstream enqueues some data to be broadcasted by Client0 along with a round output
+func (p *DissentProtocol) SendDownstream(data []byte) error {
+	if p.role != Client0 {
+		return errors.New("only Client0 can send downstream data")
+	}
+	if len(data) > p.config.Toml.CellSizeDown {
+		return errors.New("payload of " + strconv.Itoa(len(data)) + " bytes does not fit in a downstream cell of " +
+			strconv.Itoa(p.config.Toml.CellSizeDown) + " bytes")
+	}
+	p.downstreamQueue.push(data)
+	return nil
+}
+
+// PendingDownstream returns the number of payloads waiting to be broadcasted by Client0
+func (p *DissentProtocol) PendingDownstream() int {
+	return p.downstreamQueue.len()
+}
+
+// SetOutputHandler registers the function called with the data of each slot of each round (nil for slots without data)
+func (p *DissentProtocol) SetOutputHandler(handler func(roundID int, slots [][]byte, downstream []byte)) {
+	p.outputHandler = handler
+}
+
+// windowSize returns the maximum number of rounds in flight at once
+func (p *DissentProtocol) windowSize() int {
+	if p.config.Toml.RelayWindowSize < 1 {
+		return 1
+	}
+	return p.config.Toml.RelayWindowSize
+}
+
+// fillWindow is called on Client0; it announces new rounds until RelayWindowSize rounds are in flight
+func (p *DissentProtocol) fillWindow() {
+	for p.nextRound < p.nextOutput+p.windowSize() && !p.HasStopped {
+		layout, ok := p.nextLayout()
+		if !ok {
+			break
+		}
+		p.startRound(p.nextRound, layout)
+		p.nextRound++
+	}
+}
+
+// startRound is called on Client0 to announce a new round to everyone
+func (p *DissentProtocol) startRound(roundID int, layout roundLayout) {
+	if p.HasStopped {
+		log.Lvl2("Protocol stopped, not starting round", roundID)
+		return
+	}
+
+	log.Lvl3("Client0 : starting round", roundID)
+	round, ok := p.rounds[roundID]
+	if !ok {
+		//no trustee ciphertext arrived yet for this round
+		round = newRoundState(nil)
+		p.rounds[roundID] = round
+	}
+	round.history = p.relayHistory.at(roundID, p.windowSize())
+	round.layout = layout
+
+	p.sendToActiveClients(&NEW_ROUND{RoundID: roundID, Reservation: layout.reservation, OpenSlots: layout.openSlots})
+}
+
+// decodeRound XORs all ciphertexts of a complete round, and returns the cleartext; the ciphertexts of
+// the trustees cover the full cell, only the part used by the round is kept
+func (p *DissentProtocol) decodeRound(round *roundState) []byte {
+	cleartext := make([]byte, p.layoutSize(round.layout))
+	for _, cipher := range round.clientCiphers {
+		xorInto(cleartext, cipher)
+	}
+	for _, cipher := range round.trusteeCiphers {
+		xorInto(cleartext, p.project(round.layout, cipher))
+	}
+	return cleartext
+}
+
+// splitSlots cuts a cleartext cell into one payload per slot
+func (p *DissentProtocol) splitSlots(cell []byte) [][]byte {
+	payloadSize := p.config.Toml.PayloadSize
+	slots := make([][]byte, 0, p.nClients)
+	for i := 0; i+payloadSize <= len(cell); i += payloadSize {
+		slots = append(slots, cell[i:i+payloadSize])
+	}
+	return slots
+}
+
+// checkCipherLength verifies the length of a received ciphertext
+func (p *DissentProtocol) checkCipherLength(cipher []byte) error {
+	if len(cipher) != p.cellSize() {
+		return errors.New("ciphertext has length " + strconv.Itoa(len(cipher)) + ", expected " + strconv.Itoa(p.cellSize()))
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package protocols
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDataQueue(t *testing.T) {
+	q := &dataQueue{}
+	if q.pop() != nil || q.len() != 0 {
+		t.Fatal("a new queue is not empty")
+	}
+	for k := byte(0); k < 3; k++ {
+		q.push([]byte{k})
+	}
+	if q.len() != 3 {
+		t.Fatalf("%d payloads queued, expected 3", q.len())
+	}
+	for k := byte(0); k < 3; k++ {
+		if data := q.pop(); !bytes.Equal(data, []byte{k}) {
+			t.Errorf("popped %v, expected %v", data, []byte{k})
+		}
+	}
+	if q.pop() != nil {
+		t.Error("popped from an empty queue")
+	}
+}
+
+func TestNextSlotContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		fill    func(p *DissentProtocol)
+		kind    byte
+		payload []byte
+	}{
+		{"nothing to send", func(p *DissentProtocol) {}, slotEmpty, nil},
+		{"data", func(p *DissentProtocol) { p.upstreamQueue.push([]byte("up")) }, slotData, []byte("up")},
+		{"fragment", func(p *DissentProtocol) { p.fragmentQueue.push([]byte("fr")) }, slotFragment, []byte("fr")},
+		{"replayed packet", func(p *DissentProtocol) { p.replayQueue.push([]byte("re")) }, slotReplay, []byte("re")},
+		{"probe first", func(p *DissentProtocol) {
+			p.upstreamQueue.push([]byte("up"))
+			p.probeQueue.push([]byte("pr"))
+		}, slotProbe, []byte("pr")},
+		{"accusation first", func(p *DissentProtocol) {
+			p.probeQueue.push([]byte("pr"))
+			p.pendingAccusation = []byte("ac")
+		}, slotAccusation, []byte("ac")},
+		{"data before fragments", func(p *DissentProtocol) {
+			p.fragmentQueue.push([]byte("fr"))
+			p.upstreamQueue.push([]byte("up"))
+		}, slotData, []byte("up")},
+	}
+	for _, test := range tests {
+		p := layoutProtocol(3, 8)
+		test.fill(p)
+		slot := p.nextSlotContent()
+		if len(slot) != 8 {
+			t.Errorf("%s: slot of %d bytes, expected 8", test.name, len(slot))
+			continue
+		}
+		if slot[0] != test.kind || !bytes.HasPrefix(slot[slotHeaderSize:], test.payload) {
+			t.Errorf("%s: got %v, expected kind %d with %v", test.name, slot, test.kind, test.payload)
+		}
+	}
+}
+
+func TestDecodeRound(t *testing.T) {
+	nClients, nTrustees, payloadSize := 3, 2, 4
+	p := layoutProtocol(nClients, payloadSize)
+	cellSize := p.cellSize()
+	content := []byte{slotData, 'a', 'b', 'c', slotEmpty, 0, 0, 0, slotData, 'x', 'y', 'z'}
+
+	//the pad of each client-trustee pair
+	pad := func(i, j int) []byte {
+		b := make([]byte, cellSize)
+		for k := range b {
+			b[k] = byte(31*i + 17*j + 7*k + 1)
+		}
+		return b
+	}
+	round := newRoundState(nil)
+	round.layout = p.allSlotsLayout()
+	for i := 0; i < nClients; i++ {
+		cipher := make([]byte, cellSize)
+		for j := 0; j < nTrustees; j++ {
+			xorInto(cipher, pad(i, j))
+		}
+		//each client writes its own slot
+		xorInto(cipher[i*payloadSize:(i+1)*payloadSize], content[i*payloadSize:(i+1)*payloadSize])
+		round.clientCiphers[i] = cipher
+	}
+	for j := 0; j < nTrustees; j++ {
+		cipher := make([]byte, cellSize)
+		for i := 0; i < nClients; i++ {
+			xorInto(cipher, pad(i, j))
+		}
+		round.trusteeCiphers[j] = cipher
+	}
+	if !round.isComplete(nClients, nTrustees) {
+		t.Fatal("the round is not complete")
+	}
+
+	cleartext := p.decodeRound(round)
+	if !bytes.Equal(cleartext, content) {
+		t.Fatalf("decoded %v, expected %v", cleartext, content)
+	}
+	payloads := slotPayloads(p.splitSlots(cleartext))
+	expected := [][]byte{[]byte("abc"), nil, []byte("xyz")}
+	for s := range expected {
+		if !bytes.Equal(payloads[s], expected[s]) {
+			t.Errorf("slot %d contains %q, expected %q", s, payloads[s], expected[s])
+		}
+	}
+
+	//a round with some slots closed only carries the open ones
+	round.layout = roundLayout{openSlots: []int{0, 2}}
+	for i := range round.clientCiphers {
+		round.clientCiphers[i] = p.project(round.layout, round.clientCiphers[i])
+	}
+	if cleartext := p.decodeRound(round); !bytes.Equal(cleartext, append(append([]byte{}, content[:4]...), content[8:]...)) {
+		t.Errorf("decoded %v with the slots 0 and 2 open", cleartext)
+	}
+}
+
+func TestCheckCipherLength(t *testing.T) {
+	p := layoutProtocol(3, 4)
+	tests := []struct {
+		length int
+		ok     bool
+	}{
+		{12, true},
+		{11, false},
+		{13, false},
+		{0, false},
+	}
+	for _, test := range tests {
+		if err := p.checkCipherLength(make([]byte, test.length)); (err == nil) != test.ok {
+			t.Errorf("length %d: got error %v", test.length, err)
+		}
+	}
+}
+
+func TestWindowSize(t *testing.T) {
+	tests := []struct {
+		configured int
+		window     int
+	}{
+		{-1, 1},
+		{0, 1},
+		{1, 1},
+		{5, 5},
+	}
+	for _, test := range tests {
+		p := &DissentProtocol{config: DissentProtocolConfig{Toml: &DissentTomlConfig{RelayWindowSize: test.configured}}}
+		if w := p.windowSize(); w != test.window {
+			t.Errorf("RelayWindowSize %d: window of %d rounds, expected %d", test.configured, w, test.window)
+		}
+	}
+}
+
+func TestFillWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		window     int
+		nextOutput int
+		nextRound  int
+		expected   int // the next round to announce after filling the window
+	}{
+		{"empty window", 3, 0, 0, 3},
+		{"full window", 3, 0, 3, 3},
+		{"one round output", 3, 1, 3, 4},
+		{"no pipelining", 1, 4, 4, 5},
+		{"window grown", 5, 2, 4, 7},
+	}
+	for _, test := range tests {
+		p := layoutProtocol(2, 4)
+		p.config.Toml.RelayWindowSize = test.window
+		p.rounds = make(map[int]*roundState)
+		p.nextOutput, p.nextRound = test.nextOutput, test.nextRound
+
+		//the trustees sent their ciphertexts for a future round ahead of time
+		early := newRoundState(nil)
+		early.trusteeCiphers[0] = make([]byte, p.cellSize())
+		p.rounds[test.nextRound] = early
+
+		p.fillWindow()
+		if p.nextRound != test.expected {
+			t.Errorf("%s: next round %d, expected %d", test.name, p.nextRound, test.expected)
+		}
+		for r := test.nextRound; r < test.expected; r++ {
+			round, ok := p.rounds[r]
+			if !ok || round.layout.openSlots == nil {
+				t.Errorf("%s: round %d was not started", test.name, r)
+			}
+		}
+		if test.expected > test.nextRound && p.rounds[test.nextRound] != early {
+			t.Errorf("%s: the ciphertexts sent ahead of time were lost", test.name)
+		}
+	}
+}
@@ -1,6 +1,14 @@
 package protocols
 
 import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/kyber.v2/proof/dleq"
+	"gopkg.in/dedis/onet.v2"
 	"gopkg.in/dedis/onet.v2/network"
 	"gopkg.in/dedis/onet.v2/log"
 )
@@ -37,8 +45,21 @@ type DissentTomlConfig struct {
 	RelayReportingLimit                     int
 	UseUDP                                  bool
 	DoLatencyTests                          bool
+	SocksEnabled                            bool
 	SocksServerPort                         int
 	SocksClientPort                         int
+	SocksExitAllowLocal                     bool
+	ClientAPIPort                           int
+	BoardLogFile                            string
+	DataOutputPath                          string
+	QuorumMinClients                        int
+	QuorumMinTrustees                       int
+	QuorumWaitForAllTrustees                bool
+	QuorumGracePeriod                       int
+	QuorumMaxRestartsPerMinute              int
+	EpochDuration                           int
+	EpochMaxPendingJoiners                  int
+	ClientAllowlistEnabled                  bool
 	ProtocolVersion                         string
 	DCNetType                               string
 	ReplayPCAP                              bool
@@ -48,7 +69,7 @@ type DissentTomlConfig struct {
 	TrusteeNeverSlowDown                    bool
 	SimulDelayBetweenClients                int
 	DisruptionProtectionEnabled             bool
-	EquivocationProtectionEnabled           bool // not linked in the back
+	EquivocationProtectionEnabled           bool
 	OpenClosedSlotsMinDelayBetweenRequests  int
 	RelayMaxNumberOfConsecutiveFailedRounds int
 	RelayProcessingLoopSleepTime            int
@@ -63,6 +84,8 @@ type DissentProtocolConfig struct {
 	Toml                  *DissentTomlConfig
 	Identities            map[string]DissentIdentity
 	Role                  DissentRole
+	BlameHandler          func(disruptor *network.ServerIdentity) // called on Client0 when a disruptor is identified
+	TimeoutHandler        func(lateClients, lateTrustees []string, resync bool) // called on Client0 when a round times out
 }
 
 
@@ -74,18 +97,18 @@ func (p *DissentProtocol) Start() error {
 	}
 	log.Lvl3("Starting Dissent protocol (", p.nClients, "clients &", p.nTrustees, "trustees)")
 
-	//broadcast the parameters
-	message := &ALL_ALL_PARAMETERS{ NClients: p.nClients, NTrustees:p.nTrustees}
-
-	i := 0
-	for i < p.nClients {
+	//broadcast the parameters and the numbering of the nodes, which everyone follows
+	clients, trustees, err := p.ms.numbering()
+	if err != nil {
+		log.Error("Cannot number the nodes:", err)
+		return err
+	}
+	message := &ALL_ALL_PARAMETERS{NClients: p.nClients, NTrustees: p.nTrustees, Clients: clients, Trustees: trustees}
+	for i := range p.ms.clients {
 		p.ms.SendToClient(i, message)
-		i++
 	}
-	i = 0
-	for i < p.nTrustees {
+	for i := range p.ms.trustees {
 		p.ms.SendToTrustee(i, message)
-		i++
 	}
 
 	return nil
@@ -93,38 +116,850 @@ func (p *DissentProtocol) Start() error {
 
 func (p *DissentProtocol) Received_ALL_ALL_PARAMETERS(msg Struct_ALL_ALL_PARAMETERS) error {
 
-	log.Lvl1("Received_ALL_ALL_PARAMETERS", p.nClients, p.nTrustees)
+	if err := p.authenticate(msg.TreeNode, &msg.ALL_ALL_PARAMETERS); err != nil {
+		return err
+	}
+	if err := p.expectState("ALL_ALL_PARAMETERS", StateWaitingParams); err != nil {
+		return err
+	}
+
+	log.Lvl1("Received_ALL_ALL_PARAMETERS", msg.NClients, msg.NTrustees)
 
+	if len(msg.Clients) != msg.NClients || len(msg.Trustees) != msg.NTrustees {
+		e := "Received ALL_ALL_PARAMETERS with an inconsistent numbering"
+		log.Error(e)
+		return errors.New(e)
+	}
+	myID, err := p.ms.applyNumbering(msg.Clients, msg.Trustees, p.ServerIdentity(), p.isClient())
+	if err != nil {
+		log.Error("Cannot follow the numbering of Client0:", err)
+		return err
+	}
+	//only enter the key exchange once the parameters are consistent, so a bad message leaves us waiting
+	if err := p.setState(StateKeyExchange); err != nil {
+		return err
+	}
 	p.nClients = msg.NClients
 	p.nTrustees = msg.NTrustees
+	p.myID = myID
+	log.Lvl2("Our ID is", myID)
+
+	//send my key to Client0, which broadcasts all keys once it has them
+	message := &PUBLIC_KEY{Key: p.keyPub}
 
-	//broadcast my key
-	message := &PUBLIC_KEY{ Key: p.keyPub}
+	return p.ms.SendToClient0(message)
+}
+
+func (p *DissentProtocol) Received_PUBLIC_KEY(msg Struct_PUBLIC_KEY) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.PUBLIC_KEY); err != nil {
+		return err
+	}
+	if err := p.expectState("PUBLIC_KEY", StateWaitingParams, StateKeyExchange); err != nil {
+		return err
+	}
+
+	log.Lvl2("Received_PUBLIC_KEY from", msg.ServerIdentity)
+
+	if p.role != Client0 {
+		log.Error("Received a PUBLIC_KEY, but we're not Client0 ! ignoring.")
+		return nil
+	}
 
-	i := 0
-	for i < p.nClients {
+	role, id, ok := p.ms.identify(msg.TreeNode)
+	if !ok {
+		e := "Received a PUBLIC_KEY from an unknown node " + msg.ServerIdentity.String()
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	if !p.keyExchange.collectPublicKey(role, id, msg.PUBLIC_KEY, p.nClients, p.nTrustees) {
+		return nil
+	}
+
+	//we have all keys, broadcast them
+	log.Lvl2("Collected all", p.nClients, "clients keys and", p.nTrustees, "trustees keys, broadcasting them.")
+	clientKeys, trusteeKeys := p.keyExchange.orderedKeys()
+	message := &ALL_PUBLIC_KEYS{ClientKeys: clientKeys, TrusteeKeys: trusteeKeys}
+
+	for i := range p.ms.clients {
 		p.ms.SendToClient(i, message)
-		i++
 	}
-	i = 0
-	for i < p.nTrustees {
+	for i := range p.ms.trustees {
 		p.ms.SendToTrustee(i, message)
-		i++
 	}
 
 	return nil
 }
 
-func (p *DissentProtocol) Received_PUBLIC_KEY(msg Struct_PUBLIC_KEY) error {
+func (p *DissentProtocol) Received_ALL_PUBLIC_KEYS(msg Struct_ALL_PUBLIC_KEYS) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.ALL_PUBLIC_KEYS); err != nil {
+		return err
+	}
+	if err := p.expectState("ALL_PUBLIC_KEYS", StateKeyExchange); err != nil {
+		return err
+	}
+
+	log.Lvl2("Received_ALL_PUBLIC_KEYS", len(msg.ClientKeys), len(msg.TrusteeKeys))
+
+	clientKeys, trusteeKeys, err := p.checkPublicKeys(msg.ClientKeys, msg.TrusteeKeys)
+	if err != nil {
+		log.Error("Invalid ALL_PUBLIC_KEYS:", err)
+		return err
+	}
+
+	p.clientKeys = clientKeys
+	p.trusteeKeys = trusteeKeys
+
+	//one shared secret per client-trustee pair
+	if p.isClient() {
+		p.sharedSecrets = p.deriveSharedSecrets(p.trusteeKeys)
+	} else {
+		p.sharedSecrets = p.deriveSharedSecrets(p.clientKeys)
+	}
+
+	log.Lvl1("Key exchange done,", len(p.sharedSecrets), "shared secrets derived.")
+	if err := p.setState(StateShuffle); err != nil {
+		return err
+	}
+
+	//clients submit an encrypted pseudonym key to get a slot
+	if p.isClient() {
+		p.newPseudonym()
+		return p.ms.SendToClient0(&PSEUDONYM{X: p.pseudonymX, Y: p.pseudonymY})
+	}
+
+	return nil
+}
+
+func (p *DissentProtocol) Received_PSEUDONYM(msg Struct_PSEUDONYM) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.PSEUDONYM); err != nil {
+		return err
+	}
+	if err := p.expectState("PSEUDONYM", StateKeyExchange, StateShuffle); err != nil {
+		return err
+	}
+
+	log.Lvl2("Received_PSEUDONYM from", msg.ServerIdentity)
+
+	if p.role != Client0 {
+		log.Error("Received a PSEUDONYM, but we're not Client0 ! ignoring.")
+		return nil
+	}
+
+	role, id, ok := p.ms.identify(msg.TreeNode)
+	if !ok || role != Client {
+		e := "Received a PSEUDONYM from a node which is not a client " + msg.ServerIdentity.String()
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	if !p.shuffle.collectPseudonym(id, msg.PSEUDONYM, p.nClients) {
+		return nil
+	}
+
+	//all pseudonyms are there, the first trustee shuffles them
+	log.Lvl2("Collected all", p.nClients, "pseudonyms, starting the shuffle.")
+	p.transcript = p.shuffle.transcript()
+
+	return p.ms.SendToTrustee(0, &SHUFFLE_REQUEST{Transcript: p.transcript})
+}
+
+func (p *DissentProtocol) Received_SHUFFLE_REQUEST(msg Struct_SHUFFLE_REQUEST) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.SHUFFLE_REQUEST); err != nil {
+		return err
+	}
+	if err := p.expectState("SHUFFLE_REQUEST", StateShuffle); err != nil {
+		return err
+	}
+
+	log.Lvl2("Received_SHUFFLE_REQUEST with", len(msg.Transcript.Steps), "steps done")
+
+	if p.role != Trustee || len(msg.Transcript.Steps) != p.myID {
+		e := "Received a SHUFFLE_REQUEST, but it is not our turn to shuffle"
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	//check the work of the previous trustees before building on it
+	if _, err := p.checkShuffleTranscript(&msg.Transcript); err != nil {
+		log.Error("Invalid shuffle transcript:", err)
+		return err
+	}
+
+	step, err := p.doShuffleStep(&msg.Transcript)
+	if err != nil {
+		log.Error("Could not shuffle:", err)
+		return err
+	}
+
+	return p.ms.SendToClient0(&TRUSTEE_SHUFFLE{Step: *step})
+}
+
+func (p *DissentProtocol) Received_TRUSTEE_SHUFFLE(msg Struct_TRUSTEE_SHUFFLE) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.TRUSTEE_SHUFFLE); err != nil {
+		return err
+	}
+	if err := p.expectState("TRUSTEE_SHUFFLE", StateShuffle); err != nil {
+		return err
+	}
+
+	log.Lvl2("Received_TRUSTEE_SHUFFLE from", msg.ServerIdentity)
 
-	log.Lvl1("Received_PUBLIC_KEY from", msg.ServerIdentity)
+	if p.role != Client0 {
+		log.Error("Received a TRUSTEE_SHUFFLE, but we're not Client0 ! ignoring.")
+		return nil
+	}
+
+	role, id, ok := p.ms.identify(msg.TreeNode)
+	if !ok || role != Trustee || id != len(p.transcript.Steps) {
+		e := "Received a TRUSTEE_SHUFFLE from a node whose turn it is not " + msg.ServerIdentity.String()
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	X, Y := p.transcript.lastOutput()
+	if err := verifyShuffleStep(&msg.Step, X, Y, p.trusteeKeys, id); err != nil {
+		log.Error("Invalid shuffle step:", err)
+		return err
+	}
+	p.transcript.Steps = append(p.transcript.Steps, msg.Step)
+
+	if len(p.transcript.Steps) < p.nTrustees {
+		return p.ms.SendToTrustee(len(p.transcript.Steps), &SHUFFLE_REQUEST{Transcript: p.transcript})
+	}
+
+	//every trustee shuffled, everyone verifies the transcript and learns the slots
+	message := &SHUFFLE_TRANSCRIPT{Transcript: p.transcript}
+	for i := range p.ms.clients {
+		p.ms.SendToClient(i, message)
+	}
+	for i := range p.ms.trustees {
+		p.ms.SendToTrustee(i, message)
+	}
+
+	return nil
+}
+
+func (p *DissentProtocol) Received_SHUFFLE_TRANSCRIPT(msg Struct_SHUFFLE_TRANSCRIPT) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.SHUFFLE_TRANSCRIPT); err != nil {
+		return err
+	}
+	if err := p.expectState("SHUFFLE_TRANSCRIPT", StateShuffle); err != nil {
+		return err
+	}
+
+	log.Lvl2("Received_SHUFFLE_TRANSCRIPT")
+
+	if len(msg.Transcript.Steps) != p.nTrustees {
+		e := "Received a SHUFFLE_TRANSCRIPT with " + strconv.Itoa(len(msg.Transcript.Steps)) + " steps, expected " + strconv.Itoa(p.nTrustees)
+		log.Error(e)
+		return errors.New(e)
+	}
+	pseudonymKeys, err := p.checkShuffleTranscript(&msg.Transcript)
+	if err != nil {
+		log.Error("Invalid shuffle transcript:", err)
+		return err
+	}
+	p.pseudonymKeys = pseudonymKeys
+
+	if p.isClient() {
+		if !p.containsPseudonym(&msg.Transcript) {
+			e := "Our pseudonym was not included in the shuffle"
+			log.Error(e)
+			return errors.New(e)
+		}
+		slot, err := p.findSlot(pseudonymKeys)
+		if err != nil {
+			log.Error("Could not find our slot:", err)
+			return err
+		}
+		p.slot = slot
+		log.Lvl2("Shuffle done, we own slot", slot)
+	}
+
+	if err := p.setState(StateRounds); err != nil {
+		return err
+	}
+	log.Lvl1("Shuffle verified, ready for rounds.")
+
+	if p.config.Toml.UseUDP && p.role != Trustee {
+		if err := p.startFastChannel(); err != nil {
+			log.Error("Could not start the fast downstream channel:", err)
+			return err
+		}
+	}
+
+	p.startDataOutput()
+	if p.role == Trustee {
+		go p.sendPads()
+	}
+	if p.config.Toml.ReplayPCAP && p.isClient() {
+		go p.replayPCAP()
+		if p.role == Client0 {
+			go p.reportReplay()
+		}
+	}
+	if p.config.Toml.DoLatencyTests && p.isClient() {
+		go p.sendProbes()
+		if p.role == Client0 {
+			go p.reportLatencies()
+		}
+	}
+
+	//everyone received the transcript before this message, Client0 can start the rounds
+	if p.role == Client0 {
+		p.roundsLock.Lock()
+		p.fillWindow()
+		p.armRoundTimeout(p.nextOutput)
+		p.roundsLock.Unlock()
+	}
 
 	return nil
 }
 
 func (p *DissentProtocol) Received_NEW_ROUND(msg Struct_NEW_ROUND) error {
 
-	log.Lvl1("Received_NEW_ROUND")
+	if err := p.authenticate(msg.TreeNode, &msg.NEW_ROUND); err != nil {
+		return err
+	}
+	if err := p.expectState("NEW_ROUND", StateRounds, StateBlame); err != nil {
+		return err
+	}
 
+	log.Lvl3("Received_NEW_ROUND", msg.RoundID)
+
+	p.clientLock.Lock()
+	defer p.clientLock.Unlock()
+
+	if !p.isClient() {
+		e := "Received NEW_ROUND, but we're not a client"
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	layout, err := p.layoutFromMessage(&msg.NEW_ROUND)
+	if err != nil {
+		log.Error("Invalid NEW_ROUND for round", msg.RoundID, ":", err)
+		return err
+	}
+	pads, err := p.computePads(msg.RoundID, nil)
+	if err != nil {
+		log.Error("Could not compute the pads for round", msg.RoundID, ":", err)
+		return err
+	}
+	p.layouts[msg.RoundID] = layout
+	message := &CLIENT_CIPHER{RoundID: msg.RoundID, Reservation: layout.reservation, OpenSlots: layout.openSlots, Cipher: p.project(layout, pads)}
+
+	//in a reservation round, clients open their slot if they have something to send
+	if layout.reservation {
+		message.Cipher = p.reservationCipher(pads, p.hasPendingData())
+		return p.ms.SendToClient0(message)
+	}
+
+	//clients XOR their payload in their slot if it is open, encrypted with a fresh key if equivocation protection is enabled
+	k := layout.slotIndex(p.mySlot())
+	var slotKey kyber.Scalar
+	if k >= 0 {
+		slotContent := p.nextSlotContent()
+		if p.config.Toml.EquivocationProtectionEnabled {
+			key, sealed, err := sealSlot(slotContent)
+			if err != nil {
+				log.Error("Could not encrypt our slot for round", msg.RoundID, ":", err)
+				return err
+			}
+			slotKey = key
+			slotContent = sealed
+		}
+		xorInto(message.Cipher[k*p.config.Toml.PayloadSize:], slotContent)
+		if p.config.Toml.DisruptionProtectionEnabled {
+			p.sentSlots[msg.RoundID] = slotContent
+		}
+	}
+	if p.config.Toml.EquivocationProtectionEnabled {
+		if message.Kappas, err = p.clientKappas(msg.RoundID, slotKey); err != nil {
+			log.Error("Could not compute the equivocation pads for round", msg.RoundID, ":", err)
+			return err
+		}
+	}
+
+	return p.ms.SendToClient0(message)
+}
+
+func (p *DissentProtocol) Received_CLIENT_CIPHER(msg Struct_CLIENT_CIPHER) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.CLIENT_CIPHER); err != nil {
+		return err
+	}
+	if err := p.expectState("CLIENT_CIPHER", StateRounds, StateBlame); err != nil {
+		return err
+	}
+
+	log.Lvl3("Received_CLIENT_CIPHER for round", msg.RoundID, "from", msg.ServerIdentity)
+
+	p.roundsLock.Lock()
+	defer p.roundsLock.Unlock()
+
+	round, id, err := p.roundForCipher(msg.TreeNode, Client, msg.RoundID, msg.Cipher)
+	if err != nil {
+		return err
+	}
+	if !sameLayout(round.layout, roundLayout{reservation: msg.Reservation, openSlots: msg.OpenSlots}) {
+		e := "Received CLIENT_CIPHER for round " + strconv.Itoa(msg.RoundID) + " with another layout than announced"
+		log.Error(e)
+		return errors.New(e)
+	}
+	if p.config.Toml.EquivocationProtectionEnabled && !round.layout.reservation {
+		if err := p.checkScalars(msg.Kappas); err != nil {
+			log.Error("Invalid ciphertext for round", msg.RoundID, ":", err)
+			return err
+		}
+		round.clientKappas[id] = msg.Kappas
+	}
+	round.clientCiphers[id] = msg.Cipher
+	signed := msg.CLIENT_CIPHER
+	round.clientSigned[id] = &signed
+
+	return p.tryFinalizeRounds()
+}
+
+func (p *DissentProtocol) Received_TRUSTEE_CIPHER(msg Struct_TRUSTEE_CIPHER) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.TRUSTEE_CIPHER); err != nil {
+		return err
+	}
+	if err := p.expectState("TRUSTEE_CIPHER", StateShuffle, StateRounds, StateBlame); err != nil {
+		return err
+	}
+
+	log.Lvl3("Received_TRUSTEE_CIPHER for round", msg.RoundID, "from", msg.ServerIdentity)
+
+	p.roundsLock.Lock()
+	defer p.roundsLock.Unlock()
+
+	//the pads of this ciphertext include clients excluded since
+	if p.role == Client0 && msg.NExcluded != len(p.excludedClients) {
+		log.Lvl3("Ignoring an outdated ciphertext for round", msg.RoundID, "from", msg.ServerIdentity)
+		return nil
+	}
+
+	round, id, err := p.roundForCipher(msg.TreeNode, Trustee, msg.RoundID, msg.Cipher)
+	if err != nil {
+		return err
+	}
+	if p.config.Toml.EquivocationProtectionEnabled {
+		if err := p.checkScalars(msg.Sigmas); err != nil {
+			log.Error("Invalid ciphertext for round", msg.RoundID, ":", err)
+			return err
+		}
+		round.trusteeSigmas[id] = msg.Sigmas
+	}
+	round.trusteeCiphers[id] = msg.Cipher
+	signed := msg.TRUSTEE_CIPHER
+	round.trusteeSigned[id] = &signed
+	p.trusteeCipherBuffered(id)
+
+	return p.tryFinalizeRounds()
+}
+
+func (p *DissentProtocol) Received_TRUSTEE_RATE_CHANGE(msg Struct_TRUSTEE_RATE_CHANGE) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.TRUSTEE_RATE_CHANGE); err != nil {
+		return err
+	}
+	if err := p.expectState("TRUSTEE_RATE_CHANGE", StateRounds, StateBlame); err != nil {
+		return err
+	}
+
+	log.Lvl2("Received_TRUSTEE_RATE_CHANGE, slow down :", msg.SlowDown)
+
+	if p.role != Trustee {
+		e := "Received TRUSTEE_RATE_CHANGE, but we're not a trustee"
+		log.Error(e)
+		return errors.New(e)
+	}
+	if p.config.Toml.TrusteeNeverSlowDown {
+		log.Lvl3("TrusteeNeverSlowDown is set, ignoring the rate change")
+		return nil
+	}
+
+	p.flow.setSlowedDown(msg.SlowDown)
 	return nil
-}
\ No newline at end of file
+}
+
+func (p *DissentProtocol) Received_CLIENTS_EXCLUDED(msg Struct_CLIENTS_EXCLUDED) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.CLIENTS_EXCLUDED); err != nil {
+		return err
+	}
+	if err := p.expectState("CLIENTS_EXCLUDED", StateRounds, StateBlame); err != nil {
+		return err
+	}
+
+	log.Lvl1("Received_CLIENTS_EXCLUDED, clients", msg.Clients, "are excluded from round", msg.FromRound)
+
+	if p.role != Trustee {
+		e := "Received CLIENTS_EXCLUDED, but we're not a trustee"
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	p.flow.exclude(msg.Clients, msg.FromRound)
+	return nil
+}
+
+func (p *DissentProtocol) Received_ROUND_OUTPUT(msg Struct_ROUND_OUTPUT) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.ROUND_OUTPUT); err != nil {
+		return err
+	}
+	if err := p.expectState("ROUND_OUTPUT", StateRounds, StateBlame); err != nil {
+		return err
+	}
+
+	return p.handleRoundOutput(&msg.ROUND_OUTPUT)
+}
+
+// handleRoundOutput is called on clients with each round output, received from Client0 or on the fast channel
+func (p *DissentProtocol) handleRoundOutput(msg *ROUND_OUTPUT) error {
+	p.clientLock.Lock()
+	defer p.clientLock.Unlock()
+
+	log.Lvl3("Received_ROUND_OUTPUT for round", msg.RoundID, "(", len(msg.Data), "bytes up,", len(msg.DownstreamData), "bytes down)")
+
+	layout, ok := p.layouts[msg.RoundID]
+	if !ok {
+		e := "Received ROUND_OUTPUT for round " + strconv.Itoa(msg.RoundID) + ", which we did not take part in"
+		log.Error(e)
+		return errors.New(e)
+	}
+	delete(p.layouts, msg.RoundID)
+
+	//a reservation round carries no data, only the downstream data
+	if layout.reservation {
+		p.history.add(msg, p.windowSize())
+		p.outputRound(msg.RoundID, nil, msg.DownstreamData)
+		if p.outputHandler != nil {
+			p.outputHandler(msg.RoundID, make([][]byte, p.nClients), msg.DownstreamData)
+		}
+		return nil
+	}
+
+	slots := p.splitSlots(msg.Data)
+	if p.config.Toml.DisruptionProtectionEnabled {
+		p.checkMySlot(msg.RoundID, p.expandSlots(layout, slots))
+	}
+
+	//with equivocation protection, the slots only decrypt if we saw the same history as everyone
+	if p.config.Toml.EquivocationProtectionEnabled {
+		contents, err := openSlots(slots, msg.SlotKeys)
+		if err != nil {
+			err = errors.New("equivocation detected in round " + strconv.Itoa(msg.RoundID) + ", " + err.Error())
+			p.abort(err)
+			return err
+		}
+		slots = contents
+	}
+	p.history.add(msg, p.windowSize())
+
+	slots = p.expandSlots(layout, slots)
+	p.reassembleMessages(msg.RoundID, slots)
+	if p.role == Client0 && p.config.Toml.ReplayPCAP {
+		p.recordReplayed(slots)
+	}
+	if p.config.Toml.DoLatencyTests {
+		p.recordProbes(slots)
+	}
+	p.outputRound(msg.RoundID, slotPayloads(slots), msg.DownstreamData)
+	if p.outputHandler != nil {
+		p.outputHandler(msg.RoundID, slotPayloads(slots), msg.DownstreamData)
+	}
+
+	return nil
+}
+
+// roundForCipher checks a ciphertext received by Client0, and returns the round it belongs to and the ID of its sender
+func (p *DissentProtocol) roundForCipher(node *onet.TreeNode, expectedRole DissentRole, roundID int, cipher []byte) (*roundState, int, error) {
+	if p.role != Client0 {
+		e := "Received a ciphertext, but we're not Client0"
+		log.Error(e)
+		return nil, -1, errors.New(e)
+	}
+
+	role, id, ok := p.ms.identify(node)
+	if !ok || role != expectedRole {
+		e := "Received a ciphertext from an unexpected node " + node.ServerIdentity.String()
+		log.Error(e)
+		return nil, -1, errors.New(e)
+	}
+
+	//trustees send their ciphertexts ahead of time, we buffer them until the round starts
+	round, ok := p.rounds[roundID]
+	if !ok && role == Trustee && roundID >= p.nextRound {
+		round = newRoundState(nil)
+		p.rounds[roundID] = round
+		ok = true
+	}
+	if !ok || (role == Client && (roundID >= p.nextRound || p.excludedClients[id])) {
+		e := "Received a ciphertext for round " + strconv.Itoa(roundID) + ", which is not running"
+		log.Error(e)
+		return nil, -1, errors.New(e)
+	}
+
+	//trustees send the full cell, clients only the part used by the round
+	lengthErr := p.checkCipherLength(cipher)
+	if role == Client {
+		lengthErr = p.checkLayoutLength(round.layout, cipher)
+	}
+	if lengthErr != nil {
+		log.Error("Invalid ciphertext for round", roundID, ":", lengthErr)
+		return nil, -1, lengthErr
+	}
+
+	return round, id, nil
+}
+
+// tryFinalizeRounds outputs, in order, the complete rounds, and starts new rounds in their place
+func (p *DissentProtocol) tryFinalizeRounds() error {
+	firstRound := p.nextOutput
+	defer func() {
+		if p.nextOutput != firstRound {
+			p.failedRounds = 0
+			p.armRoundTimeout(p.nextOutput)
+		}
+	}()
+
+	for {
+		round, ok := p.rounds[p.nextOutput]
+		if !ok || !round.isComplete(p.nActiveClients(), p.nTrustees) {
+			break
+		}
+		if err := p.finalizeRound(p.nextOutput, round); err != nil {
+			return err
+		}
+		p.nextOutput++
+		p.trusteeCiphersConsumed()
+
+		if p.config.Toml.RelayProcessingLoopSleepTime > 0 {
+			time.Sleep(time.Duration(p.config.Toml.RelayProcessingLoopSleepTime) * time.Millisecond)
+		}
+	}
+
+	p.fillWindow()
+	return nil
+}
+
+// finalizeRound decodes a complete round and broadcasts its output
+func (p *DissentProtocol) finalizeRound(roundID int, round *roundState) error {
+	cleartext := p.decodeRound(round)
+	delete(p.rounds, roundID)
+
+	message := &ROUND_OUTPUT{
+		RoundID:        roundID,
+		Data:           cleartext,
+		DownstreamData: p.downstreamQueue.pop(),
+	}
+
+	if round.layout.reservation {
+		p.reservationDone(roundID, cleartext)
+		p.sendOutput(message)
+		p.relayHistory.add(message, p.windowSize())
+		return nil
+	}
+
+	//with equivocation protection, the slots only decrypt if all clients saw the same history as us
+	slots := p.splitSlots(cleartext)
+	if p.config.Toml.EquivocationProtectionEnabled {
+		keys := p.recoverSlotKeys(round)
+		message.SlotKeys = make([]kyber.Scalar, len(round.layout.openSlots))
+		for k, s := range round.layout.openSlots {
+			message.SlotKeys[k] = keys[s]
+		}
+		contents, err := openSlots(slots, message.SlotKeys)
+		if err != nil {
+			err = errors.New("equivocation detected in round " + strconv.Itoa(roundID) + ", " + err.Error())
+			p.abort(err)
+			return err
+		}
+		slots = contents
+	}
+
+	if p.config.Toml.DisruptionProtectionEnabled {
+		round.cleartext = cleartext
+		p.keepForBlame(roundID, round)
+		p.checkAccusations(p.expandSlots(round.layout, slots))
+	}
+
+	p.sendOutput(message)
+	p.relayHistory.add(message, p.windowSize())
+
+	return nil
+}
+
+func (p *DissentProtocol) Received_BLAME_REQUEST(msg Struct_BLAME_REQUEST) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.BLAME_REQUEST); err != nil {
+		return err
+	}
+	if err := p.expectState("BLAME_REQUEST", StateRounds, StateBlame); err != nil {
+		return err
+	}
+
+	log.Lvl1("Received_BLAME_REQUEST for round", msg.RoundID, "bit", msg.BitPos)
+
+	if err := p.checkBlameRequest(&msg.BLAME_REQUEST); err != nil {
+		log.Error("Refusing to reveal our pad bits:", err)
+		return err
+	}
+	if err := p.setState(StateBlame); err != nil {
+		return err
+	}
+	p.answeredBlame = &blameAnswer{roundID: msg.RoundID, bitPos: msg.BitPos}
+
+	bits, err := p.revealPadBits(msg.RoundID, msg.BitPos)
+	if err != nil {
+		log.Error("Could not compute our pad bits:", err)
+		return err
+	}
+
+	return p.ms.SendToClient0(&BLAME_BITS{RoundID: msg.RoundID, BitPos: msg.BitPos, PadBits: bits})
+}
+
+func (p *DissentProtocol) Received_BLAME_BITS(msg Struct_BLAME_BITS) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.BLAME_BITS); err != nil {
+		return err
+	}
+	if err := p.expectState("BLAME_BITS", StateBlame); err != nil {
+		return err
+	}
+
+	log.Lvl2("Received_BLAME_BITS from", msg.ServerIdentity)
+
+	if p.role != Client0 || p.blame == nil || p.blame.evidence.RoundID != msg.RoundID || p.blame.evidence.BitPos != msg.BitPos {
+		log.Error("Received BLAME_BITS, but we're not running this blame ! ignoring.")
+		return nil
+	}
+
+	role, id, ok := p.ms.identify(msg.TreeNode)
+	if !ok {
+		e := "Received BLAME_BITS from an unknown node " + msg.ServerIdentity.String()
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	if !p.blame.collectBlameBits(role, id, msg.BLAME_BITS, p.nClients, p.nTrustees) {
+		return nil
+	}
+
+	return p.tryFinishBlame()
+}
+
+func (p *DissentProtocol) Received_BLAME_SECRET_REQUEST(msg Struct_BLAME_SECRET_REQUEST) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.BLAME_SECRET_REQUEST); err != nil {
+		return err
+	}
+	if err := p.expectState("BLAME_SECRET_REQUEST", StateBlame); err != nil {
+		return err
+	}
+
+	log.Lvl1("Received_BLAME_SECRET_REQUEST for client", msg.ClientID)
+
+	if p.role != Trustee || msg.ClientID < 0 || msg.ClientID >= len(p.clientKeys) {
+		e := "Received an invalid BLAME_SECRET_REQUEST"
+		log.Error(e)
+		return errors.New(e)
+	}
+	if err := p.checkSecretRequest(&msg.BLAME_SECRET_REQUEST); err != nil {
+		log.Error("Refusing to reveal our shared secret:", err)
+		return err
+	}
+	p.answeredBlame.secretRevealed = true
+
+	suite := config.CryptoSuite
+	proof, _, secret, err := dleq.NewDLEQProof(suite, suite.Point().Base(), p.clientKeys[msg.ClientID], p.keyPriv)
+	if err != nil {
+		log.Error("Could not prove our shared secret:", err)
+		return err
+	}
+
+	return p.ms.SendToClient0(&BLAME_SECRET{RoundID: msg.RoundID, ClientID: msg.ClientID, Secret: secret, Proof: proof})
+}
+
+func (p *DissentProtocol) Received_BLAME_SECRET(msg Struct_BLAME_SECRET) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.BLAME_SECRET); err != nil {
+		return err
+	}
+	if err := p.expectState("BLAME_SECRET", StateBlame); err != nil {
+		return err
+	}
+
+	log.Lvl2("Received_BLAME_SECRET from", msg.ServerIdentity)
+
+	if p.role != Client0 || p.blame == nil || p.blame.evidence.RoundID != msg.RoundID {
+		log.Error("Received BLAME_SECRET, but we're not running this blame ! ignoring.")
+		return nil
+	}
+
+	role, id, ok := p.ms.identify(msg.TreeNode)
+	if !ok || role != Trustee {
+		e := "Received BLAME_SECRET from a node which is not a trustee " + msg.ServerIdentity.String()
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	e := &p.blame.evidence
+	secret := msg.BLAME_SECRET
+	e.RevealedTrusteeID = id
+	e.RevealedSecret = &secret
+
+	return p.tryFinishBlame()
+}
+
+func (p *DissentProtocol) Received_BLAME_VERDICT(msg Struct_BLAME_VERDICT) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.BLAME_VERDICT); err != nil {
+		return err
+	}
+	if err := p.expectState("BLAME_VERDICT", StateBlame); err != nil {
+		return err
+	}
+
+	e := &msg.Evidence
+	log.Lvl1("Received_BLAME_VERDICT for round", e.RoundID, ":", roleName(e.DisruptorRole), e.DisruptorID, "is the disruptor")
+
+	//the blame is over, whether the verdict is valid or not
+	if err := p.setState(StateRounds); err != nil {
+		return err
+	}
+
+	if err := p.verifyBlameEvidence(e); err != nil {
+		log.Error("Client0 published an invalid blame verdict:", err)
+		return err
+	}
+
+	log.Error("Verified blame evidence:", roleName(e.DisruptorRole), e.DisruptorID, "disrupted round", e.RoundID)
+	return nil
+}
+
+func (p *DissentProtocol) Received_BLAME_FAILED(msg Struct_BLAME_FAILED) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.BLAME_FAILED); err != nil {
+		return err
+	}
+	if err := p.expectState("BLAME_FAILED", StateBlame); err != nil {
+		return err
+	}
+
+	log.Error("Received_BLAME_FAILED for round", msg.RoundID, ":", msg.Reason)
+
+	//the blame is over, the rounds go on
+	return p.setState(StateRounds)
+}
@@ -0,0 +1,244 @@
+package protocols
+
+// This file contains the equivocation protection, used when EquivocationProtectionEnabled is set.
+//
+// Each client keeps a hash h_i of the round outputs it received. The owner of a slot encrypts
+// its content with a fresh key k; then, for each slot, each client sends
+// kappa_i = h_i * sum_j(sigma_ij) (+ k for the owner), where sigma_ij is derived from the secret
+// shared with trustee j, and each trustee sends sigma_j = sum_i(sigma_ij). Client0 recovers
+// k = sum_i(kappa_i) - h * sum_j(sigma_j) only if every client's history h_i equals its own h.
+// A Client0 which showed different outputs to different clients therefore cannot decrypt the slots.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+)
+
+// equivocationTagSize is the size of the integrity tag appended to each slot
+const equivocationTagSize = 16
+
+// slotContentSize is the number of bytes of a slot available for its header and payload
+func (p *DissentProtocol) slotContentSize() int {
+	if p.config.Toml.EquivocationProtectionEnabled {
+		return p.config.Toml.PayloadSize - equivocationTagSize
+	}
+	return p.config.Toml.PayloadSize
+}
+
+// nextHistory chains a round output into a history hash
+func nextHistory(history []byte, output *ROUND_OUTPUT) []byte {
+	h := sha256.New()
+	h.Write(history)
+	roundID := make([]byte, 8)
+	binary.BigEndian.PutUint64(roundID, uint64(output.RoundID))
+	h.Write(roundID)
+	h.Write(output.Data)
+	h.Write(output.DownstreamData)
+	return h.Sum(nil)
+}
+
+// historyChain keeps the history hash after each recent round output.
+//
+// With RelayWindowSize rounds in flight, a client may have to send its ciphertext for round r
+// before receiving the outputs of rounds r-1, ..., r-RelayWindowSize+1. The history used in
+// round r is therefore the one after the output of round r-RelayWindowSize, which everyone
+// received before round r was announced.
+type historyChain struct {
+	last  []byte
+	after map[int][]byte
+}
+
+// add chains a round output, which must be the one following the last added one
+func (c *historyChain) add(output *ROUND_OUTPUT, window int) {
+	if c.after == nil {
+		c.after = make(map[int][]byte)
+	}
+	c.last = nextHistory(c.last, output)
+	c.after[output.RoundID] = c.last
+	//rounds after this output need at most the history after round RoundID-window+1
+	delete(c.after, output.RoundID-window)
+}
+
+// at returns the history to use in the given round
+func (c *historyChain) at(roundID, window int) []byte {
+	return c.after[roundID-window]
+}
+
+// historyScalar maps a history hash to a scalar
+func historyScalar(history []byte) kyber.Scalar {
+	suite := config.CryptoSuite
+	return suite.Scalar().Pick(suite.XOF(append([]byte("history"), history...)))
+}
+
+// equivocationPads returns one scalar per slot derived from a shared secret, for the given round
+func equivocationPads(secret kyber.Point, roundID, nSlots int) ([]kyber.Scalar, error) {
+	suite := config.CryptoSuite
+	secretBytes, err := secret.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	seed := append([]byte("equivocation"), secretBytes...)
+	roundBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(roundBytes, uint64(roundID))
+	xof := suite.XOF(append(seed, roundBytes...))
+
+	pads := make([]kyber.Scalar, nSlots)
+	for s := range pads {
+		pads[s] = suite.Scalar().Pick(xof)
+	}
+	return pads, nil
+}
+
+// sumEquivocationPads returns, for each slot, the sum of the scalars shared with every peer, except the excluded ones
+func (p *DissentProtocol) sumEquivocationPads(roundID int, excluded map[int]bool) ([]kyber.Scalar, error) {
+	sums := make([]kyber.Scalar, p.nClients)
+	for s := range sums {
+		sums[s] = config.CryptoSuite.Scalar().Zero()
+	}
+	for i, secret := range p.sharedSecrets {
+		if excluded[i] {
+			continue
+		}
+		pads, err := equivocationPads(secret, roundID, p.nClients)
+		if err != nil {
+			return nil, err
+		}
+		for s := range sums {
+			sums[s].Add(sums[s], pads[s])
+		}
+	}
+	return sums, nil
+}
+
+// clientKappas returns the kappa of each slot; slotKey, if any, is added to the one of our slot
+func (p *DissentProtocol) clientKappas(roundID int, slotKey kyber.Scalar) ([]kyber.Scalar, error) {
+	kappas, err := p.sumEquivocationPads(roundID, nil)
+	if err != nil {
+		return nil, err
+	}
+	h := historyScalar(p.history.at(roundID, p.windowSize()))
+	for s := range kappas {
+		kappas[s].Mul(h, kappas[s])
+	}
+	if slotKey != nil {
+		kappas[p.mySlot()].Add(kappas[p.mySlot()], slotKey)
+	}
+	return kappas, nil
+}
+
+// slotKeystream XORs into buf the keystream derived from a slot key
+func slotKeystream(buf []byte, slotKey kyber.Scalar) error {
+	keyBytes, err := slotKey.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	config.CryptoSuite.XOF(keyBytes).XORKeyStream(buf, buf)
+	return nil
+}
+
+// slotTag computes the integrity tag of a slot content
+func slotTag(slotKey kyber.Scalar, content []byte) ([]byte, error) {
+	keyBytes, err := slotKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write(keyBytes)
+	h.Write(content)
+	return h.Sum(nil)[:equivocationTagSize], nil
+}
+
+// sealSlot encrypts a slot content with a fresh key, and returns the key and the encrypted slot
+func sealSlot(content []byte) (kyber.Scalar, []byte, error) {
+	suite := config.CryptoSuite
+	slotKey := suite.Scalar().Pick(suite.RandomStream())
+
+	tag, err := slotTag(slotKey, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	sealed := append(append([]byte{}, content...), tag...)
+	if err := slotKeystream(sealed, slotKey); err != nil {
+		return nil, nil, err
+	}
+	return slotKey, sealed, nil
+}
+
+// openSlot decrypts a slot with its key, and checks its integrity
+func openSlot(slotKey kyber.Scalar, sealed []byte) ([]byte, error) {
+	if len(sealed) < equivocationTagSize {
+		return nil, errors.New("slot too short")
+	}
+	//the slot of a client excluded after a timeout stays empty, and has no key
+	if slotKey.Equal(config.CryptoSuite.Scalar().Zero()) && bytes.Equal(sealed, make([]byte, len(sealed))) {
+		return make([]byte, len(sealed)-equivocationTagSize), nil
+	}
+
+	opened := append([]byte{}, sealed...)
+	if err := slotKeystream(opened, slotKey); err != nil {
+		return nil, err
+	}
+	content := opened[:len(opened)-equivocationTagSize]
+	tag, err := slotTag(slotKey, content)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tag, opened[len(opened)-equivocationTagSize:]) {
+		return nil, errors.New("integrity check failed")
+	}
+	return content, nil
+}
+
+// recoverSlotKeys is called on Client0; it computes the key of each slot from the kappas and sigmas of a round
+func (p *DissentProtocol) recoverSlotKeys(round *roundState) []kyber.Scalar {
+	suite := config.CryptoSuite
+	h := historyScalar(round.history)
+
+	keys := make([]kyber.Scalar, p.nClients)
+	for s := range keys {
+		sigmas := suite.Scalar().Zero()
+		for _, trusteeSigmas := range round.trusteeSigmas {
+			sigmas.Add(sigmas, trusteeSigmas[s])
+		}
+		keys[s] = suite.Scalar().Neg(suite.Scalar().Mul(h, sigmas))
+		for _, clientKappas := range round.clientKappas {
+			keys[s].Add(keys[s], clientKappas[s])
+		}
+	}
+	return keys
+}
+
+// openSlots decrypts every slot with its key; an error means that the clients do not share the same history
+func openSlots(slots [][]byte, slotKeys []kyber.Scalar) ([][]byte, error) {
+	if len(slotKeys) != len(slots) {
+		return nil, errors.New("expected " + strconv.Itoa(len(slots)) + " slot keys, got " + strconv.Itoa(len(slotKeys)))
+	}
+	contents := make([][]byte, len(slots))
+	for s := range slots {
+		content, err := openSlot(slotKeys[s], slots[s])
+		if err != nil {
+			return nil, errors.New("slot " + strconv.Itoa(s) + ": " + err.Error())
+		}
+		contents[s] = content
+	}
+	return contents, nil
+}
+
+// checkScalars verifies that a node sent one scalar per slot
+func (p *DissentProtocol) checkScalars(scalars []kyber.Scalar) error {
+	if len(scalars) != p.nClients {
+		return errors.New("received " + strconv.Itoa(len(scalars)) + " equivocation scalars, expected " + strconv.Itoa(p.nClients))
+	}
+	for _, s := range scalars {
+		if s == nil {
+			return errors.New("an equivocation scalar is missing")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package protocols
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+)
+
+func TestHistoryChain(t *testing.T) {
+	outputs := make([]*ROUND_OUTPUT, 4)
+	for r := range outputs {
+		outputs[r] = &ROUND_OUTPUT{RoundID: r, Data: []byte{byte(r)}}
+	}
+	tests := []struct {
+		name   string
+		window int
+	}{
+		{"no pipelining", 1},
+		{"window of 2", 2},
+		{"window of 3", 3},
+	}
+	for _, test := range tests {
+		var c historyChain
+		var expected []byte
+		for r, output := range outputs {
+			expected = nextHistory(expected, output)
+			c.add(output, test.window)
+			//round r+window is announced once the output of round r is received
+			if h := c.at(r+test.window, test.window); !bytes.Equal(h, expected) {
+				t.Errorf("%s: wrong history for round %d", test.name, r+test.window)
+			}
+			if len(c.after) > test.window {
+				t.Errorf("%s: %d histories kept", test.name, len(c.after))
+			}
+		}
+		if c.at(0, test.window) != nil {
+			t.Errorf("%s: the first rounds have a history", test.name)
+		}
+	}
+
+	//the history depends on every output seen
+	var a, b historyChain
+	a.add(outputs[0], 1)
+	b.add(&ROUND_OUTPUT{RoundID: 0, Data: []byte{9}}, 1)
+	a.add(outputs[1], 1)
+	b.add(outputs[1], 1)
+	if bytes.Equal(a.at(2, 1), b.at(2, 1)) {
+		t.Error("two different outputs lead to the same history")
+	}
+}
+
+func TestSealOpenSlot(t *testing.T) {
+	suite := config.CryptoSuite
+	content := []byte("the content of a slot")
+	slotKey, sealed, err := sealSlot(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != len(content)+equivocationTagSize || bytes.Contains(sealed, content) {
+		t.Fatal("the slot is not encrypted")
+	}
+
+	flipped := append([]byte{}, sealed...)
+	flipped[3] ^= 0x01
+	flippedTag := append([]byte{}, sealed...)
+	flippedTag[len(flippedTag)-1] ^= 0x01
+	empty := make([]byte, len(sealed))
+
+	tests := []struct {
+		name    string
+		key     kyber.Scalar
+		sealed  []byte
+		content []byte
+		ok      bool
+	}{
+		{"valid", slotKey, sealed, content, true},
+		{"wrong key", suite.Scalar().Pick(suite.RandomStream()), sealed, nil, false},
+		{"flipped content", slotKey, flipped, nil, false},
+		{"flipped tag", slotKey, flippedTag, nil, false},
+		{"too short", slotKey, sealed[:equivocationTagSize-1], nil, false},
+		{"slot of an excluded client", suite.Scalar().Zero(), empty, make([]byte, len(content)), true},
+		{"empty slot with a key", slotKey, empty, nil, false},
+	}
+	for _, test := range tests {
+		opened, err := openSlot(test.key, test.sealed)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if test.ok && !bytes.Equal(opened, test.content) {
+			t.Errorf("%s: opened %q, expected %q", test.name, opened, test.content)
+		}
+	}
+}
+
+func TestRecoverSlotKeys(t *testing.T) {
+	suite := config.CryptoSuite
+	const nClients, nTrustees, roundID = 3, 2, 1
+	pick := func() (kyber.Scalar, kyber.Point) {
+		priv := suite.Scalar().Pick(suite.RandomStream())
+		return priv, suite.Point().Mul(priv, nil)
+	}
+	toml := &DissentTomlConfig{RelayWindowSize: 1, EquivocationProtectionEnabled: true}
+	clientPriv := make([]kyber.Scalar, nClients)
+	clientKeys := make([]kyber.Point, nClients)
+	trusteePriv := make([]kyber.Scalar, nTrustees)
+	trusteeKeys := make([]kyber.Point, nTrustees)
+	for i := range clientPriv {
+		clientPriv[i], clientKeys[i] = pick()
+	}
+	for j := range trusteePriv {
+		trusteePriv[j], trusteeKeys[j] = pick()
+	}
+	seen := &ROUND_OUTPUT{RoundID: 0, Data: []byte("output of round 0")}
+	forged := &ROUND_OUTPUT{RoundID: 0, Data: []byte("another output of round 0")}
+
+	tests := []struct {
+		name    string
+		forged  int // the client shown another output, or -1
+		matches bool
+	}{
+		{"same history", -1, true},
+		{"equivocation", 1, false},
+	}
+	for _, test := range tests {
+		var history historyChain
+		history.add(seen, 1)
+		round := newRoundState(history.at(roundID, 1))
+		slotKeys := make([]kyber.Scalar, nClients)
+		for i := 0; i < nClients; i++ {
+			c := &DissentProtocol{nClients: nClients, slot: (i + 1) % nClients, keyPriv: clientPriv[i], config: DissentProtocolConfig{Toml: toml}}
+			c.sharedSecrets = c.deriveSharedSecrets(trusteeKeys)
+			if i == test.forged {
+				c.history.add(forged, 1)
+			} else {
+				c.history.add(seen, 1)
+			}
+			slotKeys[c.slot] = suite.Scalar().Pick(suite.RandomStream())
+			kappas, err := c.clientKappas(roundID, slotKeys[c.slot])
+			if err != nil {
+				t.Fatal(err)
+			}
+			round.clientKappas[i] = kappas
+		}
+		for j := 0; j < nTrustees; j++ {
+			tr := &DissentProtocol{nClients: nClients, keyPriv: trusteePriv[j]}
+			tr.sharedSecrets = tr.deriveSharedSecrets(clientKeys)
+			sigmas, err := tr.sumEquivocationPads(roundID, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			round.trusteeSigmas[j] = sigmas
+		}
+
+		client0 := &DissentProtocol{nClients: nClients}
+		recovered := client0.recoverSlotKeys(round)
+		for s := range recovered {
+			if recovered[s].Equal(slotKeys[s]) != test.matches {
+				t.Errorf("%s: the key of slot %d is recovered %v", test.name, s, !test.matches)
+			}
+		}
+	}
+}
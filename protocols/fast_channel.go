@@ -0,0 +1,434 @@
+package protocols
+
+// This file contains the fast downstream channel (UseUDP).
+//
+// Sending each round output to every client over TCP costs N times the downstream cell, so on a
+// local network Client0 broadcasts the outputs over UDP instead, on the port of each client plus
+// fastChannelPortOffset. Each output is signed by Client0 with a broadcast sequence number, and cut
+// into fragments which fit in a datagram. Each datagram is signed too, with the session ID, so that
+// the clients only store the fragments of Client0 (anyone on the network can send datagrams), and
+// never more fragments than the largest possible output. The clients deliver the outputs in order
+// of sequence number; when one is missing for fastNackDelay while a later one arrived, or when
+// nothing arrived for fastSilenceDelay while a round is in flight, a client sends a DOWNSTREAM_NACK
+// over TCP, and Client0 retransmits the missing outputs over TCP.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2/sign/schnorr"
+	"gopkg.in/dedis/onet.v2"
+	"gopkg.in/dedis/onet.v2/log"
+	"gopkg.in/dedis/onet.v2/network"
+)
+
+const (
+	// fastChannelPortOffset is added to the port of a node to get the port of its fast channel
+	fastChannelPortOffset = 3
+	// fastHeaderSize is the size of the header of a datagram: sequence number, fragment index, number of fragments
+	fastHeaderSize = 8 + 2 + 2
+	// fastSignatureSize is the size of the Schnorr signature of a datagram, which follows the header
+	fastSignatureSize = 64
+	// fastOutputOverhead bounds what a signed output adds to its data, without the slot keys
+	fastOutputOverhead = 1024
+	// fastSlotKeySize bounds the encoded size of the key of a slot
+	fastSlotKeySize = 64
+	// fastFragmentSize is the maximum payload of a datagram, which fits in an Ethernet frame
+	fastFragmentSize = 1400
+	// fastRetransmitBuffer is the number of outputs Client0 keeps for the retransmissions
+	fastRetransmitBuffer = 256
+	// fastNackDelay is the time a client waits for a missing output before asking for it
+	fastNackDelay = 50 * time.Millisecond
+	// fastMinSilenceDelay is the shortest silence after which a client asks for the outputs of a round in flight
+	fastMinSilenceDelay = 500 * time.Millisecond
+)
+
+// fastChannel holds the UDP socket of the fast channel, and its sequence numbers
+type fastChannel struct {
+	sync.Mutex
+	conn *net.UDPConn
+
+	//used by Client0
+	nextSeq int
+	sent    map[int]*ROUND_OUTPUT // the last broadcasted outputs, by sequence number
+	targets []*net.UDPAddr
+
+	//used by the clients
+	nextDeliver  int
+	fragments    map[int][][]byte      // the fragments received so far, by sequence number
+	pending      map[int]*ROUND_OUTPUT // the outputs received before the previous ones
+	lastProgress time.Time
+}
+
+func (f *fastChannel) close() {
+	if f.conn != nil {
+		f.conn.Close()
+	}
+}
+
+// fastChannelPort returns the port of the fast channel of a node
+func fastChannelPort(node *onet.TreeNode) int {
+	port, _ := strconv.Atoi(node.ServerIdentity.Address.Port())
+	return port + fastChannelPortOffset
+}
+
+// startFastChannel opens the UDP socket of the fast channel; Client0 broadcasts to the port of each
+// client, and the other clients listen on their own port
+func (p *DissentProtocol) startFastChannel() error {
+	f := &fastChannel{
+		sent:         make(map[int]*ROUND_OUTPUT),
+		fragments:    make(map[int][][]byte),
+		pending:      make(map[int]*ROUND_OUTPUT),
+		lastProgress: time.Now(),
+	}
+
+	if p.role == Client0 {
+		conn, err := net.ListenUDP("udp4", nil)
+		if err != nil {
+			return err
+		}
+		f.conn = conn
+
+		//clients on the same port receive the same broadcast
+		ports := make(map[int]bool)
+		for i, client := range p.ms.clients {
+			port := fastChannelPort(client)
+			if i == p.myID || ports[port] {
+				continue
+			}
+			ports[port] = true
+			f.targets = append(f.targets, &net.UDPAddr{IP: net.IPv4bcast, Port: port})
+		}
+		p.fast = f
+		log.Lvl2("Client0 : fast channel ready, broadcasting to", len(f.targets), "ports")
+		return nil
+	}
+
+	port := fastChannelPort(p.TreeNode())
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	p.fast = f
+	log.Lvl2("Listening for the fast channel on port", port)
+
+	go p.receiveFast()
+	go p.watchFast()
+	return nil
+}
+
+// sendOutput is called on Client0 to send a round output to every client, on the fast channel if it is enabled
+func (p *DissentProtocol) sendOutput(msg *ROUND_OUTPUT) {
+	if p.fast == nil {
+		p.sendToActiveClients(msg)
+		return
+	}
+	//we are a client too, and do not listen to our own broadcast
+	p.ms.SendToClient(p.myID, msg)
+	if err := p.broadcastOutput(msg); err != nil {
+		log.Error("Could not broadcast the output of round", msg.RoundID, ":", err)
+	}
+}
+
+// broadcastOutput signs a round output with the next broadcast sequence number, and sends it in fragments
+func (p *DissentProtocol) broadcastOutput(msg *ROUND_OUTPUT) error {
+	f := p.fast
+	f.Lock()
+	defer f.Unlock()
+
+	signed, err := signMessage(msg, p.Private(), p.ms.auth.sessionID, f.nextSeq)
+	if err != nil {
+		return err
+	}
+	output := signed.(*ROUND_OUTPUT)
+	data, err := network.Marshal(output)
+	if err != nil {
+		return err
+	}
+	seq := f.nextSeq
+	f.sent[seq] = output
+	delete(f.sent, seq-fastRetransmitBuffer)
+	f.nextSeq++
+
+	nFragments := (len(data) + fastFragmentSize - 1) / fastFragmentSize
+	for k := 0; k < nFragments; k++ {
+		end := (k + 1) * fastFragmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		datagram, err := p.fastDatagram(seq, k, nFragments, data[k*fastFragmentSize:end])
+		if err != nil {
+			return err
+		}
+
+		for _, target := range f.targets {
+			if _, err := f.conn.WriteToUDP(datagram, target); err != nil {
+				log.Lvl2("Could not send a datagram to", target, ":", err)
+			}
+		}
+	}
+	return nil
+}
+
+// fastDatagram returns a signed datagram carrying a fragment of the output with the given sequence number
+func (p *DissentProtocol) fastDatagram(seq, k, nFragments int, fragment []byte) ([]byte, error) {
+	datagram := make([]byte, fastHeaderSize+fastSignatureSize, fastHeaderSize+fastSignatureSize+len(fragment))
+	binary.BigEndian.PutUint64(datagram[0:8], uint64(seq))
+	binary.BigEndian.PutUint16(datagram[8:10], uint16(k))
+	binary.BigEndian.PutUint16(datagram[10:12], uint16(nFragments))
+	datagram = append(datagram, fragment...)
+
+	signature, err := schnorr.Sign(config.CryptoSuite, p.Private(), p.fastSignedData(datagram))
+	if err != nil {
+		return nil, err
+	}
+	if len(signature) != fastSignatureSize {
+		return nil, errors.New("unexpected signature size " + strconv.Itoa(len(signature)))
+	}
+	copy(datagram[fastHeaderSize:], signature)
+	return datagram, nil
+}
+
+// fastSignedData returns what the signature of a datagram covers: the session ID, the header and the fragment
+func (p *DissentProtocol) fastSignedData(datagram []byte) []byte {
+	data := make([]byte, 0, len(p.ms.auth.sessionID)+len(datagram)-fastSignatureSize)
+	data = append(data, p.ms.auth.sessionID...)
+	data = append(data, datagram[:fastHeaderSize]...)
+	return append(data, datagram[fastHeaderSize+fastSignatureSize:]...)
+}
+
+// checkFastDatagram verifies that a datagram was signed by Client0 for this session
+func (p *DissentProtocol) checkFastDatagram(datagram []byte) error {
+	if len(datagram) < fastHeaderSize+fastSignatureSize {
+		return errors.New("datagram too short")
+	}
+	signature := datagram[fastHeaderSize : fastHeaderSize+fastSignatureSize]
+	return schnorr.Verify(config.CryptoSuite, p.ms.client0.ServerIdentity.Public, p.fastSignedData(datagram), signature)
+}
+
+// maxFastFragments returns the number of fragments of the largest output Client0 can send
+func (p *DissentProtocol) maxFastFragments() int {
+	toml := p.config.Toml
+	maxOutputSize := p.nClients*(toml.PayloadSize+fastSlotKeySize) + toml.CellSizeDown + fastOutputOverhead
+	return (maxOutputSize + fastFragmentSize - 1) / fastFragmentSize
+}
+
+// fastSilenceDelay returns the time without any output after which a client asks for the outputs of a round in flight
+func fastSilenceDelay(toml *DissentTomlConfig) time.Duration {
+	delay := time.Duration(toml.RelayRoundTimeOut) * time.Millisecond
+	if delay < fastMinSilenceDelay {
+		return fastMinSilenceDelay
+	}
+	return delay
+}
+
+// receiveFast is run by the clients in their own goroutine; it reassembles the datagrams of the fast channel
+func (p *DissentProtocol) receiveFast() {
+	f := p.fast
+	buf := make([]byte, 65536)
+	for !p.HasStopped {
+		n, _, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !p.HasStopped {
+				log.Error("Fast channel stopped:", err)
+			}
+			return
+		}
+		if err := p.checkFastDatagram(buf[:n]); err != nil {
+			log.Lvl3("Dropped a datagram of the fast channel:", err)
+			continue
+		}
+		seq := int(binary.BigEndian.Uint64(buf[0:8]))
+		k := int(binary.BigEndian.Uint16(buf[8:10]))
+		nFragments := int(binary.BigEndian.Uint16(buf[10:12]))
+
+		data := p.addFragment(seq, k, nFragments, append([]byte{}, buf[fastHeaderSize+fastSignatureSize:n]...))
+		if data == nil {
+			continue
+		}
+		_, msg, err := network.Unmarshal(data, config.CryptoSuite)
+		if err != nil {
+			log.Error("Could not decode an output of the fast channel:", err)
+			continue
+		}
+		output, ok := msg.(*ROUND_OUTPUT)
+		if !ok {
+			log.Error("Received something else than a round output on the fast channel")
+			continue
+		}
+		p.fastReceived(output)
+	}
+}
+
+// addFragment stores a fragment, and returns the whole output once all its fragments arrived
+func (p *DissentProtocol) addFragment(seq, k, nFragments int, fragment []byte) []byte {
+	f := p.fast
+	f.Lock()
+	defer f.Unlock()
+
+	//outputs already delivered, too far ahead to be legitimate, or larger than any output
+	if seq < f.nextDeliver || seq >= f.nextDeliver+fastRetransmitBuffer || k >= nFragments || nFragments > p.maxFastFragments() {
+		return nil
+	}
+	fragments, ok := f.fragments[seq]
+	if !ok {
+		fragments = make([][]byte, nFragments)
+		f.fragments[seq] = fragments
+	} else if len(fragments) != nFragments {
+		log.Lvl2("Dropped a fragment of the output with sequence number", seq, "with an inconsistent number of fragments")
+		return nil
+	}
+	fragments[k] = fragment
+	for _, fr := range fragments {
+		if fr == nil {
+			return nil
+		}
+	}
+	delete(f.fragments, seq)
+	return bytes.Join(fragments, nil)
+}
+
+// fastReceived verifies an output signed for the fast channel, and delivers the outputs which are next in order
+func (p *DissentProtocol) fastReceived(output *ROUND_OUTPUT) {
+	if err := p.checkFastSignature(output); err != nil {
+		log.Error("Rejected an output of the fast channel:", err)
+		return
+	}
+
+	f := p.fast
+	f.Lock()
+	defer f.Unlock()
+
+	if output.Seq < f.nextDeliver {
+		return
+	}
+	f.pending[output.Seq] = output
+	for {
+		next, ok := f.pending[f.nextDeliver]
+		if !ok {
+			break
+		}
+		delete(f.pending, f.nextDeliver)
+		delete(f.fragments, f.nextDeliver)
+		f.nextDeliver++
+		f.lastProgress = time.Now()
+
+		if err := p.expectState("ROUND_OUTPUT", StateRounds, StateBlame); err != nil {
+			continue
+		}
+		p.handleRoundOutput(next)
+	}
+}
+
+// checkFastSignature verifies that an output was signed by Client0 for this session
+func (p *DissentProtocol) checkFastSignature(output *ROUND_OUTPUT) error {
+	if !bytes.Equal(output.SessionID, p.ms.auth.sessionID) {
+		return errors.New("output from another session")
+	}
+	data, err := signedData(output)
+	if err != nil {
+		return err
+	}
+	if err := schnorr.Verify(config.CryptoSuite, p.ms.client0.ServerIdentity.Public, data, output.Signature); err != nil {
+		return errors.New("invalid signature: " + err.Error())
+	}
+	return nil
+}
+
+// watchFast is run by the clients in their own goroutine; it asks Client0 for the outputs which did not arrive
+func (p *DissentProtocol) watchFast() {
+	f := p.fast
+	for !p.HasStopped {
+		time.Sleep(fastNackDelay)
+
+		p.clientLock.Lock()
+		waiting := len(p.layouts) > 0
+		p.clientLock.Unlock()
+
+		f.Lock()
+		//a later output, or a part of one, arrived: some datagrams were lost
+		gap := len(f.pending) > 0 || len(f.fragments) > 0
+		//nothing arrived at all for a round in flight: the last outputs were lost, or a node is slow
+		silent := waiting && time.Since(f.lastProgress) >= fastSilenceDelay(p.config.Toml)
+		if (gap || silent) && time.Since(f.lastProgress) >= fastNackDelay {
+			log.Lvl2("Missing the output with sequence number", f.nextDeliver, "on the fast channel, asking Client0")
+			f.lastProgress = time.Now()
+			if err := p.ms.SendToClient0(&DOWNSTREAM_NACK{From: f.nextDeliver}); err != nil {
+				log.Error("Could not send a DOWNSTREAM_NACK:", err)
+			}
+		}
+		f.Unlock()
+	}
+}
+
+func (p *DissentProtocol) Received_DOWNSTREAM_NACK(msg Struct_DOWNSTREAM_NACK) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.DOWNSTREAM_NACK); err != nil {
+		return err
+	}
+	if err := p.expectState("DOWNSTREAM_NACK", StateRounds, StateBlame); err != nil {
+		return err
+	}
+
+	log.Lvl2("Received_DOWNSTREAM_NACK from", msg.ServerIdentity, "for the outputs from", msg.From)
+
+	if p.role != Client0 || p.fast == nil {
+		e := "Received DOWNSTREAM_NACK, but we're not Client0 or the fast channel is disabled"
+		log.Error(e)
+		return errors.New(e)
+	}
+	role, id, ok := p.ms.identify(msg.TreeNode)
+	if !ok || role != Client {
+		e := "Received DOWNSTREAM_NACK from an unexpected node " + msg.ServerIdentity.String()
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	f := p.fast
+	f.Lock()
+	outputs := make([]ROUND_OUTPUT, 0)
+	for seq := msg.From; seq < f.nextSeq; seq++ {
+		output, ok := f.sent[seq]
+		if !ok {
+			log.Error("Client", id, "missed the output with sequence number", seq, ", which is not kept anymore")
+			continue
+		}
+		outputs = append(outputs, *output)
+	}
+	f.Unlock()
+
+	if len(outputs) == 0 {
+		return nil
+	}
+	return p.ms.SendToClient(id, &DOWNSTREAM_RETRANSMIT{Outputs: outputs})
+}
+
+func (p *DissentProtocol) Received_DOWNSTREAM_RETRANSMIT(msg Struct_DOWNSTREAM_RETRANSMIT) error {
+
+	if err := p.authenticate(msg.TreeNode, &msg.DOWNSTREAM_RETRANSMIT); err != nil {
+		return err
+	}
+	if err := p.expectState("DOWNSTREAM_RETRANSMIT", StateRounds, StateBlame); err != nil {
+		return err
+	}
+
+	log.Lvl2("Received_DOWNSTREAM_RETRANSMIT with", len(msg.Outputs), "outputs")
+
+	if p.fast == nil || p.role == Client0 {
+		e := "Received DOWNSTREAM_RETRANSMIT, but we don't listen to the fast channel"
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	for i := range msg.Outputs {
+		p.fastReceived(&msg.Outputs[i])
+	}
+	return nil
+}
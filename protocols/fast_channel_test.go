@@ -0,0 +1,74 @@
+package protocols
+
+import (
+	"testing"
+	"time"
+)
+
+// fastProtocol returns a client with only what the reassembly of the fast channel needs
+func fastProtocol(nClients, payloadSize, cellSizeDown int) *DissentProtocol {
+	return &DissentProtocol{
+		nClients: nClients,
+		config:   DissentProtocolConfig{Toml: &DissentTomlConfig{PayloadSize: payloadSize, CellSizeDown: cellSizeDown}},
+		fast:     &fastChannel{fragments: make(map[int][][]byte), pending: make(map[int]*ROUND_OUTPUT)},
+	}
+}
+
+func TestAddFragment(t *testing.T) {
+	p := fastProtocol(2, 1000, 2000) //at most 4 fragments
+	if max := p.maxFastFragments(); max != 4 {
+		t.Fatalf("at most %d fragments, expected 4", max)
+	}
+
+	type datagram struct {
+		seq, k, n int
+	}
+	tests := []struct {
+		name      string
+		datagrams []datagram
+		complete  int // the datagram which completes an output, or -1
+		stored    int // the outputs left incomplete
+	}{
+		{"single fragment", []datagram{{0, 0, 1}}, 0, 0},
+		{"several fragments", []datagram{{0, 1, 3}, {0, 0, 3}, {0, 2, 3}}, 2, 0},
+		{"largest output", []datagram{{0, 0, 4}, {0, 1, 4}, {0, 2, 4}, {0, 3, 4}}, 3, 0},
+		{"too many fragments", []datagram{{0, 0, 5}}, -1, 0},
+		{"huge count", []datagram{{0, 0, 65535}}, -1, 0},
+		{"index out of range", []datagram{{0, 2, 2}}, -1, 0},
+		{"inconsistent count", []datagram{{0, 0, 2}, {0, 1, 3}}, -1, 1},
+		{"inconsistent count does not complete", []datagram{{0, 0, 2}, {0, 1, 1}, {0, 1, 2}}, 2, 0},
+		{"too far ahead", []datagram{{fastRetransmitBuffer, 0, 1}}, -1, 0},
+	}
+	for _, test := range tests {
+		p := fastProtocol(2, 1000, 2000)
+		complete := -1
+		for d, dg := range test.datagrams {
+			if p.addFragment(dg.seq, dg.k, dg.n, []byte{byte(dg.k)}) != nil {
+				complete = d
+			}
+		}
+		if complete != test.complete {
+			t.Errorf("%s: datagram %d completed an output, expected %d", test.name, complete, test.complete)
+		}
+		if len(p.fast.fragments) != test.stored {
+			t.Errorf("%s: %d incomplete outputs, expected %d", test.name, len(p.fast.fragments), test.stored)
+		}
+	}
+}
+
+func TestFastSilenceDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout int
+		delay   time.Duration
+	}{
+		{"no round timeout", 0, fastMinSilenceDelay},
+		{"short round timeout", 10, fastMinSilenceDelay},
+		{"round timeout", 2000, 2 * time.Second},
+	}
+	for _, test := range tests {
+		if delay := fastSilenceDelay(&DissentTomlConfig{RelayRoundTimeOut: test.timeout}); delay != test.delay {
+			t.Errorf("%s: %v, expected %v", test.name, delay, test.delay)
+		}
+	}
+}
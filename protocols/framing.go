@@ -0,0 +1,214 @@
+package protocols
+
+// This file contains the framing of the messages larger than a slot.
+//
+// SendMessage cuts a message into fragments, each sent in our slot in its own round with the slot
+// kind slotFragment. A fragment carries the ID of its message, its index, the number of fragments,
+// its length, and a checksum. Every client reassembles the messages of every slot; a fragment whose
+// checksum fails was corrupted (a disruption, or a collision if two clients think they own the
+// slot), which is told apart from the padding of empty slots. A message which gets no fragment for
+// fragmentTimeoutRounds rounds is dropped.
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strconv"
+
+	"gopkg.in/dedis/onet.v2/log"
+)
+
+// fragmentHeaderSize is the size of the header of a fragment: message ID, index, count, length, checksum
+const fragmentHeaderSize = 4 + 2 + 2 + 2 + 4
+
+// fragmentTimeoutRounds is the number of rounds after which an incomplete message is dropped
+const fragmentTimeoutRounds = 100
+
+// maxFragments is the maximum number of fragments of a message
+const maxFragments = 0xffff
+
+// fragment is a part of a message, as sent in a slot
+type fragment struct {
+	messageID uint32
+	index     int
+	count     int
+	data      []byte
+}
+
+func (f *fragment) encode() []byte {
+	buf := make([]byte, fragmentHeaderSize+len(f.data))
+	binary.BigEndian.PutUint32(buf[0:4], f.messageID)
+	binary.BigEndian.PutUint16(buf[4:6], uint16(f.index))
+	binary.BigEndian.PutUint16(buf[6:8], uint16(f.count))
+	binary.BigEndian.PutUint16(buf[8:10], uint16(len(f.data)))
+	copy(buf[fragmentHeaderSize:], f.data)
+	binary.BigEndian.PutUint32(buf[10:14], fragmentChecksum(buf))
+	return buf
+}
+
+// fragmentChecksum covers the header, except the checksum itself, and the data of a fragment
+func fragmentChecksum(buf []byte) uint32 {
+	h := crc32.NewIEEE()
+	h.Write(buf[0:10])
+	length := int(binary.BigEndian.Uint16(buf[8:10]))
+	h.Write(buf[fragmentHeaderSize : fragmentHeaderSize+length])
+	return h.Sum32()
+}
+
+// decodeFragment parses a fragment, and checks its checksum
+func decodeFragment(buf []byte) (*fragment, error) {
+	if len(buf) < fragmentHeaderSize {
+		return nil, errors.New("fragment too short")
+	}
+	length := int(binary.BigEndian.Uint16(buf[8:10]))
+	if fragmentHeaderSize+length > len(buf) {
+		return nil, errors.New("fragment length " + strconv.Itoa(length) + " exceeds the slot")
+	}
+	if binary.BigEndian.Uint32(buf[10:14]) != fragmentChecksum(buf) {
+		return nil, errors.New("checksum mismatch")
+	}
+	f := &fragment{
+		messageID: binary.BigEndian.Uint32(buf[0:4]),
+		index:     int(binary.BigEndian.Uint16(buf[4:6])),
+		count:     int(binary.BigEndian.Uint16(buf[6:8])),
+		data:      append([]byte{}, buf[fragmentHeaderSize:fragmentHeaderSize+length]...),
+	}
+	if f.count == 0 || f.index >= f.count {
+		return nil, errors.New("fragment " + strconv.Itoa(f.index) + " of " + strconv.Itoa(f.count))
+	}
+	return f, nil
+}
+
+// MaxMessageSize returns the maximum size of the data passed to SendMessage
+func MaxMessageSize(toml *DissentTomlConfig) int {
+	return maxFragments * (MaxUpstreamPayload(toml) - fragmentHeaderSize)
+}
+
+// SendMessage enqueues a message of any size up to MaxMessageSize, sent in fragments in this client's slot
+func (p *DissentProtocol) SendMessage(data []byte) error {
+	if !p.isClient() {
+		return errors.New("only clients can send messages")
+	}
+	if len(data) > MaxMessageSize(p.config.Toml) {
+		return errors.New("message of " + strconv.Itoa(len(data)) + " bytes is larger than " +
+			strconv.Itoa(MaxMessageSize(p.config.Toml)) + " bytes")
+	}
+	idBytes := make([]byte, 4)
+	if _, err := rand.Read(idBytes); err != nil {
+		return err
+	}
+	messageID := binary.BigEndian.Uint32(idBytes)
+
+	fragmentSize := MaxUpstreamPayload(p.config.Toml) - fragmentHeaderSize
+	count := (len(data) + fragmentSize - 1) / fragmentSize
+	if count == 0 {
+		count = 1
+	}
+	for k := 0; k < count; k++ {
+		end := (k + 1) * fragmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		f := &fragment{messageID: messageID, index: k, count: count, data: data[k*fragmentSize : end]}
+		p.fragmentQueue.push(f.encode())
+	}
+	return nil
+}
+
+// SetMessageHandler registers the function called with each message reassembled, and the slot it was sent in
+func (p *DissentProtocol) SetMessageHandler(handler func(roundID int, slot int, data []byte)) {
+	p.messageHandler = handler
+}
+
+// reassemblyKey identifies a message being reassembled
+type reassemblyKey struct {
+	slot      int
+	messageID uint32
+}
+
+// partialMessage is a message being reassembled
+type partialMessage struct {
+	fragments [][]byte
+	received  int
+	lastRound int
+}
+
+// reassembler rebuilds the messages sent in fragments
+type reassembler struct {
+	pending   map[reassemblyKey]*partialMessage
+	corrupted int // the number of fragments which failed their checksum
+	expired   int // the number of messages dropped before they were complete
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{pending: make(map[reassemblyKey]*partialMessage)}
+}
+
+// add stores a fragment received in a slot, and returns the message once it is complete
+func (r *reassembler) add(roundID, slot int, buf []byte) []byte {
+	f, err := decodeFragment(buf)
+	if err != nil {
+		r.corrupted++
+		log.Error("Corrupted fragment in slot", slot, "of round", roundID, "(disruption or collision):", err)
+		return nil
+	}
+
+	key := reassemblyKey{slot: slot, messageID: f.messageID}
+	m, ok := r.pending[key]
+	if !ok {
+		m = &partialMessage{fragments: make([][]byte, f.count)}
+		r.pending[key] = m
+	}
+	if len(m.fragments) != f.count {
+		r.corrupted++
+		log.Error("Fragment in slot", slot, "of round", roundID, "announces", f.count, "fragments, expected", len(m.fragments))
+		return nil
+	}
+	m.lastRound = roundID
+	if m.fragments[f.index] == nil {
+		m.fragments[f.index] = f.data
+		m.received++
+	}
+	if m.received < f.count {
+		return nil
+	}
+
+	delete(r.pending, key)
+	data := make([]byte, 0)
+	for _, part := range m.fragments {
+		data = append(data, part...)
+	}
+	return data
+}
+
+// expire drops the messages which got no fragment for fragmentTimeoutRounds rounds
+func (r *reassembler) expire(roundID int) {
+	for key, m := range r.pending {
+		if roundID-m.lastRound > fragmentTimeoutRounds {
+			r.expired++
+			log.Lvl2("Dropping an incomplete message of slot", key.slot, ",", m.received, "of", len(m.fragments), "fragments received")
+			delete(r.pending, key)
+		}
+	}
+}
+
+// reassembleMessages is called on clients with the opened slots of each round; it passes the complete messages to the message handler
+func (p *DissentProtocol) reassembleMessages(roundID int, slots [][]byte) {
+	for slot, content := range slots {
+		if len(content) == 0 || content[0] != slotFragment {
+			continue
+		}
+		data := p.reassembler.add(roundID, slot, content[slotHeaderSize:])
+		if data == nil {
+			continue
+		}
+		if p.output != nil {
+			p.output.write(roundID, slot, "message", data)
+		}
+		if p.messageHandler != nil {
+			p.messageHandler(roundID, slot, data)
+		}
+	}
+	p.reassembler.expire(roundID)
+}
@@ -0,0 +1,144 @@
+package protocols
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestFragmentEncodeDecode(t *testing.T) {
+	valid := (&fragment{messageID: 7, index: 1, count: 3, data: []byte("hello")}).encode()
+
+	flipData := append([]byte{}, valid...)
+	flipData[fragmentHeaderSize] ^= 0x01
+	flipHeader := append([]byte{}, valid...)
+	flipHeader[4] ^= 0x01 // the index, covered by the checksum
+	flipChecksum := append([]byte{}, valid...)
+	flipChecksum[13] ^= 0x01
+	longLength := append([]byte{}, valid...)
+	binary.BigEndian.PutUint16(longLength[8:10], 100)
+	padded := append(append([]byte{}, valid...), 0, 0, 0)
+
+	tests := []struct {
+		name string
+		buf  []byte
+		ok   bool
+	}{
+		{"valid", valid, true},
+		{"padded to the slot", padded, true},
+		{"empty fragment", (&fragment{messageID: 1, index: 0, count: 1}).encode(), true},
+		{"flipped data", flipData, false},
+		{"flipped header", flipHeader, false},
+		{"flipped checksum", flipChecksum, false},
+		{"length exceeds the slot", longLength, false},
+		{"too short", valid[:fragmentHeaderSize-1], false},
+		{"empty slot", make([]byte, 64), false},
+		{"index out of range", (&fragment{messageID: 1, index: 2, count: 2}).encode(), false},
+		{"no fragments", (&fragment{messageID: 1, index: 0, count: 0}).encode(), false},
+	}
+	for _, test := range tests {
+		f, err := decodeFragment(test.buf)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if test.name == "valid" && (f.messageID != 7 || f.index != 1 || f.count != 3 || !bytes.Equal(f.data, []byte("hello"))) {
+			t.Errorf("%s: decoded %+v", test.name, f)
+		}
+	}
+}
+
+// slotFragments returns the encoded fragments of a message, as SendMessage cuts it
+func slotFragments(messageID uint32, count int, part func(k int) []byte) [][]byte {
+	fragments := make([][]byte, count)
+	for k := range fragments {
+		fragments[k] = (&fragment{messageID: messageID, index: k, count: count, data: part(k)}).encode()
+	}
+	return fragments
+}
+
+func TestReassembler(t *testing.T) {
+	part := func(k int) []byte { return []byte{byte('a' + k), byte('a' + k)} }
+	frags := slotFragments(1, 3, part)
+	other := slotFragments(2, 2, func(k int) []byte { return []byte{byte('x' + k)} })
+	corrupted := append([]byte{}, frags[1]...)
+	corrupted[fragmentHeaderSize] ^= 0xff
+	mismatch := (&fragment{messageID: 1, index: 1, count: 4, data: part(1)}).encode()
+
+	type arrival struct {
+		slot int
+		buf  []byte
+	}
+	tests := []struct {
+		name      string
+		arrivals  []arrival
+		messages  []string // the messages returned, in order
+		corrupted int
+		pending   int
+	}{
+		{"in order", []arrival{{0, frags[0]}, {0, frags[1]}, {0, frags[2]}}, []string{"aabbcc"}, 0, 0},
+		{"out of order", []arrival{{0, frags[2]}, {0, frags[0]}, {0, frags[1]}}, []string{"aabbcc"}, 0, 0},
+		{"duplicates", []arrival{{0, frags[0]}, {0, frags[0]}, {0, frags[1]}, {0, frags[1]}, {0, frags[2]}}, []string{"aabbcc"}, 0, 0},
+		{"single fragment", []arrival{{3, slotFragments(9, 1, part)[0]}}, []string{"aa"}, 0, 0},
+		{"missing fragment", []arrival{{0, frags[0]}, {0, frags[2]}}, nil, 0, 1},
+		{"corrupted fragment", []arrival{{0, frags[0]}, {0, corrupted}, {0, frags[2]}}, nil, 1, 1},
+		{"corrupted then resent", []arrival{{0, frags[0]}, {0, corrupted}, {0, frags[2]}, {0, frags[1]}}, []string{"aabbcc"}, 1, 0},
+		{"inconsistent count", []arrival{{0, frags[0]}, {0, mismatch}}, nil, 1, 1},
+		{"same ID in two slots", []arrival{{0, frags[0]}, {1, frags[1]}, {0, frags[2]}}, nil, 0, 2},
+		{"interleaved messages", []arrival{{0, frags[0]}, {1, other[1]}, {0, frags[1]}, {1, other[0]}, {0, frags[2]}}, []string{"xy", "aabbcc"}, 0, 0},
+	}
+	for _, test := range tests {
+		r := newReassembler()
+		messages := make([]string, 0)
+		for round, a := range test.arrivals {
+			if data := r.add(round, a.slot, a.buf); data != nil {
+				messages = append(messages, string(data))
+			}
+		}
+		if len(messages) != len(test.messages) {
+			t.Errorf("%s: got messages %q, expected %q", test.name, messages, test.messages)
+		} else {
+			for k := range messages {
+				if messages[k] != test.messages[k] {
+					t.Errorf("%s: got messages %q, expected %q", test.name, messages, test.messages)
+					break
+				}
+			}
+		}
+		if r.corrupted != test.corrupted {
+			t.Errorf("%s: %d corrupted fragments, expected %d", test.name, r.corrupted, test.corrupted)
+		}
+		if len(r.pending) != test.pending {
+			t.Errorf("%s: %d incomplete messages, expected %d", test.name, len(r.pending), test.pending)
+		}
+	}
+}
+
+func TestReassemblerExpire(t *testing.T) {
+	frags := slotFragments(1, 2, func(k int) []byte { return []byte{byte(k)} })
+	tests := []struct {
+		name    string
+		round   int
+		pending int
+	}{
+		{"before the timeout", fragmentTimeoutRounds, 1},
+		{"after the timeout", fragmentTimeoutRounds + 1, 0},
+	}
+	for _, test := range tests {
+		r := newReassembler()
+		r.add(0, 0, frags[0])
+		r.expire(test.round)
+		if len(r.pending) != test.pending || r.expired != 1-test.pending {
+			t.Errorf("%s: %d incomplete and %d expired messages", test.name, len(r.pending), r.expired)
+		}
+		if data := r.add(test.round, 0, frags[1]); (data != nil) != (test.pending == 1) {
+			t.Errorf("%s: the last fragment returned %v", test.name, data)
+		}
+	}
+}
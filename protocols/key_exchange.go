@@ -0,0 +1,106 @@
+package protocols
+
+// This file contains the logic of the key-exchange phase.
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/onet.v2"
+)
+
+// keyExchangeState holds the PUBLIC_KEY messages collected by Client0
+// before they are broadcasted to everyone.
+type keyExchangeState struct {
+	clientKeys  map[int]PUBLIC_KEY
+	trusteeKeys map[int]PUBLIC_KEY
+}
+
+func newKeyExchangeState() *keyExchangeState {
+	return &keyExchangeState{
+		clientKeys:  make(map[int]PUBLIC_KEY),
+		trusteeKeys: make(map[int]PUBLIC_KEY),
+	}
+}
+
+// isClient returns true iff this node participates to the DC-net as a client (Client0 included)
+func (p *DissentProtocol) isClient() bool {
+	return p.role == Client || p.role == Client0
+}
+
+// collectPublicKey stores the signed PUBLIC_KEY of the given node, and returns true when
+// the keys of all clients and trustees have been collected.
+func (k *keyExchangeState) collectPublicKey(role DissentRole, id int, key PUBLIC_KEY, nClients, nTrustees int) bool {
+	switch role {
+	case Client:
+		k.clientKeys[id] = key
+	case Trustee:
+		k.trusteeKeys[id] = key
+	}
+	return len(k.clientKeys) == nClients && len(k.trusteeKeys) == nTrustees
+}
+
+// orderedKeys returns the collected PUBLIC_KEY messages, ordered by ID
+func (k *keyExchangeState) orderedKeys() ([]PUBLIC_KEY, []PUBLIC_KEY) {
+	clientKeys := make([]PUBLIC_KEY, len(k.clientKeys))
+	for i := range clientKeys {
+		clientKeys[i] = k.clientKeys[i]
+	}
+	trusteeKeys := make([]PUBLIC_KEY, len(k.trusteeKeys))
+	for i := range trusteeKeys {
+		trusteeKeys[i] = k.trusteeKeys[i]
+	}
+	return clientKeys, trusteeKeys
+}
+
+// checkPublicKeys verifies that the keys broadcasted by Client0 are consistent with the parameters,
+// that each one was signed by the node at its position in this session, and that our own key was not
+// replaced. It returns the keys of the clients and of the trustees.
+func (p *DissentProtocol) checkPublicKeys(clientKeys, trusteeKeys []PUBLIC_KEY) ([]kyber.Point, []kyber.Point, error) {
+	if len(clientKeys) != p.nClients || len(trusteeKeys) != p.nTrustees {
+		return nil, nil, errors.New("expected " + strconv.Itoa(p.nClients) + " clients keys and " + strconv.Itoa(p.nTrustees) +
+			" trustees keys, got " + strconv.Itoa(len(clientKeys)) + " and " + strconv.Itoa(len(trusteeKeys)))
+	}
+	verified := func(messages []PUBLIC_KEY, nodes map[int]*onet.TreeNode, role string) ([]kyber.Point, error) {
+		keys := make([]kyber.Point, len(messages))
+		for i := range messages {
+			node, ok := nodes[i]
+			if !ok || messages[i].Key == nil {
+				return nil, errors.New("the public key of " + role + " " + strconv.Itoa(i) + " is missing")
+			}
+			if err := verifyForwarded(node.ServerIdentity, &messages[i], p.ms.auth.sessionID); err != nil {
+				return nil, errors.New("the public key of " + role + " " + strconv.Itoa(i) + " was not signed by it: " + err.Error())
+			}
+			keys[i] = messages[i].Key
+		}
+		return keys, nil
+	}
+	clients, err := verified(clientKeys, p.ms.clients, "client")
+	if err != nil {
+		return nil, nil, err
+	}
+	trustees, err := verified(trusteeKeys, p.ms.trustees, "trustee")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	myKeys := trustees
+	if p.isClient() {
+		myKeys = clients
+	}
+	if p.myID < 0 || p.myID >= len(myKeys) || !myKeys[p.myID].Equal(p.keyPub) {
+		return nil, nil, errors.New("our public key is not at our position " + strconv.Itoa(p.myID))
+	}
+	return clients, trustees, nil
+}
+
+// deriveSharedSecrets computes one Diffie-Hellman shared secret per peer
+func (p *DissentProtocol) deriveSharedSecrets(peersKeys []kyber.Point) []kyber.Point {
+	secrets := make([]kyber.Point, len(peersKeys))
+	for i, peerKey := range peersKeys {
+		secrets[i] = config.CryptoSuite.Point().Mul(p.keyPriv, peerKey)
+	}
+	return secrets
+}
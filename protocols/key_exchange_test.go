@@ -0,0 +1,164 @@
+package protocols
+
+import (
+	"testing"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/onet.v2"
+	"gopkg.in/dedis/onet.v2/network"
+)
+
+func TestCollectPublicKey(t *testing.T) {
+	k := newKeyExchangeState()
+	arrivals := []struct {
+		role     DissentRole
+		id       int
+		complete bool
+	}{
+		{Trustee, 0, false},
+		{Client, 1, false},
+		{Client, 0, false},
+		{Client, 0, false}, //a duplicate does not count twice
+		{Trustee, 1, true},
+	}
+	for n, a := range arrivals {
+		if complete := k.collectPublicKey(a.role, a.id, PUBLIC_KEY{MessageAuth: MessageAuth{Seq: a.id}}, 2, 2); complete != a.complete {
+			t.Errorf("arrival %d: complete %v, expected %v", n, complete, a.complete)
+		}
+	}
+	clientKeys, trusteeKeys := k.orderedKeys()
+	if len(clientKeys) != 2 || len(trusteeKeys) != 2 {
+		t.Fatalf("%d clients keys and %d trustees keys", len(clientKeys), len(trusteeKeys))
+	}
+	for i := range clientKeys {
+		if clientKeys[i].Seq != i || trusteeKeys[i].Seq != i {
+			t.Errorf("key %d is out of order", i)
+		}
+	}
+}
+
+func TestSharedSecrets(t *testing.T) {
+	suite := config.CryptoSuite
+	pick := func() (kyber.Scalar, kyber.Point) {
+		priv := suite.Scalar().Pick(suite.RandomStream())
+		return priv, suite.Point().Mul(priv, nil)
+	}
+	clients := make([]*DissentProtocol, 3)
+	trustees := make([]*DissentProtocol, 2)
+	clientKeys := make([]kyber.Point, len(clients))
+	trusteeKeys := make([]kyber.Point, len(trustees))
+	for i := range clients {
+		clients[i] = &DissentProtocol{role: Client}
+		clients[i].keyPriv, clientKeys[i] = pick()
+	}
+	for j := range trustees {
+		trustees[j] = &DissentProtocol{role: Trustee}
+		trustees[j].keyPriv, trusteeKeys[j] = pick()
+	}
+
+	for i, c := range clients {
+		c.sharedSecrets = c.deriveSharedSecrets(trusteeKeys)
+		if len(c.sharedSecrets) != len(trustees) {
+			t.Fatalf("client %d has %d secrets", i, len(c.sharedSecrets))
+		}
+	}
+	for j, tr := range trustees {
+		tr.sharedSecrets = tr.deriveSharedSecrets(clientKeys)
+		for i, c := range clients {
+			if !tr.sharedSecrets[i].Equal(c.sharedSecrets[j]) {
+				t.Errorf("client %d and trustee %d do not agree on their secret", i, j)
+			}
+			if j > 0 && tr.sharedSecrets[i].Equal(trustees[0].sharedSecrets[i]) {
+				t.Errorf("client %d shares the same secret with two trustees", i)
+			}
+		}
+	}
+}
+
+func TestCheckPublicKeys(t *testing.T) {
+	suite := config.CryptoSuite
+	sessionID := []byte("session")
+	pick := func() (kyber.Scalar, kyber.Point) {
+		priv := suite.Scalar().Pick(suite.RandomStream())
+		return priv, suite.Point().Mul(priv, nil)
+	}
+	identityPriv := make([]kyber.Scalar, 3) // two clients, one trustee
+	nodes := make([]*onet.TreeNode, 3)
+	for k := range nodes {
+		var pub kyber.Point
+		identityPriv[k], pub = pick()
+		nodes[k] = &onet.TreeNode{ServerIdentity: &network.ServerIdentity{Public: pub}}
+	}
+	_, outsider := pick()
+	outsiderPriv, _ := pick()
+	myPriv, myKey := pick()
+
+	sign := func(key kyber.Point, priv kyber.Scalar, session []byte) PUBLIC_KEY {
+		signed, err := signMessage(&PUBLIC_KEY{Key: key}, priv, session, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *signed.(*PUBLIC_KEY)
+	}
+	_, otherKey := pick()
+	_, trusteeKey := pick()
+	valid := func() ([]PUBLIC_KEY, []PUBLIC_KEY) {
+		return []PUBLIC_KEY{sign(otherKey, identityPriv[0], sessionID), sign(myKey, identityPriv[1], sessionID)},
+			[]PUBLIC_KEY{sign(trusteeKey, identityPriv[2], sessionID)}
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(clients, trustees []PUBLIC_KEY) ([]PUBLIC_KEY, []PUBLIC_KEY)
+		ok     bool
+	}{
+		{"valid", func(c, tr []PUBLIC_KEY) ([]PUBLIC_KEY, []PUBLIC_KEY) { return c, tr }, true},
+		{"missing client", func(c, tr []PUBLIC_KEY) ([]PUBLIC_KEY, []PUBLIC_KEY) { return c[:1], tr }, false},
+		{"extra trustee", func(c, tr []PUBLIC_KEY) ([]PUBLIC_KEY, []PUBLIC_KEY) { return c, append(tr, tr[0]) }, false},
+		{"missing key", func(c, tr []PUBLIC_KEY) ([]PUBLIC_KEY, []PUBLIC_KEY) {
+			c[0].Key = nil
+			return c, tr
+		}, false},
+		{"replaced key", func(c, tr []PUBLIC_KEY) ([]PUBLIC_KEY, []PUBLIC_KEY) {
+			tr[0].Key = outsider
+			return c, tr
+		}, false},
+		{"key signed by another node", func(c, tr []PUBLIC_KEY) ([]PUBLIC_KEY, []PUBLIC_KEY) {
+			tr[0] = sign(outsider, outsiderPriv, sessionID)
+			return c, tr
+		}, false},
+		{"key of another session", func(c, tr []PUBLIC_KEY) ([]PUBLIC_KEY, []PUBLIC_KEY) {
+			c[0] = sign(otherKey, identityPriv[0], []byte("another session"))
+			return c, tr
+		}, false},
+		{"keys swapped", func(c, tr []PUBLIC_KEY) ([]PUBLIC_KEY, []PUBLIC_KEY) {
+			c[0], c[1] = c[1], c[0]
+			return c, tr
+		}, false},
+		{"our key replaced", func(c, tr []PUBLIC_KEY) ([]PUBLIC_KEY, []PUBLIC_KEY) {
+			c[1] = sign(outsider, identityPriv[1], sessionID)
+			return c, tr
+		}, false},
+	}
+	for _, test := range tests {
+		p := &DissentProtocol{
+			role:      Client,
+			nClients:  2,
+			nTrustees: 1,
+			myID:      1,
+			keyPriv:   myPriv,
+			keyPub:    myKey,
+			ms: MessageSender{
+				clients:  map[int]*onet.TreeNode{0: nodes[0], 1: nodes[1]},
+				trustees: map[int]*onet.TreeNode{0: nodes[2]},
+				auth:     newAuthState(sessionID),
+			},
+		}
+		clients, trustees := test.tamper(valid())
+		_, _, err := p.checkPublicKeys(clients, trustees)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+}
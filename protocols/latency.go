@@ -0,0 +1,194 @@
+package protocols
+
+// This file contains the latency tests (DoLatencyTests).
+//
+// Every latencyProbeInterval, each client enqueues a probe in its slot with the kind slotProbe. A
+// probe carries a sequence number, the time it was enqueued, and the last round-trip time measured
+// by the client. When a client finds its own probe in its slot of a round output, it measures the
+// round-trip time with its own clock, so the clocks need not be synchronized. Since the probes
+// also carry the last measured round-trip time, Client0 collects the latency of every slot without
+// learning who owns it. Every latencyReportInterval, Client0 sends the percentiles of each slot and
+// of all slots together to the LatencyChannel. Each report covers all the samples so far, so when
+// nobody reads the channel (outside of the simulations), a new report replaces the unread one.
+
+import (
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/onet.v2/log"
+)
+
+// probeSize is the size of a probe: sequence number, send time, last round-trip time in microseconds
+const probeSize = 4 + 8 + 4
+
+// latencyProbeInterval is the time between two probes of a client
+const latencyProbeInterval = 500 * time.Millisecond
+
+// latencyProbeTimeout is the time after which a probe which did not come back is considered lost
+const latencyProbeTimeout = 10 * time.Second
+
+// latencyReportInterval is the time between two reports of Client0
+const latencyReportInterval = 10 * time.Second
+
+// latencyState holds the probes in flight and the round-trip times measured
+type latencyState struct {
+	sync.Mutex
+	nextSeq  uint32
+	inFlight map[uint32]time.Time // our probes not back yet, and when they were enqueued
+	lastRTT  time.Duration
+	perSlot  map[int][]time.Duration // the round-trip times announced in each slot, only used by Client0
+	reported int                     // the number of samples in the last report, only used by Client0
+}
+
+// LatencyReport holds the percentiles of the round-trip times of a slot, or of all slots
+type LatencyReport struct {
+	Name    string // "slot N", or "all"
+	Samples int
+	Min     time.Duration
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+}
+
+// sendProbes is run by the clients in their own goroutine; it enqueues a probe every latencyProbeInterval
+func (p *DissentProtocol) sendProbes() {
+	l := &p.latency
+	l.Lock()
+	l.inFlight = make(map[uint32]time.Time)
+	l.perSlot = make(map[int][]time.Duration)
+	l.Unlock()
+
+	for !p.HasStopped {
+		l.Lock()
+		now := time.Now()
+		for seq, sent := range l.inFlight {
+			if now.Sub(sent) > latencyProbeTimeout {
+				log.Lvl2("Probe", seq, "was lost")
+				delete(l.inFlight, seq)
+			}
+		}
+		//a probe still queued would measure the previous one's wait
+		if len(l.inFlight) == 0 {
+			buf := make([]byte, probeSize)
+			binary.BigEndian.PutUint32(buf[0:4], l.nextSeq)
+			binary.BigEndian.PutUint64(buf[4:12], uint64(now.UnixNano()))
+			binary.BigEndian.PutUint32(buf[12:16], uint32(l.lastRTT/time.Microsecond))
+			l.inFlight[l.nextSeq] = now
+			l.nextSeq++
+			p.probeQueue.push(buf)
+		}
+		l.Unlock()
+		time.Sleep(latencyProbeInterval)
+	}
+}
+
+// recordProbes is called on clients with the slots of each round; it measures the round-trip time of
+// our probes, and on Client0, collects the round-trip times announced in every slot
+func (p *DissentProtocol) recordProbes(slots [][]byte) {
+	l := &p.latency
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	if l.inFlight == nil {
+		return
+	}
+
+	for slot, content := range slots {
+		if len(content) < slotHeaderSize+probeSize || content[0] != slotProbe {
+			continue
+		}
+		buf := content[slotHeaderSize:]
+
+		if slot == p.slot {
+			seq := binary.BigEndian.Uint32(buf[0:4])
+			if _, ok := l.inFlight[seq]; ok {
+				delete(l.inFlight, seq)
+				sent := time.Unix(0, int64(binary.BigEndian.Uint64(buf[4:12])))
+				l.lastRTT = now.Sub(sent)
+				log.Lvl3("Probe", seq, "came back after", l.lastRTT)
+			}
+		}
+
+		if p.role == Client0 {
+			if rtt := time.Duration(binary.BigEndian.Uint32(buf[12:16])) * time.Microsecond; rtt > 0 {
+				l.perSlot[slot] = append(l.perSlot[slot], rtt)
+			}
+		}
+	}
+}
+
+// reportLatencies is run by Client0 in its own goroutine; it sends the percentiles of the round-trip times to the LatencyChannel
+func (p *DissentProtocol) reportLatencies() {
+	l := &p.latency
+	for !p.HasStopped {
+		time.Sleep(latencyReportInterval)
+
+		l.Lock()
+		reports := make([]LatencyReport, 0)
+		all := make([]time.Duration, 0)
+		slots := make([]int, 0, len(l.perSlot))
+		for slot := range l.perSlot {
+			slots = append(slots, slot)
+		}
+		sort.Ints(slots)
+		for _, slot := range slots {
+			reports = append(reports, newLatencyReport("slot "+strconv.Itoa(slot), l.perSlot[slot]))
+			all = append(all, l.perSlot[slot]...)
+		}
+		fresh := len(all) > l.reported
+		l.reported = len(all)
+		l.Unlock()
+
+		if !fresh {
+			continue
+		}
+		reports = append(reports, newLatencyReport("all", all))
+		for _, r := range reports {
+			log.Lvl1("Client0 :", r.String())
+		}
+		//we are the only sender, so once the unread report is dropped, the send cannot block
+		select {
+		case <-p.LatencyChannel:
+		default:
+		}
+		p.LatencyChannel <- reports
+	}
+}
+
+// newLatencyReport computes the percentiles of some round-trip times
+func newLatencyReport(name string, samples []time.Duration) LatencyReport {
+	sorted := append([]time.Duration{}, samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return LatencyReport{
+		Name:    name,
+		Samples: len(sorted),
+		Min:     sorted[0],
+		P50:     percentile(sorted, 50),
+		P90:     percentile(sorted, 90),
+		P99:     percentile(sorted, 99),
+		Max:     sorted[len(sorted)-1],
+	}
+}
+
+// String formats the percentiles of a report in milliseconds
+func (r LatencyReport) String() string {
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 1, 64) + "ms"
+	}
+	return "latency " + r.Name + " samples " + strconv.Itoa(r.Samples) +
+		" min " + ms(r.Min) + " p50 " + ms(r.P50) + " p90 " + ms(r.P90) +
+		" p99 " + ms(r.P99) + " max " + ms(r.Max)
+}
+
+// percentile returns the q-th percentile of some sorted, non-empty samples (nearest rank)
+func percentile(sorted []time.Duration, q int) time.Duration {
+	rank := (q*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,114 @@
+package protocols
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// durations returns the given numbers of milliseconds
+func durations(ms ...int) []time.Duration {
+	res := make([]time.Duration, len(ms))
+	for i, v := range ms {
+		res[i] = time.Duration(v) * time.Millisecond
+	}
+	return res
+}
+
+func TestPercentile(t *testing.T) {
+	hundred := make([]int, 100)
+	for i := range hundred {
+		hundred[i] = i + 1
+	}
+	tests := []struct {
+		sorted []time.Duration
+		q      int
+		want   int
+	}{
+		{durations(5), 50, 5},
+		{durations(5), 99, 5},
+		{durations(1, 2), 50, 1},
+		{durations(1, 2), 90, 2},
+		{durations(1, 2, 3, 4), 0, 1},
+		{durations(1, 2, 3, 4), 50, 2},
+		{durations(1, 2, 3, 4), 100, 4},
+		{durations(hundred...), 50, 50},
+		{durations(hundred...), 90, 90},
+		{durations(hundred...), 99, 99},
+	}
+	for _, test := range tests {
+		if got := percentile(test.sorted, test.q); got != time.Duration(test.want)*time.Millisecond {
+			t.Errorf("%d-th percentile of %d samples: got %v, want %dms", test.q, len(test.sorted), got, test.want)
+		}
+	}
+}
+
+func TestNewLatencyReport(t *testing.T) {
+	samples := durations(30, 10, 50, 20, 40)
+	r := newLatencyReport("slot 1", samples)
+	want := LatencyReport{
+		Name:    "slot 1",
+		Samples: 5,
+		Min:     10 * time.Millisecond,
+		P50:     30 * time.Millisecond,
+		P90:     50 * time.Millisecond,
+		P99:     50 * time.Millisecond,
+		Max:     50 * time.Millisecond,
+	}
+	if r != want {
+		t.Errorf("got %v, want %v", r, want)
+	}
+	if samples[0] != 30*time.Millisecond {
+		t.Error("the samples were sorted in place")
+	}
+	if s := r.String(); s != "latency slot 1 samples 5 min 10.0ms p50 30.0ms p90 50.0ms p99 50.0ms max 50.0ms" {
+		t.Errorf("got %q", s)
+	}
+}
+
+func TestRecordProbes(t *testing.T) {
+	probe := func(seq uint32, sent time.Time, lastRTT time.Duration) []byte {
+		buf := make([]byte, slotHeaderSize+probeSize)
+		buf[0] = slotProbe
+		binary.BigEndian.PutUint32(buf[1:5], seq)
+		binary.BigEndian.PutUint64(buf[5:13], uint64(sent.UnixNano()))
+		binary.BigEndian.PutUint32(buf[13:17], uint32(lastRTT/time.Microsecond))
+		return buf
+	}
+	sent := time.Now().Add(-20 * time.Millisecond)
+
+	tests := []struct {
+		role    DissentRole
+		collect bool
+	}{
+		{Client, false},
+		{Client0, true},
+	}
+	for _, test := range tests {
+		p := &DissentProtocol{role: test.role, slot: 1}
+		p.latency.inFlight = map[uint32]time.Time{7: sent}
+		p.latency.perSlot = make(map[int][]time.Duration)
+
+		p.recordProbes([][]byte{
+			probe(3, sent, 15*time.Millisecond),
+			probe(7, sent, 0),
+			nil,
+			probe(9, sent, 25*time.Millisecond),
+		})
+
+		if len(p.latency.inFlight) != 0 {
+			t.Errorf("role %v: our probe is still in flight", test.role)
+		}
+		if rtt := p.latency.lastRTT; rtt < 20*time.Millisecond || rtt > time.Second {
+			t.Errorf("role %v: got round-trip time %v", test.role, rtt)
+		}
+		//a probe announcing no round-trip time yet is not a sample
+		collected := len(p.latency.perSlot[0]) == 1 && len(p.latency.perSlot[3]) == 1 && len(p.latency.perSlot) == 2
+		if test.collect != collected || test.collect && p.latency.perSlot[3][0] != 25*time.Millisecond {
+			t.Errorf("role %v: got samples %v", test.role, p.latency.perSlot)
+		}
+		if !test.collect && len(p.latency.perSlot) != 0 {
+			t.Errorf("role %v: a client collected samples", test.role)
+		}
+	}
+}
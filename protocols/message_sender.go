@@ -4,9 +4,9 @@ import (
 	"errors"
 	"strconv"
 
-	"github.com/dedis/prifi/prifi-lib/net"
 	"gopkg.in/dedis/onet.v2"
 	"gopkg.in/dedis/onet.v2/log"
+	"gopkg.in/dedis/onet.v2/network"
 )
 
 //MessageSender is the struct we need to give PriFi-Lib so it can send messages.
@@ -16,6 +16,7 @@ type MessageSender struct {
 	client0    *onet.TreeNode
 	clients    map[int]*onet.TreeNode
 	trustees   map[int]*onet.TreeNode
+	auth       *authState
 }
 
 // buildMessageSender creates a MessageSender struct
@@ -24,15 +25,13 @@ func (p *DissentProtocol) buildMessageSender(identities map[string]DissentIdenti
 	nodes := p.List() // Has type []*onet.TreeNode
 	trustees := make(map[int]*onet.TreeNode)
 	clients := make(map[int]*onet.TreeNode)
-	trusteeID := 0
-	clientID := 0
 	var relay *onet.TreeNode
 
 	for i := 0; i < len(nodes); i++ {
 		identifier := nodes[i].ServerIdentity.Public.String()
 		id, ok := identities[identifier]
 		port, _ := strconv.Atoi(nodes[i].ServerIdentity.Address.Port())
-		portForFastChannel := port + 3
+		portForFastChannel := fastChannelPort(nodes[i])
 
 		log.Lvl3("Found identity", identifier, " -> ", port, portForFastChannel)
 
@@ -40,13 +39,12 @@ func (p *DissentProtocol) buildMessageSender(identities map[string]DissentIdenti
 			log.Lvl3("Skipping unknow node with address", identifier)
 			continue
 		}
+		//the IDs are assigned by Client0, and sent to everyone in ALL_ALL_PARAMETERS
 		switch id.Role {
 		case Client:
-			clients[clientID] = nodes[i] //TODO : wrong
-			clientID++
+			clients[id.ID] = nodes[i]
 		case Trustee:
-			trustees[trusteeID] = nodes[i]
-			trusteeID++
+			trustees[id.ID] = nodes[i]
 		case Client0:
 			if relay == nil {
 				relay = nodes[i]
@@ -56,18 +54,103 @@ func (p *DissentProtocol) buildMessageSender(identities map[string]DissentIdenti
 		}
 	}
 
-	return MessageSender{p.TreeNodeInstance, relay, clients, trustees}
+	// nodes other than Client0 might not know its role, but the tree is always rooted at Client0
+	if relay == nil {
+		relay = p.Root()
+	}
+
+	return MessageSender{p.TreeNodeInstance, relay, clients, trustees, newAuthState(p.sessionID())}
 }
 
-//SendToClient sends a message to client i, or fails if it is unknown
-func (ms MessageSender) FastSendToClient(i int, msg *net.REL_CLI_DOWNSTREAM_DATA) error {
+// numbering returns the identities of the clients and trustees, ordered by ID
+func (ms MessageSender) numbering() ([]*network.ServerIdentity, []*network.ServerIdentity, error) {
+	ordered := func(nodes map[int]*onet.TreeNode) ([]*network.ServerIdentity, error) {
+		res := make([]*network.ServerIdentity, len(nodes))
+		for i := range res {
+			node, ok := nodes[i]
+			if !ok {
+				return nil, errors.New("the IDs are not contiguous, " + strconv.Itoa(i) + " is missing")
+			}
+			res[i] = node.ServerIdentity
+		}
+		return res, nil
+	}
+	clients, err := ordered(ms.clients)
+	if err != nil {
+		return nil, nil, err
+	}
+	trustees, err := ordered(ms.trustees)
+	if err != nil {
+		return nil, nil, err
+	}
+	return clients, trustees, nil
+}
 
-	if client, ok := ms.clients[i]; ok {
-		log.Lvl5("Sending a message to client ", i, " (", client.Name(), ") - ", msg)
-		return ms.tree.SendTo(client, msg)
+// applyNumbering replaces the clients and trustees by the given ones, numbered by their index, and
+// returns our own ID
+func (ms *MessageSender) applyNumbering(clients, trustees []*network.ServerIdentity, me *network.ServerIdentity, isClient bool) (int, error) {
+	nodes := make(map[string]*onet.TreeNode)
+	for _, node := range ms.tree.List() {
+		nodes[node.ServerIdentity.Public.String()] = node
 	}
 
-	e := "Client " + strconv.Itoa(i) + " is unknown !"
+	seen := make(map[string]bool)
+	byID := func(identities []*network.ServerIdentity) (map[int]*onet.TreeNode, error) {
+		res := make(map[int]*onet.TreeNode)
+		for i, si := range identities {
+			if si == nil {
+				return nil, errors.New("an identity is missing")
+			}
+			key := si.Public.String()
+			node, ok := nodes[key]
+			if !ok {
+				return nil, errors.New("node " + si.String() + " is not in the tree")
+			}
+			if seen[key] {
+				return nil, errors.New("node " + si.String() + " is numbered twice")
+			}
+			seen[key] = true
+			res[i] = node
+		}
+		return res, nil
+	}
+	newClients, err := byID(clients)
+	if err != nil {
+		return -1, err
+	}
+	newTrustees, err := byID(trustees)
+	if err != nil {
+		return -1, err
+	}
+
+	mine := trustees
+	if isClient {
+		mine = clients
+	}
+	myID := -1
+	for i, si := range mine {
+		if si.Equal(me) {
+			myID = i
+		}
+	}
+	if myID < 0 {
+		return -1, errors.New("we are not in the numbering")
+	}
+
+	ms.clients = newClients
+	ms.trustees = newTrustees
+	return myID, nil
+}
+
+//SendToClient0 sends a message to Client0
+func (ms MessageSender) SendToClient0(msg interface{}) error {
+
+	if ms.client0 != nil {
+		log.Lvl5("Sending a message to client0 (", ms.client0.Name(), ") - ", msg)
+		return ms.send(ms.client0, msg)
+	}
+
+	e := "Client0 is unknown !"
 	log.Error(e)
 	return errors.New(e)
 }
@@ -77,7 +160,7 @@ func (ms MessageSender) SendToClient(i int, msg interface{}) error {
 
 	if client, ok := ms.clients[i]; ok {
 		log.Lvl5("Sending a message to client ", i, " (", client.Name(), ") - ", msg)
-		return ms.tree.SendTo(client, msg)
+		return ms.send(client, msg)
 	}
 
 	e := "Client " + strconv.Itoa(i) + " is unknown !"
@@ -90,10 +173,55 @@ func (ms MessageSender) SendToTrustee(i int, msg interface{}) error {
 
 	if trustee, ok := ms.trustees[i]; ok {
 		log.Lvl5("Sending a message to trustee ", i, " (", trustee.Name(), ") - ", msg)
-		return ms.tree.SendTo(trustee, msg)
+		return ms.send(trustee, msg)
 	}
 
 	e := "Trustee " + strconv.Itoa(i) + " is unknown !"
 	log.Error(e)
 	return errors.New(e)
 }
+
+// send signs a message for the given node, and sends it
+func (ms MessageSender) send(node *onet.TreeNode, msg interface{}) error {
+	ms.auth.Lock()
+	defer ms.auth.Unlock()
+
+	link := node.ServerIdentity.Public.String()
+	signed, err := signMessage(msg, ms.tree.Private(), ms.auth.sessionID, ms.auth.sendSeq[link])
+	if err != nil {
+		log.Error("Could not sign a message for", node.Name(), ":", err)
+		return err
+	}
+	if err := ms.tree.SendTo(node, signed); err != nil {
+		return err
+	}
+	ms.auth.sendSeq[link]++
+	return nil
+}
+
+//identify returns the role and the ID of the node which sent a message, as known by this MessageSender
+func (ms MessageSender) identify(node *onet.TreeNode) (DissentRole, int, bool) {
+	for i, client := range ms.clients {
+		if client.ServerIdentity.Equal(node.ServerIdentity) {
+			return Client, i, true
+		}
+	}
+	for i, trustee := range ms.trustees {
+		if trustee.ServerIdentity.Equal(node.ServerIdentity) {
+			return Trustee, i, true
+		}
+	}
+	return Client, -1, false
+}
+
+// serverIdentity returns the identity of the given node, or nil if it is unknown
+func (ms MessageSender) serverIdentity(role DissentRole, id int) *network.ServerIdentity {
+	nodes := ms.clients
+	if role == Trustee {
+		nodes = ms.trustees
+	}
+	if node, ok := nodes[id]; ok {
+		return node.ServerIdentity
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package protocols
+
+import (
+	"testing"
+
+	"gopkg.in/dedis/onet.v2"
+	"gopkg.in/dedis/onet.v2/network"
+)
+
+// treeNodes returns a TreeNode for each of the given IDs, with a distinct identity
+func treeNodes(IDs ...int) map[int]*onet.TreeNode {
+	res := make(map[int]*onet.TreeNode)
+	for _, id := range IDs {
+		res[id] = &onet.TreeNode{ServerIdentity: &network.ServerIdentity{Description: "node"}}
+	}
+	return res
+}
+
+func TestNumbering(t *testing.T) {
+	tests := []struct {
+		name     string
+		clients  map[int]*onet.TreeNode
+		trustees map[int]*onet.TreeNode
+		ok       bool
+	}{
+		{"contiguous", treeNodes(0, 1, 2), treeNodes(0, 1), true},
+		{"single nodes", treeNodes(0), treeNodes(0), true},
+		{"no trustee", treeNodes(0, 1), treeNodes(), true},
+		{"gap in the clients", treeNodes(0, 2), treeNodes(0), false},
+		{"clients from 1", treeNodes(1, 2), treeNodes(0), false},
+		{"gap in the trustees", treeNodes(0, 1), treeNodes(0, 1, 3), false},
+	}
+	for _, test := range tests {
+		ms := MessageSender{clients: test.clients, trustees: test.trustees}
+		clients, trustees, err := ms.numbering()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v, want success %v", test.name, err, test.ok)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if len(clients) != len(test.clients) || len(trustees) != len(test.trustees) {
+			t.Errorf("%s: got %d clients and %d trustees, want %d and %d", test.name,
+				len(clients), len(trustees), len(test.clients), len(test.trustees))
+			continue
+		}
+		for i, si := range clients {
+			if si != test.clients[i].ServerIdentity {
+				t.Errorf("%s: client %d is not in its place", test.name, i)
+			}
+		}
+		for i, si := range trustees {
+			if si != test.trustees[i].ServerIdentity {
+				t.Errorf("%s: trustee %d is not in its place", test.name, i)
+			}
+		}
+	}
+}
@@ -2,16 +2,35 @@ package protocols
 
 import (
 	"gopkg.in/dedis/onet.v2"
+	"gopkg.in/dedis/onet.v2/network"
 	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/kyber.v2/proof/dleq"
 )
 
+// MessageAuth is embedded in every message. It is signed with the long-term key of the sender, and
+// the messages are numbered on each link, so that replayed or reordered messages are rejected.
+type MessageAuth struct {
+	SessionID []byte
+	Seq       int
+	Signature []byte
+}
+
+// auth gives access to the MessageAuth of any message embedding it
+func (a *MessageAuth) auth() *MessageAuth {
+	return a
+}
+
 type Struct_NEW_ROUND struct {
 	*onet.TreeNode
 	NEW_ROUND
 }
 
+// NEW_ROUND is sent by Client0 to all clients to announce a round
 type NEW_ROUND struct {
-	RoundID int
+	MessageAuth
+	RoundID     int
+	Reservation bool  // the round only carries one bit per slot, see slots.go
+	OpenSlots   []int // the slots carried by the round, if it is not a reservation round
 }
 
 type Struct_ALL_ALL_PARAMETERS struct {
@@ -19,9 +38,13 @@ type Struct_ALL_ALL_PARAMETERS struct {
 	ALL_ALL_PARAMETERS
 }
 
+// ALL_ALL_PARAMETERS is sent by Client0 to everyone; the ID of a client (resp. trustee) is its index in Clients (resp. Trustees)
 type ALL_ALL_PARAMETERS struct {
+	MessageAuth
 	NClients int
 	NTrustees int
+	Clients   []*network.ServerIdentity
+	Trustees  []*network.ServerIdentity
 }
 
 type Struct_PUBLIC_KEY struct {
@@ -29,6 +52,271 @@ type Struct_PUBLIC_KEY struct {
 	PUBLIC_KEY
 }
 
+// PUBLIC_KEY is sent by every node to Client0
 type PUBLIC_KEY struct {
+	MessageAuth
 	Key kyber.Point
-}
\ No newline at end of file
+}
+
+type Struct_ALL_PUBLIC_KEYS struct {
+	*onet.TreeNode
+	ALL_PUBLIC_KEYS
+}
+
+// ALL_PUBLIC_KEYS is broadcasted by Client0 once it collected the PUBLIC_KEY of every node; the
+// PUBLIC_KEY messages are forwarded as signed by their sender, so that Client0 cannot replace a key
+type ALL_PUBLIC_KEYS struct {
+	MessageAuth
+	ClientKeys  []PUBLIC_KEY
+	TrusteeKeys []PUBLIC_KEY
+}
+
+type Struct_CLIENT_CIPHER struct {
+	*onet.TreeNode
+	CLIENT_CIPHER
+}
+
+// CLIENT_CIPHER is sent by each client to Client0; it contains its slot payload XORed with its pads
+type CLIENT_CIPHER struct {
+	MessageAuth
+	RoundID     int
+	Reservation bool  // the layout of the round, as announced in NEW_ROUND; signed so that the
+	OpenSlots   []int // ciphertext can be checked by everyone during a blame
+	Cipher      []byte
+	Kappas      []kyber.Scalar // one per slot, only with equivocation protection
+}
+
+type Struct_TRUSTEE_CIPHER struct {
+	*onet.TreeNode
+	TRUSTEE_CIPHER
+}
+
+// TRUSTEE_CIPHER is sent by each trustee to Client0, ahead of time; it contains the XOR of its pads with every client
+type TRUSTEE_CIPHER struct {
+	MessageAuth
+	RoundID int
+	Cipher  []byte
+	Sigmas  []kyber.Scalar // one per slot, only with equivocation protection
+	NExcluded int // the number of excluded clients the pads account for
+}
+
+type Struct_CLIENTS_EXCLUDED struct {
+	*onet.TreeNode
+	CLIENTS_EXCLUDED
+}
+
+// CLIENTS_EXCLUDED is sent by Client0 to the trustees when late clients are excluded; the trustees
+// recompute their ciphertexts from round FromRound on, without the pads of these clients
+type CLIENTS_EXCLUDED struct {
+	MessageAuth
+	FromRound int
+	Clients   []int // all the clients excluded so far
+}
+
+type Struct_TRUSTEE_RATE_CHANGE struct {
+	*onet.TreeNode
+	TRUSTEE_RATE_CHANGE
+}
+
+// TRUSTEE_RATE_CHANGE is sent by Client0 to a trustee when its cache of that trustee's ciphertexts crosses a bound
+type TRUSTEE_RATE_CHANGE struct {
+	MessageAuth
+	SlowDown bool // true to pause the trustee, false to resume it
+}
+
+type Struct_ROUND_OUTPUT struct {
+	*onet.TreeNode
+	ROUND_OUTPUT
+}
+
+// ROUND_OUTPUT is broadcasted by Client0 to all clients once it decoded a round
+type ROUND_OUTPUT struct {
+	MessageAuth
+	RoundID        int
+	Data           []byte // the cleartext of all slots
+	DownstreamData []byte // at most CellSizeDown bytes added by Client0
+	SlotKeys       []kyber.Scalar // the key of each slot, only with equivocation protection
+}
+
+type Struct_DOWNSTREAM_NACK struct {
+	*onet.TreeNode
+	DOWNSTREAM_NACK
+}
+
+// DOWNSTREAM_NACK is sent by a client to Client0 when it missed some round outputs on the fast channel
+type DOWNSTREAM_NACK struct {
+	MessageAuth
+	From int // the sequence number of the first missing output
+}
+
+type Struct_DOWNSTREAM_RETRANSMIT struct {
+	*onet.TreeNode
+	DOWNSTREAM_RETRANSMIT
+}
+
+// DOWNSTREAM_RETRANSMIT is sent by Client0 to a client in response to a DOWNSTREAM_NACK, with the
+// round outputs as signed for the fast channel
+type DOWNSTREAM_RETRANSMIT struct {
+	MessageAuth
+	Outputs []ROUND_OUTPUT
+}
+
+type Struct_PSEUDONYM struct {
+	*onet.TreeNode
+	PSEUDONYM
+}
+
+// PSEUDONYM is sent by each client to Client0; it is the ElGamal encryption of a fresh pseudonym key
+// under the trustees' keys
+type PSEUDONYM struct {
+	MessageAuth
+	X kyber.Point
+	Y kyber.Point
+}
+
+// ShuffleStep is the contribution of one trustee to the shuffle : its shuffled and
+// partially decrypted pseudonyms, with the proofs that it did so correctly
+type ShuffleStep struct {
+	XBar             []kyber.Point
+	YBar             []kyber.Point
+	ShuffleProof     []byte
+	YDecrypted       []kyber.Point
+	DecryptionProofs []*dleq.Proof
+}
+
+// ShuffleTranscript contains the encrypted pseudonyms of the clients, and the steps of the trustees done so far
+type ShuffleTranscript struct {
+	Pseudonyms []PSEUDONYM // the pseudonym signed by each client, ordered by client ID
+	InitialX   []kyber.Point
+	InitialY   []kyber.Point
+	Steps      []ShuffleStep
+}
+
+type Struct_SHUFFLE_REQUEST struct {
+	*onet.TreeNode
+	SHUFFLE_REQUEST
+}
+
+// SHUFFLE_REQUEST is sent by Client0 to the next trustee that needs to shuffle
+type SHUFFLE_REQUEST struct {
+	MessageAuth
+	Transcript ShuffleTranscript
+}
+
+type Struct_TRUSTEE_SHUFFLE struct {
+	*onet.TreeNode
+	TRUSTEE_SHUFFLE
+}
+
+// TRUSTEE_SHUFFLE is sent by a trustee to Client0 once it did its shuffle step
+type TRUSTEE_SHUFFLE struct {
+	MessageAuth
+	Step ShuffleStep
+}
+
+type Struct_SHUFFLE_TRANSCRIPT struct {
+	*onet.TreeNode
+	SHUFFLE_TRANSCRIPT
+}
+
+// SHUFFLE_TRANSCRIPT is broadcasted by Client0 once all trustees shuffled
+type SHUFFLE_TRANSCRIPT struct {
+	MessageAuth
+	Transcript ShuffleTranscript
+}
+
+type Struct_BLAME_REQUEST struct {
+	*onet.TreeNode
+	BLAME_REQUEST
+}
+
+// BLAME_REQUEST is broadcasted by Client0 after a valid accusation; everyone checks the accusation, then
+// reveals its pad bits at BitPos
+type BLAME_REQUEST struct {
+	MessageAuth
+	RoundID    int
+	BitPos     int
+	Slot       int    // the slot in which the accusation was found
+	Accusation []byte // signed with the pseudonym key of that slot
+}
+
+type Struct_BLAME_BITS struct {
+	*onet.TreeNode
+	BLAME_BITS
+}
+
+// BLAME_BITS is sent to Client0 in answer to a BLAME_REQUEST; it contains one pad bit per peer
+type BLAME_BITS struct {
+	MessageAuth
+	RoundID int
+	BitPos  int
+	PadBits []byte
+}
+
+type Struct_BLAME_SECRET_REQUEST struct {
+	*onet.TreeNode
+	BLAME_SECRET_REQUEST
+}
+
+// BLAME_SECRET_REQUEST is sent by Client0 to a trustee which disagrees with a client about their pad bit
+type BLAME_SECRET_REQUEST struct {
+	MessageAuth
+	RoundID    int
+	BitPos     int
+	ClientID   int
+	ClientBits BLAME_BITS // the pad bits of the client, as signed by it
+}
+
+type Struct_BLAME_SECRET struct {
+	*onet.TreeNode
+	BLAME_SECRET
+}
+
+// BLAME_SECRET reveals the secret shared by a trustee and a client, with a proof of its correctness
+type BLAME_SECRET struct {
+	MessageAuth
+	RoundID  int
+	ClientID int
+	Secret   kyber.Point
+	Proof    *dleq.Proof
+}
+
+// BlameEvidence contains everything needed to verify who disrupted an accused bit. Client0 is not
+// trusted, so the evidence is made of the messages of the other nodes, as signed by them.
+type BlameEvidence struct {
+	RoundID           int
+	BitPos            int
+	Slot              int
+	Accusation        []byte           // the accusation, as decoded in the accuser's slot
+	ClientBits        []BLAME_BITS     // the pad bits revealed by each client, one per trustee
+	TrusteeBits       []BLAME_BITS     // the pad bits revealed by each trustee, one per client
+	ClientCiphers     []CLIENT_CIPHER  // the ciphertexts of the accused round
+	TrusteeCiphers    []TRUSTEE_CIPHER // the ciphertexts of the accused round
+	RevealedTrusteeID int
+	RevealedSecret    *BLAME_SECRET // only if a client and a trustee disagree
+	DisruptorRole     DissentRole
+	DisruptorID       int
+}
+
+type Struct_BLAME_VERDICT struct {
+	*onet.TreeNode
+	BLAME_VERDICT
+}
+
+// BLAME_VERDICT is broadcasted by Client0 to everyone once the disruptor is identified
+type BLAME_VERDICT struct {
+	MessageAuth
+	Evidence BlameEvidence
+}
+
+type Struct_BLAME_FAILED struct {
+	*onet.TreeNode
+	BLAME_FAILED
+}
+
+// BLAME_FAILED is broadcasted by Client0 to everyone when a blame cannot identify a disruptor
+type BLAME_FAILED struct {
+	MessageAuth
+	RoundID int
+	Reason  string
+}
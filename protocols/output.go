@@ -0,0 +1,131 @@
+package protocols
+
+// This file contains the data output (ClientDataOutputEnabled, RelayDataOutputEnabled).
+//
+// When enabled (RelayDataOutputEnabled on Client0, ClientDataOutputEnabled on the other clients) and
+// DataOutputPath is set, a node writes the data decoded in each round to DataOutputPath: a file, a
+// named pipe, or the standard output if the path is "-". DataOutputPath is empty by default, as the
+// records would otherwise be mixed with the logs. Each record is one JSON object per line:
+//
+//	{"time":"2006-01-02T15:04:05.999999999Z","round":12,"slot":3,"kind":"up","data":"<base64>"}
+//
+// where kind is "up" for the data of a slot, "message" for a message reassembled from the
+// fragments of a slot (see framing.go), and "down" for the downstream data, whose slot is -1.
+// The records are written by a separate goroutine, so a slow reader never blocks the rounds;
+// records are dropped if outputBufferSize of them are waiting.
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/onet.v2/log"
+)
+
+// outputBufferSize is the number of records waiting to be written before new ones are dropped
+const outputBufferSize = 4096
+
+// OutputRecord is a line of the data output
+type OutputRecord struct {
+	Time  time.Time `json:"time"`
+	Round int       `json:"round"`
+	Slot  int       `json:"slot"`
+	Kind  string    `json:"kind"`
+	Data  []byte    `json:"data"`
+}
+
+// outputSink writes the records of the data output
+type outputSink struct {
+	sync.Mutex
+	records chan *OutputRecord
+	dropped int
+	closed  bool // the records written after the protocol stopped are dropped
+}
+
+// dataOutputEnabled returns true if this node writes its decoded data
+func (p *DissentProtocol) dataOutputEnabled() bool {
+	if p.config.Toml.DataOutputPath == "" {
+		return false
+	}
+	if p.role == Client0 {
+		return p.config.Toml.RelayDataOutputEnabled
+	}
+	return p.role == Client && p.config.Toml.ClientDataOutputEnabled
+}
+
+// startDataOutput opens the data output, if it is enabled
+func (p *DissentProtocol) startDataOutput() {
+	if !p.dataOutputEnabled() {
+		return
+	}
+	sink := &outputSink{records: make(chan *OutputRecord, outputBufferSize)}
+	p.output = sink
+	go sink.run(p.config.Toml.DataOutputPath)
+}
+
+// run opens the output, which blocks for a named pipe until it has a reader, then writes the records
+func (s *outputSink) run(path string) {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Error("Could not open the data output", path, ":", err)
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+
+	encoder := json.NewEncoder(w)
+	for record := range s.records {
+		if err := encoder.Encode(record); err != nil {
+			log.Error("Could not write to the data output:", err)
+			return
+		}
+	}
+}
+
+// write queues a record, or drops it if the output is too slow
+func (s *outputSink) write(roundID, slot int, kind string, data []byte) {
+	record := &OutputRecord{Time: time.Now(), Round: roundID, Slot: slot, Kind: kind, Data: data}
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.records <- record:
+	default:
+		s.dropped++
+		if s.dropped%outputBufferSize == 1 {
+			log.Error("The data output is too slow,", s.dropped, "records dropped so far")
+		}
+	}
+}
+
+// close stops the output once the queued records are written
+func (s *outputSink) close() {
+	s.Lock()
+	defer s.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.records)
+	}
+}
+
+// outputRound is called on clients with the data of each round
+func (p *DissentProtocol) outputRound(roundID int, payloads [][]byte, downstream []byte) {
+	if p.output == nil {
+		return
+	}
+	for slot, data := range payloads {
+		if data != nil {
+			p.output.write(roundID, slot, "up", data)
+		}
+	}
+	if len(downstream) > 0 {
+		p.output.write(roundID, -1, "down", downstream)
+	}
+}
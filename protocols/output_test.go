@@ -0,0 +1,79 @@
+package protocols
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDataOutputEnabled(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   DissentRole
+		relay  bool
+		client bool
+		path   string
+		on     bool
+	}{
+		{"Client0", Client0, true, false, "out.jsonl", true},
+		{"Client0 disabled", Client0, false, true, "out.jsonl", false},
+		{"client", Client, false, true, "-", true},
+		{"client disabled", Client, true, false, "-", false},
+		{"trustee", Trustee, true, true, "out.jsonl", false},
+		{"no path", Client, true, true, "", false},
+	}
+	for _, test := range tests {
+		toml := &DissentTomlConfig{RelayDataOutputEnabled: test.relay, ClientDataOutputEnabled: test.client, DataOutputPath: test.path}
+		p := &DissentProtocol{role: test.role, config: DissentProtocolConfig{Toml: toml}}
+		if p.dataOutputEnabled() != test.on {
+			t.Errorf("%s: enabled %v, expected %v", test.name, !test.on, test.on)
+		}
+	}
+}
+
+func TestOutputSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.jsonl")
+
+	//a sink of two records, whose writer is not running yet
+	s := &outputSink{records: make(chan *OutputRecord, 2)}
+	s.write(1, 0, "up", []byte("a"))
+	s.write(1, -1, "down", []byte("b"))
+	s.write(2, 0, "up", []byte("dropped"))
+	s.close()
+	s.close()
+	s.write(3, 0, "up", []byte("after close"))
+	if s.dropped != 1 {
+		t.Errorf("%d records dropped, expected 1", s.dropped)
+	}
+	s.run(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	expected := []OutputRecord{{Round: 1, Slot: 0, Kind: "up", Data: []byte("a")}, {Round: 1, Slot: -1, Kind: "down", Data: []byte("b")}}
+	scanner := bufio.NewScanner(f)
+	k := 0
+	for ; scanner.Scan(); k++ {
+		var r OutputRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal("line", k, ":", err)
+		}
+		if k >= len(expected) || r.Round != expected[k].Round || r.Slot != expected[k].Slot ||
+			r.Kind != expected[k].Kind || string(r.Data) != string(expected[k].Data) {
+			t.Errorf("line %d: got %+v", k, r)
+		}
+	}
+	if k != len(expected) {
+		t.Errorf("%d lines written, expected %d", k, len(expected))
+	}
+}
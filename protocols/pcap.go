@@ -0,0 +1,227 @@
+package protocols
+
+// This file contains the replay of PCAP traces (ReplayPCAP).
+//
+// Each client replays one of the .pcap files of PCAPFolder (the files are sorted, and client i
+// takes file i modulo their number). A packet is sent in the client's slot at the time it was
+// captured, relative to the first packet; a packet larger than a slot is cut into several slots
+// with the kind slotReplay. Only the sizes and timing of the packets are replayed, not their
+// content. Client0 records the end-to-end latency of each packet when its last part is decoded;
+// the clocks of the clients and of Client0 are assumed to be synchronized. Once no packet arrived
+// for pcapIdleTime, the replay is over, and Client0 sends the latency of every packet to the
+// ReplayChannel, which has room for this single report.
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/onet.v2/log"
+)
+
+// replayHeaderSize is the size of the header of a replayed packet: packet ID, part, number of parts, send time, size
+const replayHeaderSize = 4 + 2 + 2 + 8 + 4
+
+// pcapIdleTime is the time without packets after which Client0 considers the replay over
+const pcapIdleTime = 5 * time.Second
+
+// pcapPacket is a packet read from a trace
+type pcapPacket struct {
+	offset time.Duration // capture time, relative to the first packet
+	size   int           // original length of the packet
+}
+
+// readPCAP reads the capture time and size of each packet of a trace in the classic pcap format
+func readPCAP(file string) ([]pcapPacket, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	var nanoseconds bool
+	switch {
+	case binary.LittleEndian.Uint32(header) == 0xa1b2c3d4:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header) == 0xa1b2c3d4:
+		order = binary.BigEndian
+	case binary.LittleEndian.Uint32(header) == 0xa1b23c4d:
+		order, nanoseconds = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header) == 0xa1b23c4d:
+		order, nanoseconds = binary.BigEndian, true
+	default:
+		return nil, errors.New(file + " is not a pcap file")
+	}
+
+	packets := make([]pcapPacket, 0)
+	var first time.Duration
+	record := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(f, record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		ts := time.Duration(order.Uint32(record[0:4])) * time.Second
+		if nanoseconds {
+			ts += time.Duration(order.Uint32(record[4:8]))
+		} else {
+			ts += time.Duration(order.Uint32(record[4:8])) * time.Microsecond
+		}
+		captured := int64(order.Uint32(record[8:12]))
+		if _, err := f.Seek(captured, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		if len(packets) == 0 {
+			first = ts
+		}
+		packets = append(packets, pcapPacket{offset: ts - first, size: int(order.Uint32(record[12:16]))})
+	}
+	return packets, nil
+}
+
+// pcapFileFor returns the trace replayed by the given client
+func pcapFileFor(folder string, clientID int) (string, error) {
+	infos, err := ioutil.ReadDir(folder)
+	if err != nil {
+		return "", err
+	}
+	files := make([]string, 0)
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".pcap") {
+			files = append(files, info.Name())
+		}
+	}
+	if len(files) == 0 {
+		return "", errors.New("no .pcap file in " + folder)
+	}
+	sort.Strings(files)
+	return path.Join(folder, files[clientID%len(files)]), nil
+}
+
+// replayPCAP is run by the clients in their own goroutine; it sends the packets of their trace with their original timing
+func (p *DissentProtocol) replayPCAP() {
+	file, err := pcapFileFor(p.config.Toml.PCAPFolder, p.myID)
+	if err != nil {
+		log.Error("Cannot replay a trace:", err)
+		return
+	}
+	packets, err := readPCAP(file)
+	if err != nil {
+		log.Error("Cannot read the trace", file, ":", err)
+		return
+	}
+	log.Lvl1("Replaying", len(packets), "packets of", file)
+
+	partSize := MaxUpstreamPayload(p.config.Toml) - replayHeaderSize
+	start := time.Now()
+	for id, packet := range packets {
+		if wait := time.Until(start.Add(packet.offset)); wait > 0 {
+			time.Sleep(wait)
+		}
+		if p.HasStopped {
+			return
+		}
+
+		count := (packet.size + partSize - 1) / partSize
+		if count == 0 {
+			count = 1
+		}
+		sent := time.Now().UnixNano()
+		for part := 0; part < count; part++ {
+			buf := make([]byte, replayHeaderSize)
+			binary.BigEndian.PutUint32(buf[0:4], uint32(id))
+			binary.BigEndian.PutUint16(buf[4:6], uint16(part))
+			binary.BigEndian.PutUint16(buf[6:8], uint16(count))
+			binary.BigEndian.PutUint64(buf[8:16], uint64(sent))
+			binary.BigEndian.PutUint32(buf[16:20], uint32(packet.size))
+			p.replayQueue.push(buf)
+		}
+	}
+	log.Lvl1("Done replaying", file)
+}
+
+// ReplayedPacket is the latency of a replayed packet, measured by Client0
+type ReplayedPacket struct {
+	Slot    int
+	ID      uint32
+	Size    int
+	Latency time.Duration
+}
+
+// String formats a replayed packet with its latency in milliseconds
+func (r ReplayedPacket) String() string {
+	return "pcap slot " + strconv.Itoa(r.Slot) + " packet " + strconv.Itoa(int(r.ID)) +
+		" size " + strconv.Itoa(r.Size) + " latency " + strconv.FormatInt(r.Latency.Nanoseconds()/1e6, 10) + "ms"
+}
+
+// pcapRecorder holds, on Client0, the latency of each replayed packet
+type pcapRecorder struct {
+	sync.Mutex
+	packets  []ReplayedPacket
+	last     time.Time
+	reported bool
+}
+
+// recordReplayed is called on Client0 with the slots of each round; it records the latency of the packets whose last part arrived
+func (p *DissentProtocol) recordReplayed(slots [][]byte) {
+	r := &p.pcapRecorder
+	now := time.Now()
+	for slot, content := range slots {
+		if len(content) < slotHeaderSize+replayHeaderSize || content[0] != slotReplay {
+			continue
+		}
+		buf := content[slotHeaderSize:]
+		part := int(binary.BigEndian.Uint16(buf[4:6]))
+		count := int(binary.BigEndian.Uint16(buf[6:8]))
+		if part != count-1 {
+			continue
+		}
+		id := binary.BigEndian.Uint32(buf[0:4])
+		sent := time.Unix(0, int64(binary.BigEndian.Uint64(buf[8:16])))
+		size := binary.BigEndian.Uint32(buf[16:20])
+		latency := now.Sub(sent)
+
+		r.Lock()
+		r.packets = append(r.packets, ReplayedPacket{Slot: slot, ID: id, Size: int(size), Latency: latency})
+		r.last = now
+		r.Unlock()
+		log.Lvl3("Replayed packet", id, "of slot", slot, "(", size, "bytes ) arrived after", latency)
+	}
+}
+
+// reportReplay is run by Client0 in its own goroutine; it sends the latencies to the ReplayChannel once the replay is over
+func (p *DissentProtocol) reportReplay() {
+	r := &p.pcapRecorder
+	for !p.HasStopped {
+		time.Sleep(pcapIdleTime / 5)
+
+		r.Lock()
+		done := len(r.packets) > 0 && !r.reported && time.Since(r.last) > pcapIdleTime
+		if done {
+			r.reported = true
+		}
+		packets := r.packets
+		r.Unlock()
+
+		if done {
+			log.Lvl1("Client0 : replay over,", len(packets), "packets received")
+			//the only report, which the buffer of the channel holds until it is read
+			p.ReplayChannel <- packets
+			return
+		}
+	}
+}
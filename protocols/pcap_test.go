@@ -0,0 +1,146 @@
+package protocols
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// pcapTrace returns a pcap file with the given capture times and sizes; each packet captures 4 bytes
+func pcapTrace(order binary.ByteOrder, nanoseconds bool, times []time.Duration, sizes []int) []byte {
+	magic := uint32(0xa1b2c3d4)
+	unit := time.Microsecond
+	if nanoseconds {
+		magic, unit = 0xa1b23c4d, time.Nanosecond
+	}
+	buf := make([]byte, 24)
+	order.PutUint32(buf[0:4], magic)
+	for i := range times {
+		record := make([]byte, 16+4)
+		order.PutUint32(record[0:4], uint32(times[i]/time.Second))
+		order.PutUint32(record[4:8], uint32((times[i]%time.Second)/unit))
+		order.PutUint32(record[8:12], 4)
+		order.PutUint32(record[12:16], uint32(sizes[i]))
+		buf = append(buf, record...)
+	}
+	return buf
+}
+
+func TestReadPCAP(t *testing.T) {
+	folder, err := ioutil.TempDir("", "pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	times := []time.Duration{10*time.Second + 500*time.Microsecond, 10*time.Second + 2*time.Millisecond, 12 * time.Second}
+	sizes := []int{60, 1500, 0}
+	want := []pcapPacket{{0, 60}, {1500 * time.Microsecond, 1500}, {2*time.Second - 500*time.Microsecond, 0}}
+	trace := pcapTrace(binary.LittleEndian, false, times, sizes)
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    []pcapPacket
+		ok      bool
+	}{
+		{"little endian", trace, want, true},
+		{"big endian", pcapTrace(binary.BigEndian, false, times, sizes), want, true},
+		{"nanoseconds", pcapTrace(binary.LittleEndian, true, times, sizes), want, true},
+		{"no packet", trace[:24], []pcapPacket{}, true},
+		{"not a pcap file", make([]byte, 24), nil, false},
+		{"truncated header", trace[:10], nil, false},
+		{"truncated record", trace[:24+8], nil, false},
+	}
+	for i, test := range tests {
+		file := path.Join(folder, string(rune('a'+i))+".pcap")
+		if err := ioutil.WriteFile(file, test.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		packets, err := readPCAP(file)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v, want success %v", test.name, err, test.ok)
+			continue
+		}
+		if test.ok && !reflect.DeepEqual(packets, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, packets, test.want)
+		}
+	}
+}
+
+func TestPcapFileFor(t *testing.T) {
+	folder, err := ioutil.TempDir("", "pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	if _, err := pcapFileFor(folder, 0); err == nil {
+		t.Error("got a trace in an empty folder")
+	}
+	for _, name := range []string{"b.pcap", "a.pcap", "notes.txt"} {
+		if err := ioutil.WriteFile(path.Join(folder, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(path.Join(folder, "c.pcap"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		clientID int
+		want     string
+	}{
+		{0, "a.pcap"},
+		{1, "b.pcap"},
+		{2, "a.pcap"},
+		{5, "b.pcap"},
+	}
+	for _, test := range tests {
+		file, err := pcapFileFor(folder, test.clientID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file != path.Join(folder, test.want) {
+			t.Errorf("client %d: got %s, want %s", test.clientID, file, test.want)
+		}
+	}
+}
+
+func TestRecordReplayed(t *testing.T) {
+	part := func(id uint32, part, count uint16, size uint32) []byte {
+		buf := make([]byte, slotHeaderSize+replayHeaderSize)
+		buf[0] = slotReplay
+		binary.BigEndian.PutUint32(buf[1:5], id)
+		binary.BigEndian.PutUint16(buf[5:7], part)
+		binary.BigEndian.PutUint16(buf[7:9], count)
+		binary.BigEndian.PutUint64(buf[9:17], uint64(time.Now().UnixNano()))
+		binary.BigEndian.PutUint32(buf[17:21], size)
+		return buf
+	}
+	p := &DissentProtocol{}
+	p.recordReplayed([][]byte{
+		part(1, 0, 2, 3000),
+		nil,
+		part(2, 0, 1, 100),
+		[]byte{slotReplay, 1, 2},
+		part(1, 1, 2, 3000),
+	})
+
+	//only the last part of a packet tells that it arrived
+	got := make([]ReplayedPacket, len(p.pcapRecorder.packets))
+	for i, r := range p.pcapRecorder.packets {
+		if r.Latency < 0 || r.Latency > time.Second {
+			t.Errorf("packet %d: got latency %v", r.ID, r.Latency)
+		}
+		got[i] = ReplayedPacket{Slot: r.Slot, ID: r.ID, Size: r.Size}
+	}
+	want := []ReplayedPacket{{Slot: 2, ID: 2, Size: 100}, {Slot: 4, ID: 1, Size: 3000}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
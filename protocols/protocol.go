@@ -1,11 +1,13 @@
 package protocols
 
 import (
+	"gopkg.in/dedis/onet.v2/log"
 	"gopkg.in/dedis/onet.v2/network"
 	"gopkg.in/dedis/onet.v2"
 	"gopkg.in/dedis/kyber.v2"
 	"github.com/dedis/prifi/prifi-lib/crypto"
 	"errors"
+	"sync"
 )
 
 const ProtocolName = "DissentProtocol"
@@ -16,28 +18,118 @@ type DissentProtocol struct {
 	config        DissentProtocolConfig
 	role          DissentRole
 	ms            MessageSender
-	toHandler     func([]string, []string)
+	toHandler     func(lateClients, lateTrustees []string, resync bool)
 	ResultChannel chan interface{}
+	LatencyChannel chan []LatencyReport // the last report of the latency tests, see latency.go
+	ReplayChannel  chan []ReplayedPacket // the latencies of the replayed packets, see pcap.go
 
 	nClients int
 	nTrustees int
+	myID      int // our ID among the clients (resp. trustees)
 
 	keyPriv		kyber.Scalar
 	keyPub 	kyber.Point
 
+	keyExchange   *keyExchangeState // only used by Client0
+	clientKeys    []kyber.Point
+	trusteeKeys   []kyber.Point
+	sharedSecrets []kyber.Point // one per trustee if we are a client, one per client if we are a trustee
+
+	shuffle       *shuffleState // only used by Client0
+	transcript    ShuffleTranscript // only used by Client0
+	pseudonymPriv kyber.Scalar
+	pseudonymPub  kyber.Point
+	pseudonymX    kyber.Point
+	pseudonymY    kyber.Point
+	pseudonymKeys []kyber.Point // the key of the owner of each slot
+	slot          int
+
+	roundsLock      sync.Mutex          // protects the rounds of Client0 against the round timeouts
+	nextRound       int                 // next round to announce, only used by Client0
+	nextOutput      int                 // next round to decode and broadcast, only used by Client0
+	rounds          map[int]*roundState // rounds in flight, and future rounds with trustee ciphertexts; only used by Client0
+	padCache        *trusteeCache       // only used by Client0
+	flow            trusteeFlow         // only used by trustees
+	upstreamQueue   dataQueue
+	fragmentQueue   dataQueue    // the fragments of the messages to send, see framing.go
+	replayQueue     dataQueue    // the parts of the replayed packets to send, see pcap.go
+	pcapRecorder    pcapRecorder // only used by Client0
+	probeQueue      dataQueue    // the latency probes to send, see latency.go
+	latency         latencyState
+	downstreamQueue dataQueue // only used by Client0
+	excludedClients map[int]bool // clients excluded after a timeout, only used by Client0
+	failedRounds    int          // consecutive rounds which timed out, only used by Client0
+	reservations    reservationState     // only used by Client0
+	layouts         map[int]roundLayout  // the layout of each round in flight, only used by clients
+	outputHandler   func(roundID int, slots [][]byte, downstream []byte)
+	messageHandler  func(roundID int, slot int, data []byte)
+	reassembler     *reassembler
+	history         historyChain // the round outputs received so far
+	clientLock      sync.Mutex   // serializes the rounds of a client, as outputs also arrive on the fast channel
+	fast            *fastChannel // nil unless UseUDP is set
+	output          *outputSink  // nil unless the data output is enabled, see output.go
+	relayHistory    historyChain // the round outputs broadcasted so far, only used by Client0
+
+	sentSlots         map[int][]byte      // what we put in our slot, per round
+	pendingAccusation []byte              // sent in our next slot
+	pastRounds        map[int]*roundState // only used by Client0
+	blame             *blameState         // only used by Client0
+	answeredBlame     *blameAnswer        // the last blame we revealed our pad bits for
+	blameHandler      func(disruptor *network.ServerIdentity)
+
+	stateLock sync.Mutex
+	state     ProtocolState
+	stopOnce  sync.Once
+
 	HasStopped       bool
 }
 
-// Stop aborts the current execution of the protocol.
+// Stop aborts the current execution of the protocol. It can be called several times.
 func (p *DissentProtocol) Stop() {
-	p.HasStopped = true
-	p.Shutdown()
+	p.stopOnce.Do(func() {
+		p.stateLock.Lock()
+		p.state = StateStopped
+		p.stateLock.Unlock()
+
+		p.HasStopped = true
+		if p.fast != nil {
+			p.fast.close()
+		}
+		if p.output != nil {
+			p.output.close()
+		}
+		p.Shutdown()
+	})
+}
+
+// abort stops the protocol after a fatal protocol violation
+func (p *DissentProtocol) abort(reason error) {
+	log.Error("Aborting the protocol:", reason)
+	go p.Stop()
 }
 
 func init() {
 	network.RegisterMessage(NEW_ROUND{})
 	network.RegisterMessage(PUBLIC_KEY{})
 	network.RegisterMessage(ALL_ALL_PARAMETERS{})
+	network.RegisterMessage(ALL_PUBLIC_KEYS{})
+	network.RegisterMessage(CLIENT_CIPHER{})
+	network.RegisterMessage(TRUSTEE_CIPHER{})
+	network.RegisterMessage(TRUSTEE_RATE_CHANGE{})
+	network.RegisterMessage(CLIENTS_EXCLUDED{})
+	network.RegisterMessage(ROUND_OUTPUT{})
+	network.RegisterMessage(DOWNSTREAM_NACK{})
+	network.RegisterMessage(DOWNSTREAM_RETRANSMIT{})
+	network.RegisterMessage(PSEUDONYM{})
+	network.RegisterMessage(SHUFFLE_REQUEST{})
+	network.RegisterMessage(TRUSTEE_SHUFFLE{})
+	network.RegisterMessage(SHUFFLE_TRANSCRIPT{})
+	network.RegisterMessage(BLAME_REQUEST{})
+	network.RegisterMessage(BLAME_BITS{})
+	network.RegisterMessage(BLAME_SECRET_REQUEST{})
+	network.RegisterMessage(BLAME_SECRET{})
+	network.RegisterMessage(BLAME_VERDICT{})
+	network.RegisterMessage(BLAME_FAILED{})
 
 	onet.GlobalProtocolRegister(ProtocolName, NewDissentProtocol)
 }
@@ -56,6 +148,78 @@ func (p *DissentProtocol) registerHandlers() error {
 	if err != nil {
 		return errors.New("couldn't register handler: " + err.Error())
 	}
+	err = p.RegisterHandler(p.Received_ALL_PUBLIC_KEYS)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_CLIENT_CIPHER)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_TRUSTEE_CIPHER)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_TRUSTEE_RATE_CHANGE)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_CLIENTS_EXCLUDED)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_ROUND_OUTPUT)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_DOWNSTREAM_NACK)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_DOWNSTREAM_RETRANSMIT)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_PSEUDONYM)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_SHUFFLE_REQUEST)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_TRUSTEE_SHUFFLE)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_SHUFFLE_TRANSCRIPT)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_BLAME_REQUEST)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_BLAME_BITS)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_BLAME_SECRET_REQUEST)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_BLAME_SECRET)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_BLAME_VERDICT)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_BLAME_FAILED)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
 
 	return nil
 }
@@ -67,6 +231,8 @@ func NewDissentProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error)
 	p := &DissentProtocol{
 		TreeNodeInstance: n,
 		ResultChannel:    make(chan interface{}),
+		LatencyChannel:   make(chan []LatencyReport, 1),
+		ReplayChannel:    make(chan []ReplayedPacket, 1),
 	}
 
 	return p, nil
@@ -86,9 +252,25 @@ func (p *DissentProtocol) SetConfigFromDissentService(config *DissentProtocolCon
 	p.nTrustees = len(p.ms.trustees)
 
 	p.keyPub, p.keyPriv = crypto.NewKeyPair()
+	p.myID = -1
+	p.slot = -1
+	p.sentSlots = make(map[int][]byte)
+	p.layouts = make(map[int]roundLayout)
+	p.reassembler = newReassembler()
 
 	switch config.Role {
 	case Client0:
+		p.keyExchange = newKeyExchangeState()
+		p.rounds = make(map[int]*roundState)
+		p.padCache = newTrusteeCache()
+		if low, high := trusteeCacheBounds(config.Toml); high != config.Toml.RelayTrusteeCacheHighBound || low != config.Toml.RelayTrusteeCacheLowBound {
+			log.Lvl1("Invalid bounds of the trustee cache, using", low, "and", high)
+		}
+		p.shuffle = newShuffleState()
+		p.pastRounds = make(map[int]*roundState)
+		p.blameHandler = config.BlameHandler
+		p.toHandler = config.TimeoutHandler
+		p.excludedClients = make(map[int]bool)
 		/*relayOutputEnabled := config.Toml.RelayDataOutputEnabled
 		p.prifiLibInstance = prifi_lib.NewPriFiRelay(relayOutputEnabled,
 			config.RelaySideSocksConfig.DownstreamChannel,
@@ -0,0 +1,209 @@
+package protocols
+
+// This file contains the verifiable shuffle of the clients' pseudonym keys, which
+// assigns the DC-net slots.
+//
+// Each client encrypts a fresh pseudonym key with ElGamal under the sum of the
+// trustees' keys. Each trustee in turn re-encrypts and permutes the ciphertexts
+// (Neff shuffle), then removes its layer of encryption. After the last trustee,
+// the ciphertexts decrypt to the pseudonym keys in a random order; slot i
+// belongs to the owner of the i-th pseudonym key. The transcript carries the
+// PSEUDONYM message signed by each client, so that everyone checks that Client0
+// did not replace or add pseudonyms before the shuffle.
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/kyber.v2/proof"
+	"gopkg.in/dedis/kyber.v2/proof/dleq"
+	"gopkg.in/dedis/kyber.v2/shuffle"
+	"gopkg.in/dedis/onet.v2/network"
+)
+
+// shuffleProtocolName is used by the Fiat-Shamir transform of the shuffle proofs
+const shuffleProtocolName = "DissentPseudonymShuffle"
+
+// shuffleState holds the signed pseudonyms collected by Client0
+type shuffleState struct {
+	pseudonyms map[int]PSEUDONYM
+}
+
+func newShuffleState() *shuffleState {
+	return &shuffleState{
+		pseudonyms: make(map[int]PSEUDONYM),
+	}
+}
+
+// collectPseudonym stores the signed pseudonym of a client, and returns true when all clients sent theirs
+func (s *shuffleState) collectPseudonym(clientID int, pseudonym PSEUDONYM, nClients int) bool {
+	s.pseudonyms[clientID] = pseudonym
+	return len(s.pseudonyms) == nClients
+}
+
+// transcript returns the initial transcript with the pseudonyms ordered by client ID
+func (s *shuffleState) transcript() ShuffleTranscript {
+	t := ShuffleTranscript{
+		Pseudonyms: make([]PSEUDONYM, len(s.pseudonyms)),
+		InitialX:   make([]kyber.Point, len(s.pseudonyms)),
+		InitialY:   make([]kyber.Point, len(s.pseudonyms)),
+	}
+	for i := range t.Pseudonyms {
+		t.Pseudonyms[i] = s.pseudonyms[i]
+		t.InitialX[i] = s.pseudonyms[i].X
+		t.InitialY[i] = s.pseudonyms[i].Y
+	}
+	return t
+}
+
+// newPseudonym creates a fresh pseudonym key pair, and its ElGamal encryption under the trustees' keys
+func (p *DissentProtocol) newPseudonym() {
+	suite := config.CryptoSuite
+	p.pseudonymPriv = suite.Scalar().Pick(suite.RandomStream())
+	p.pseudonymPub = suite.Point().Mul(p.pseudonymPriv, nil)
+
+	r := suite.Scalar().Pick(suite.RandomStream())
+	p.pseudonymX = suite.Point().Mul(r, nil)
+	p.pseudonymY = suite.Point().Add(p.pseudonymPub, suite.Point().Mul(r, aggregateKey(p.trusteeKeys, 0)))
+}
+
+// aggregateKey returns the sum of the keys of the trustees from the given index on, i.e., the key
+// under which the pseudonyms are encrypted before that trustee's shuffle step
+func aggregateKey(trusteeKeys []kyber.Point, from int) kyber.Point {
+	h := config.CryptoSuite.Point().Null()
+	for _, key := range trusteeKeys[from:] {
+		h.Add(h, key)
+	}
+	return h
+}
+
+// lastOutput returns the ciphertexts produced by the last step of the transcript
+func (t *ShuffleTranscript) lastOutput() ([]kyber.Point, []kyber.Point) {
+	if len(t.Steps) == 0 {
+		return t.InitialX, t.InitialY
+	}
+	last := t.Steps[len(t.Steps)-1]
+	return last.XBar, last.YDecrypted
+}
+
+// doShuffleStep is called on the trustees; it shuffles the last output of the transcript and removes our encryption layer
+func (p *DissentProtocol) doShuffleStep(t *ShuffleTranscript) (*ShuffleStep, error) {
+	suite := config.CryptoSuite
+	G := suite.Point().Base()
+	h := aggregateKey(p.trusteeKeys, p.myID)
+	X, Y := t.lastOutput()
+
+	XBar, YBar, prover := shuffle.Shuffle(suite, G, h, X, Y, suite.RandomStream())
+	shuffleProof, err := proof.HashProve(suite, shuffleProtocolName, prover)
+	if err != nil {
+		return nil, err
+	}
+
+	step := &ShuffleStep{
+		XBar:             XBar,
+		YBar:             YBar,
+		ShuffleProof:     shuffleProof,
+		YDecrypted:       make([]kyber.Point, len(YBar)),
+		DecryptionProofs: make([]*dleq.Proof, len(YBar)),
+	}
+	for i := range YBar {
+		decryptionProof, _, share, err := dleq.NewDLEQProof(suite, G, XBar[i], p.keyPriv)
+		if err != nil {
+			return nil, err
+		}
+		step.YDecrypted[i] = suite.Point().Sub(YBar[i], share)
+		step.DecryptionProofs[i] = decryptionProof
+	}
+	return step, nil
+}
+
+// verifyShuffleStep checks the shuffle and decryption proofs of the step of the given trustee
+func verifyShuffleStep(step *ShuffleStep, X, Y []kyber.Point, trusteeKeys []kyber.Point, trusteeID int) error {
+	suite := config.CryptoSuite
+	G := suite.Point().Base()
+	h := aggregateKey(trusteeKeys, trusteeID)
+
+	if len(step.XBar) != len(X) || len(step.YBar) != len(Y) || len(step.YDecrypted) != len(Y) || len(step.DecryptionProofs) != len(Y) {
+		return errors.New("step of trustee " + strconv.Itoa(trusteeID) + " has the wrong number of elements")
+	}
+
+	verifier := shuffle.Verifier(suite, G, h, X, Y, step.XBar, step.YBar)
+	if err := proof.HashVerify(suite, shuffleProtocolName, verifier, step.ShuffleProof); err != nil {
+		return errors.New("invalid shuffle proof of trustee " + strconv.Itoa(trusteeID) + ": " + err.Error())
+	}
+
+	for i := range step.YBar {
+		share := suite.Point().Sub(step.YBar[i], step.YDecrypted[i])
+		if step.DecryptionProofs[i] == nil {
+			return errors.New("missing decryption proof of trustee " + strconv.Itoa(trusteeID))
+		}
+		if err := step.DecryptionProofs[i].Verify(suite, G, step.XBar[i], trusteeKeys[trusteeID], share); err != nil {
+			return errors.New("invalid decryption proof of trustee " + strconv.Itoa(trusteeID) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// verifyShuffleTranscript checks that each initial pseudonym was signed by the client at its position in
+// this session, then every step of the transcript, and returns the ciphertexts output by the last one
+func verifyShuffleTranscript(t *ShuffleTranscript, trusteeKeys []kyber.Point, clients []*network.ServerIdentity, sessionID []byte) ([]kyber.Point, error) {
+	nClients := len(clients)
+	if len(t.Pseudonyms) != nClients || len(t.InitialX) != nClients || len(t.InitialY) != nClients {
+		return nil, errors.New("transcript contains " + strconv.Itoa(len(t.Pseudonyms)) + " pseudonyms, expected " + strconv.Itoa(nClients))
+	}
+	for i := range t.Pseudonyms {
+		pseudonym := &t.Pseudonyms[i]
+		if pseudonym.X == nil || pseudonym.Y == nil || t.InitialX[i] == nil || t.InitialY[i] == nil {
+			return nil, errors.New("the pseudonym of client " + strconv.Itoa(i) + " is missing")
+		}
+		if err := verifyForwarded(clients[i], pseudonym, sessionID); err != nil {
+			return nil, errors.New("the pseudonym of client " + strconv.Itoa(i) + " was not signed by it: " + err.Error())
+		}
+		if !pseudonym.X.Equal(t.InitialX[i]) || !pseudonym.Y.Equal(t.InitialY[i]) {
+			return nil, errors.New("the pseudonym of client " + strconv.Itoa(i) + " differs from the one it signed")
+		}
+	}
+	if len(t.Steps) > len(trusteeKeys) {
+		return nil, errors.New("transcript contains more steps than trustees")
+	}
+
+	X, Y := t.InitialX, t.InitialY
+	for j := range t.Steps {
+		if err := verifyShuffleStep(&t.Steps[j], X, Y, trusteeKeys, j); err != nil {
+			return nil, err
+		}
+		X, Y = t.Steps[j].XBar, t.Steps[j].YDecrypted
+	}
+	return Y, nil
+}
+
+// checkShuffleTranscript verifies a transcript against the long-term keys of the clients of this session
+func (p *DissentProtocol) checkShuffleTranscript(t *ShuffleTranscript) ([]kyber.Point, error) {
+	clients, _, err := p.ms.numbering()
+	if err != nil {
+		return nil, err
+	}
+	return verifyShuffleTranscript(t, p.trusteeKeys, clients, p.ms.auth.sessionID)
+}
+
+// findSlot returns the index of our pseudonym key among the shuffled keys
+func (p *DissentProtocol) findSlot(pseudonymKeys []kyber.Point) (int, error) {
+	for i, key := range pseudonymKeys {
+		if key.Equal(p.pseudonymPub) {
+			return i, nil
+		}
+	}
+	return -1, errors.New("our pseudonym key is not in the shuffle output")
+}
+
+// containsPseudonym returns true iff our encrypted pseudonym is among the initial ones
+func (p *DissentProtocol) containsPseudonym(t *ShuffleTranscript) bool {
+	for i := range t.InitialX {
+		if t.InitialX[i].Equal(p.pseudonymX) && t.InitialY[i].Equal(p.pseudonymY) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package protocols
+
+import (
+	"testing"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/onet.v2/network"
+)
+
+// shuffleSessionID is the session of the shuffles of the tests
+var shuffleSessionID = []byte("session")
+
+// shuffleNodes returns the trustees and clients of a shuffle, with their keys and pseudonyms
+func shuffleNodes(nClients, nTrustees int) ([]*DissentProtocol, []*DissentProtocol, []kyber.Point) {
+	suite := config.CryptoSuite
+	trustees := make([]*DissentProtocol, nTrustees)
+	trusteeKeys := make([]kyber.Point, nTrustees)
+	for j := range trustees {
+		priv := suite.Scalar().Pick(suite.RandomStream())
+		trusteeKeys[j] = suite.Point().Mul(priv, nil)
+		trustees[j] = &DissentProtocol{myID: j, keyPriv: priv, keyPub: trusteeKeys[j], trusteeKeys: trusteeKeys}
+	}
+	clients := make([]*DissentProtocol, nClients)
+	for i := range clients {
+		priv := suite.Scalar().Pick(suite.RandomStream())
+		clients[i] = &DissentProtocol{myID: i, keyPriv: priv, keyPub: suite.Point().Mul(priv, nil), trusteeKeys: trusteeKeys}
+		clients[i].newPseudonym()
+	}
+	return trustees, clients, trusteeKeys
+}
+
+// clientIdentities returns the long-term identities of the clients
+func clientIdentities(clients []*DissentProtocol) []*network.ServerIdentity {
+	identities := make([]*network.ServerIdentity, len(clients))
+	for i, c := range clients {
+		identities[i] = &network.ServerIdentity{Public: c.keyPub}
+	}
+	return identities
+}
+
+// signedPseudonym returns the PSEUDONYM of a client, signed with its long-term key
+func signedPseudonym(t *testing.T, c *DissentProtocol) PSEUDONYM {
+	signed, err := signMessage(&PSEUDONYM{X: c.pseudonymX, Y: c.pseudonymY}, c.keyPriv, shuffleSessionID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *signed.(*PSEUDONYM)
+}
+
+// runShuffle returns the transcript of an honest shuffle by the first nSteps trustees
+func runShuffle(t *testing.T, trustees, clients []*DissentProtocol, nSteps int) *ShuffleTranscript {
+	s := newShuffleState()
+	for i, c := range clients {
+		s.collectPseudonym(i, signedPseudonym(t, c), len(clients))
+	}
+	transcript := s.transcript()
+	for j := 0; j < nSteps; j++ {
+		step, err := trustees[j].doShuffleStep(&transcript)
+		if err != nil {
+			t.Fatal("trustee", j, "could not shuffle:", err)
+		}
+		transcript.Steps = append(transcript.Steps, *step)
+	}
+	return &transcript
+}
+
+func TestShuffle(t *testing.T) {
+	trustees, clients, trusteeKeys := shuffleNodes(4, 3)
+	transcript := runShuffle(t, trustees, clients, len(trustees))
+
+	pseudonymKeys, err := verifyShuffleTranscript(transcript, trusteeKeys, clientIdentities(clients), shuffleSessionID)
+	if err != nil {
+		t.Fatal("honest transcript rejected:", err)
+	}
+	taken := make(map[int]bool)
+	for i, c := range clients {
+		if !c.containsPseudonym(transcript) {
+			t.Error("client", i, "does not find its pseudonym in the transcript")
+		}
+		slot, err := c.findSlot(pseudonymKeys)
+		if err != nil {
+			t.Fatal("client", i, "has no slot:", err)
+		}
+		if taken[slot] {
+			t.Error("slot", slot, "is given twice")
+		}
+		taken[slot] = true
+	}
+}
+
+func TestShuffleTampered(t *testing.T) {
+	suite := config.CryptoSuite
+	randomPoint := func() kyber.Point { return suite.Point().Pick(suite.RandomStream()) }
+	outsider := suite.Scalar().Pick(suite.RandomStream())
+	resign := func(tr *ShuffleTranscript, i int, priv kyber.Scalar, sessionID []byte) {
+		signed, err := signMessage(&tr.Pseudonyms[i], priv, sessionID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Pseudonyms[i] = *signed.(*PSEUDONYM)
+	}
+
+	tests := []struct {
+		name   string
+		steps  int // the number of trustees which shuffled
+		tamper func(tr *ShuffleTranscript)
+		ok     bool
+	}{
+		{"honest", 3, func(tr *ShuffleTranscript) {}, true},
+		{"partial", 1, func(tr *ShuffleTranscript) {}, true},
+		{"replaced pseudonym", 3, func(tr *ShuffleTranscript) { tr.InitialY[0] = randomPoint() }, false},
+		{"missing pseudonym", 3, func(tr *ShuffleTranscript) {
+			tr.Pseudonyms, tr.InitialX, tr.InitialY = tr.Pseudonyms[1:], tr.InitialX[1:], tr.InitialY[1:]
+		}, false},
+		{"missing signed pseudonym", 0, func(tr *ShuffleTranscript) { tr.Pseudonyms = tr.Pseudonyms[1:] }, false},
+		{"replaced signed pseudonym", 0, func(tr *ShuffleTranscript) {
+			tr.Pseudonyms[1].Y = randomPoint()
+			tr.InitialY[1] = tr.Pseudonyms[1].Y
+		}, false},
+		{"pseudonym signed by another key", 0, func(tr *ShuffleTranscript) {
+			tr.Pseudonyms[1].Y = randomPoint()
+			tr.InitialY[1] = tr.Pseudonyms[1].Y
+			resign(tr, 1, outsider, shuffleSessionID)
+		}, false},
+		{"pseudonym of another session", 0, func(tr *ShuffleTranscript) {
+			tr.Pseudonyms[2].SessionID = []byte("another session")
+		}, false},
+		{"pseudonyms swapped", 0, func(tr *ShuffleTranscript) {
+			tr.Pseudonyms[0], tr.Pseudonyms[1] = tr.Pseudonyms[1], tr.Pseudonyms[0]
+			tr.InitialX[0], tr.InitialX[1] = tr.InitialX[1], tr.InitialX[0]
+			tr.InitialY[0], tr.InitialY[1] = tr.InitialY[1], tr.InitialY[0]
+		}, false},
+		{"replaced shuffled ciphertext", 3, func(tr *ShuffleTranscript) { tr.Steps[1].XBar[0] = randomPoint() }, false},
+		{"proof of another step", 3, func(tr *ShuffleTranscript) { tr.Steps[1].ShuffleProof = tr.Steps[0].ShuffleProof }, false},
+		{"swapped decryptions", 3, func(tr *ShuffleTranscript) {
+			y := tr.Steps[2].YDecrypted
+			y[0], y[1] = y[1], y[0]
+		}, false},
+		{"replaced decryption", 3, func(tr *ShuffleTranscript) { tr.Steps[0].YDecrypted[2] = randomPoint() }, false},
+		{"missing decryption proof", 3, func(tr *ShuffleTranscript) { tr.Steps[2].DecryptionProofs[3] = nil }, false},
+		{"dropped element", 3, func(tr *ShuffleTranscript) {
+			s := &tr.Steps[1]
+			s.XBar, s.YBar, s.YDecrypted, s.DecryptionProofs = s.XBar[1:], s.YBar[1:], s.YDecrypted[1:], s.DecryptionProofs[1:]
+		}, false},
+		{"steps reordered", 2, func(tr *ShuffleTranscript) { tr.Steps[0], tr.Steps[1] = tr.Steps[1], tr.Steps[0] }, false},
+		{"extra step", 3, func(tr *ShuffleTranscript) { tr.Steps = append(tr.Steps, tr.Steps[2]) }, false},
+	}
+	for _, test := range tests {
+		trustees, clients, trusteeKeys := shuffleNodes(4, 3)
+		transcript := runShuffle(t, trustees, clients, test.steps)
+		test.tamper(transcript)
+		_, err := verifyShuffleTranscript(transcript, trusteeKeys, clientIdentities(clients), shuffleSessionID)
+		if test.ok && err != nil {
+			t.Errorf("%s: rejected: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
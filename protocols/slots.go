@@ -0,0 +1,213 @@
+package protocols
+
+// This file contains the open/closed slots (RelayUseOpenClosedSlots).
+//
+// Most clients are idle most of the time, so instead of carrying every slot in every round, Client0
+// runs a short reservation round whose cell holds one bit per slot. A client sets the bit of its slot
+// if it has something to send. The next round only carries the open slots; closed slots cost no
+// bandwidth. When every slot was closed, Client0 waits OpenClosedSlotsMinDelayBetweenRequests
+// milliseconds before the next reservation round.
+//
+// The pads are always computed over the full cell, and a round only uses a part of it: the first
+// bytes in a reservation round, and the bytes of the open slots in a data round. The trustees can
+// thus compute their ciphertexts ahead of time, without knowing which slots will be open; Client0
+// keeps the used part. Bit positions in the accusations and the blame refer to the full cell.
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"gopkg.in/dedis/onet.v2/log"
+)
+
+// roundLayout tells which part of the full cell a round uses
+type roundLayout struct {
+	reservation bool
+	openSlots   []int // the slots carried by a data round, in increasing order
+}
+
+// reservationState holds, on Client0, the result of the last reservation round
+type reservationState struct {
+	pending    bool      // a reservation round is in flight
+	openSlots  []int     // the slots opened by the last reservation round, until a data round carries them
+	notBefore  time.Time // all slots were closed, the next reservation round waits until then
+	timerArmed bool
+}
+
+// allSlotsLayout returns the layout of a data round carrying every slot
+func (p *DissentProtocol) allSlotsLayout() roundLayout {
+	open := make([]int, p.nClients)
+	for s := range open {
+		open[s] = s
+	}
+	return roundLayout{openSlots: open}
+}
+
+// layoutFromMessage checks the layout announced in a NEW_ROUND
+func (p *DissentProtocol) layoutFromMessage(msg *NEW_ROUND) (roundLayout, error) {
+	if msg.Reservation {
+		if len(msg.OpenSlots) != 0 {
+			return roundLayout{}, errors.New("a reservation round cannot have open slots")
+		}
+		return roundLayout{reservation: true}, nil
+	}
+	for k, s := range msg.OpenSlots {
+		if s < 0 || s >= p.nClients || (k > 0 && s <= msg.OpenSlots[k-1]) {
+			return roundLayout{}, errors.New("invalid list of open slots")
+		}
+	}
+	return roundLayout{openSlots: msg.OpenSlots}, nil
+}
+
+// layoutSize returns the size of the cell of a round with the given layout
+func (p *DissentProtocol) layoutSize(l roundLayout) int {
+	if l.reservation {
+		return (p.nClients + 7) / 8
+	}
+	return len(l.openSlots) * p.config.Toml.PayloadSize
+}
+
+// project returns the part of a full cell used by a round with the given layout
+func (p *DissentProtocol) project(l roundLayout, full []byte) []byte {
+	if l.reservation {
+		return full[:p.layoutSize(l)]
+	}
+	payloadSize := p.config.Toml.PayloadSize
+	cell := make([]byte, 0, p.layoutSize(l))
+	for _, s := range l.openSlots {
+		cell = append(cell, full[s*payloadSize:(s+1)*payloadSize]...)
+	}
+	return cell
+}
+
+// compactBit converts a bit position in the full cell to a position in the cell of the round, or -1
+// if the round does not carry this bit
+func (p *DissentProtocol) compactBit(l roundLayout, bitPos int) int {
+	if l.reservation {
+		if bitPos < p.layoutSize(l)*8 {
+			return bitPos
+		}
+		return -1
+	}
+	slotBits := p.config.Toml.PayloadSize * 8
+	if k := l.slotIndex(bitPos / slotBits); k >= 0 {
+		return k*slotBits + bitPos%slotBits
+	}
+	return -1
+}
+
+// sameLayout returns true if two layouts carry the same part of the cell
+func sameLayout(a, b roundLayout) bool {
+	if a.reservation != b.reservation || len(a.openSlots) != len(b.openSlots) {
+		return false
+	}
+	for k := range a.openSlots {
+		if a.openSlots[k] != b.openSlots[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// slotIndex returns the position of a slot in the cell of a data round, or -1 if the slot is closed
+func (l roundLayout) slotIndex(slot int) int {
+	for k, s := range l.openSlots {
+		if s == slot {
+			return k
+		}
+	}
+	return -1
+}
+
+// expandSlots returns one entry per slot of the full cell, nil for the closed slots
+func (p *DissentProtocol) expandSlots(l roundLayout, slots [][]byte) [][]byte {
+	full := make([][]byte, p.nClients)
+	for k, s := range l.openSlots {
+		if k < len(slots) {
+			full[s] = slots[k]
+		}
+	}
+	return full
+}
+
+// flipBit flips the bit at position pos in buf (most significant bit first); the cells are XORed
+// together, so this is how a bit is set in the output whatever the pad bit is
+func flipBit(buf []byte, pos int) {
+	buf[pos/8] ^= 1 << uint(7-pos%8)
+}
+
+// reservationCipher returns our ciphertext of a reservation round: our pads, with the bit of our slot
+// flipped if we have something to send
+func (p *DissentProtocol) reservationCipher(pads []byte, open bool) []byte {
+	cipher := p.project(roundLayout{reservation: true}, pads)
+	if open {
+		flipBit(cipher, p.mySlot())
+	}
+	return cipher
+}
+
+// hasPendingData returns true if this client has something to send in its slot
+func (p *DissentProtocol) hasPendingData() bool {
+	return p.pendingAccusation != nil || p.upstreamQueue.len() > 0 || p.fragmentQueue.len() > 0 || p.replayQueue.len() > 0 || p.probeQueue.len() > 0
+}
+
+// nextLayout is called on Client0 to choose the layout of the next round; it returns false if no
+// round can be started yet
+func (p *DissentProtocol) nextLayout() (roundLayout, bool) {
+	if !p.config.Toml.RelayUseOpenClosedSlots {
+		return p.allSlotsLayout(), true
+	}
+	s := &p.reservations
+	if s.pending {
+		return roundLayout{}, false
+	}
+	if s.openSlots != nil {
+		l := roundLayout{openSlots: s.openSlots}
+		s.openSlots = nil
+		return l, true
+	}
+
+	if wait := time.Until(s.notBefore); wait > 0 {
+		if !s.timerArmed {
+			s.timerArmed = true
+			time.AfterFunc(wait, func() {
+				p.roundsLock.Lock()
+				defer p.roundsLock.Unlock()
+				s.timerArmed = false
+				p.fillWindow()
+			})
+		}
+		return roundLayout{}, false
+	}
+	s.pending = true
+	return roundLayout{reservation: true}, true
+}
+
+// reservationDone is called on Client0 with the cleartext of a reservation round
+func (p *DissentProtocol) reservationDone(roundID int, cleartext []byte) {
+	s := &p.reservations
+	s.pending = false
+
+	open := make([]int, 0)
+	for slot := 0; slot < p.nClients; slot++ {
+		if getBit(cleartext, slot) == 1 {
+			open = append(open, slot)
+		}
+	}
+	log.Lvl3("Client0 : reservation round", roundID, "opened slots", open)
+
+	if len(open) == 0 {
+		s.notBefore = time.Now().Add(time.Duration(p.config.Toml.OpenClosedSlotsMinDelayBetweenRequests) * time.Millisecond)
+		return
+	}
+	s.openSlots = open
+}
+
+// checkLayoutLength verifies the length of a client ciphertext against the layout of its round
+func (p *DissentProtocol) checkLayoutLength(l roundLayout, cipher []byte) error {
+	if len(cipher) != p.layoutSize(l) {
+		return errors.New("ciphertext has length " + strconv.Itoa(len(cipher)) + ", expected " + strconv.Itoa(p.layoutSize(l)))
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package protocols
+
+import (
+	"bytes"
+	"testing"
+)
+
+// layoutProtocol returns a protocol with only what the layouts need
+func layoutProtocol(nClients, payloadSize int) *DissentProtocol {
+	return &DissentProtocol{
+		nClients: nClients,
+		config:   DissentProtocolConfig{Toml: &DissentTomlConfig{PayloadSize: payloadSize}},
+	}
+}
+
+func TestLayoutFromMessage(t *testing.T) {
+	p := layoutProtocol(4, 2)
+	tests := []struct {
+		name string
+		msg  *NEW_ROUND
+		ok   bool
+		size int
+	}{
+		{"reservation", &NEW_ROUND{Reservation: true}, true, 1},
+		{"reservation with slots", &NEW_ROUND{Reservation: true, OpenSlots: []int{0}}, false, 0},
+		{"no open slot", &NEW_ROUND{}, true, 0},
+		{"some open slots", &NEW_ROUND{OpenSlots: []int{1, 3}}, true, 4},
+		{"all slots", &NEW_ROUND{OpenSlots: []int{0, 1, 2, 3}}, true, 8},
+		{"unsorted", &NEW_ROUND{OpenSlots: []int{3, 1}}, false, 0},
+		{"duplicate", &NEW_ROUND{OpenSlots: []int{1, 1}}, false, 0},
+		{"negative", &NEW_ROUND{OpenSlots: []int{-1, 2}}, false, 0},
+		{"out of range", &NEW_ROUND{OpenSlots: []int{2, 4}}, false, 0},
+	}
+	for _, test := range tests {
+		l, err := p.layoutFromMessage(test.msg)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if test.ok && p.layoutSize(l) != test.size {
+			t.Errorf("%s: size %d, expected %d", test.name, p.layoutSize(l), test.size)
+		}
+	}
+}
+
+func TestProject(t *testing.T) {
+	p := layoutProtocol(4, 2)
+	full := []byte{0, 1, 10, 11, 20, 21, 30, 31}
+	tests := []struct {
+		name   string
+		layout roundLayout
+		cell   []byte
+	}{
+		{"reservation", roundLayout{reservation: true}, []byte{0}},
+		{"no open slot", roundLayout{}, []byte{}},
+		{"first slot", roundLayout{openSlots: []int{0}}, []byte{0, 1}},
+		{"some open slots", roundLayout{openSlots: []int{1, 3}}, []byte{10, 11, 30, 31}},
+		{"all slots", p.allSlotsLayout(), full},
+	}
+	for _, test := range tests {
+		if cell := p.project(test.layout, full); !bytes.Equal(cell, test.cell) {
+			t.Errorf("%s: got %v, expected %v", test.name, cell, test.cell)
+		}
+	}
+
+	//a reservation round of more than 8 clients uses several bytes
+	p = layoutProtocol(9, 2)
+	full = make([]byte, 18)
+	full[1] = 0xff
+	if cell := p.project(roundLayout{reservation: true}, full); !bytes.Equal(cell, []byte{0, 0xff}) {
+		t.Errorf("reservation of 9 clients: got %v", cell)
+	}
+}
+
+func TestCompactBit(t *testing.T) {
+	p := layoutProtocol(4, 2) //16 bits per slot
+	tests := []struct {
+		name    string
+		layout  roundLayout
+		bitPos  int
+		compact int
+	}{
+		{"reservation, first bit", roundLayout{reservation: true}, 0, 0},
+		{"reservation, last bit", roundLayout{reservation: true}, 7, 7},
+		{"reservation, outside the cell", roundLayout{reservation: true}, 8, -1},
+		{"all slots", p.allSlotsLayout(), 37, 37},
+		{"open slot at its place", roundLayout{openSlots: []int{0, 2}}, 5, 5},
+		{"open slot moved", roundLayout{openSlots: []int{1, 3}}, 3*16 + 4, 16 + 4},
+		{"first bit of a moved slot", roundLayout{openSlots: []int{2}}, 2 * 16, 0},
+		{"last bit of a moved slot", roundLayout{openSlots: []int{2}}, 3*16 - 1, 15},
+		{"closed slot", roundLayout{openSlots: []int{1, 3}}, 2*16 + 4, -1},
+		{"no open slot", roundLayout{}, 0, -1},
+	}
+	for _, test := range tests {
+		if compact := p.compactBit(test.layout, test.bitPos); compact != test.compact {
+			t.Errorf("%s: bit %d compacts to %d, expected %d", test.name, test.bitPos, compact, test.compact)
+		}
+	}
+
+	//compactBit and project agree: the compacted bit is the same bit of the projected cell
+	full := make([]byte, 8)
+	for _, test := range tests {
+		if test.compact < 0 {
+			continue
+		}
+		for k := range full {
+			full[k] = 0
+		}
+		flipBit(full, test.bitPos)
+		cell := p.project(test.layout, full)
+		if cell[test.compact/8]&(1<<uint(7-test.compact%8)) == 0 {
+			t.Errorf("%s: bit %d is not at %d in the projected cell", test.name, test.bitPos, test.compact)
+		}
+	}
+}
+
+func TestReservation(t *testing.T) {
+	nClients := 10 //two bytes of reservation
+	pads := []struct {
+		name string
+		pad  func(client, k int) byte
+	}{
+		{"zero pads", func(client, k int) byte { return 0 }},
+		{"pads of ones", func(client, k int) byte { return 0xff }},
+		{"mixed pads", func(client, k int) byte { return byte(37*client + 101*k + 13) }},
+	}
+	opened := []struct {
+		name  string
+		slots []int
+	}{
+		{"no slot", nil},
+		{"one slot", []int{3}},
+		{"first and last slots", []int{0, 9}},
+		{"every slot", []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	}
+	for _, pad := range pads {
+		for _, test := range opened {
+			open := make(map[int]bool)
+			for _, s := range test.slots {
+				open[s] = true
+			}
+
+			//the trustees' ciphertext cancels the clients' pads
+			output := make([]byte, 2)
+			for client := 0; client < nClients; client++ {
+				full := make([]byte, nClients)
+				for k := range full {
+					full[k] = pad.pad(client, k)
+				}
+				p := layoutProtocol(nClients, 1)
+				p.slot = (client + 4) % nClients
+				xorInto(output, p.project(roundLayout{reservation: true}, full))
+				xorInto(output, p.reservationCipher(full, open[p.slot]))
+			}
+
+			for s := 0; s < nClients; s++ {
+				if (getBit(output, s) == 1) != open[s] {
+					t.Errorf("%s, %s: slot %d is open %v, expected %v", pad.name, test.name, s, getBit(output, s) == 1, open[s])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package protocols
+
+// This file contains the state machine of the protocol.
+//
+// Every node goes through the same phases : it waits for the parameters, exchanges keys, takes part
+// in the shuffle, then runs rounds. During a blame, the rounds go on until the verdict. Each handler
+// checks that its message is expected in the current state, and a message arriving in the wrong
+// state is a protocol violation.
+
+import "gopkg.in/dedis/onet.v2/log"
+
+// ProtocolState is the phase a node is in
+type ProtocolState int
+
+const (
+	StateWaitingParams ProtocolState = iota
+	StateKeyExchange
+	StateShuffle
+	StateRounds
+	StateBlame
+	StateStopped
+)
+
+// String returns a printable name for a state
+func (s ProtocolState) String() string {
+	switch s {
+	case StateWaitingParams:
+		return "WaitingParams"
+	case StateKeyExchange:
+		return "KeyExchange"
+	case StateShuffle:
+		return "Shuffle"
+	case StateRounds:
+		return "Rounds"
+	case StateBlame:
+		return "Blame"
+	case StateStopped:
+		return "Stopped"
+	}
+	return "Unknown"
+}
+
+// legalTransitions lists the states reachable from each state
+var legalTransitions = map[ProtocolState][]ProtocolState{
+	StateWaitingParams: {StateKeyExchange, StateStopped},
+	StateKeyExchange:   {StateShuffle, StateStopped},
+	StateShuffle:       {StateRounds, StateStopped},
+	StateRounds:        {StateBlame, StateStopped},
+	StateBlame:         {StateBlame, StateRounds, StateStopped},
+}
+
+// UnexpectedMessageError is returned by a handler when its message is not expected in the current state
+type UnexpectedMessageError struct {
+	Message string
+	State   ProtocolState
+}
+
+func (e *UnexpectedMessageError) Error() string {
+	return "unexpected " + e.Message + " in state " + e.State.String()
+}
+
+// IllegalTransitionError is returned when a node tries to go to a state not reachable from the current one
+type IllegalTransitionError struct {
+	From ProtocolState
+	To   ProtocolState
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return "illegal transition from state " + e.From.String() + " to state " + e.To.String()
+}
+
+// State returns the current phase of this node
+func (p *DissentProtocol) State() ProtocolState {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	return p.state
+}
+
+// expectState checks that a message is expected in the current state
+func (p *DissentProtocol) expectState(message string, states ...ProtocolState) error {
+	current := p.State()
+	for _, s := range states {
+		if current == s {
+			return nil
+		}
+	}
+	err := &UnexpectedMessageError{Message: message, State: current}
+	log.Error("Protocol violation:", err)
+	return err
+}
+
+// setState moves to the given state, if it is reachable from the current one
+func (p *DissentProtocol) setState(next ProtocolState) error {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+
+	for _, s := range legalTransitions[p.state] {
+		if s == next {
+			log.Lvl2("State", p.state, "->", next)
+			p.state = next
+			return nil
+		}
+	}
+	err := &IllegalTransitionError{From: p.state, To: next}
+	log.Error("Protocol violation:", err)
+	return err
+}
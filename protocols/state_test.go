@@ -0,0 +1,72 @@
+package protocols
+
+import "testing"
+
+func TestSetState(t *testing.T) {
+	tests := []struct {
+		from ProtocolState
+		to   ProtocolState
+		ok   bool
+	}{
+		{StateWaitingParams, StateKeyExchange, true},
+		{StateKeyExchange, StateShuffle, true},
+		{StateShuffle, StateRounds, true},
+		{StateRounds, StateBlame, true},
+		{StateBlame, StateBlame, true},
+		{StateBlame, StateRounds, true},
+		{StateWaitingParams, StateStopped, true},
+		{StateRounds, StateStopped, true},
+		{StateBlame, StateStopped, true},
+		{StateWaitingParams, StateRounds, false},
+		{StateKeyExchange, StateKeyExchange, false},
+		{StateKeyExchange, StateRounds, false},
+		{StateShuffle, StateKeyExchange, false},
+		{StateRounds, StateShuffle, false},
+		{StateRounds, StateRounds, false},
+		{StateShuffle, StateBlame, false},
+		{StateStopped, StateRounds, false},
+		{StateStopped, StateStopped, false},
+	}
+	for _, test := range tests {
+		p := &DissentProtocol{state: test.from}
+		err := p.setState(test.to)
+		if (err == nil) != test.ok {
+			t.Errorf("%v -> %v: got error %v", test.from, test.to, err)
+		}
+		expected := test.from
+		if test.ok {
+			expected = test.to
+		}
+		if p.State() != expected {
+			t.Errorf("%v -> %v: in state %v, expected %v", test.from, test.to, p.State(), expected)
+		}
+		if _, illegal := err.(*IllegalTransitionError); err != nil && !illegal {
+			t.Errorf("%v -> %v: unexpected error type %T", test.from, test.to, err)
+		}
+	}
+}
+
+func TestExpectState(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    ProtocolState
+		expected []ProtocolState
+		ok       bool
+	}{
+		{"expected state", StateRounds, []ProtocolState{StateRounds}, true},
+		{"one of the expected states", StateBlame, []ProtocolState{StateRounds, StateBlame}, true},
+		{"unexpected state", StateShuffle, []ProtocolState{StateRounds, StateBlame}, false},
+		{"stopped", StateStopped, []ProtocolState{StateRounds}, false},
+	}
+	for _, test := range tests {
+		p := &DissentProtocol{state: test.state}
+		err := p.expectState("NEW_ROUND", test.expected...)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if e, ok := err.(*UnexpectedMessageError); err != nil && (!ok || e.Message != "NEW_ROUND" || e.State != test.state) {
+			t.Errorf("%s: unexpected error %#v", test.name, err)
+		}
+	}
+}
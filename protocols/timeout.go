@@ -0,0 +1,169 @@
+package protocols
+
+// This file contains the round timeouts of Client0.
+//
+// If the oldest round in flight is not complete after RelayRoundTimeOut milliseconds, Client0
+// continues without the late clients when it safely can: they are excluded, and the trustees
+// recompute their ciphertexts without them. Otherwise, Client0 waits for another timeout. After
+// RelayMaxNumberOfConsecutiveFailedRounds timeouts in a row, the late nodes are removed and the
+// service restarts the protocol.
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"gopkg.in/dedis/kyber.v2"
+	"gopkg.in/dedis/onet.v2/log"
+)
+
+// armRoundTimeout starts the timeout of the given round, if RelayRoundTimeOut is set
+func (p *DissentProtocol) armRoundTimeout(roundID int) {
+	if p.config.Toml.RelayRoundTimeOut <= 0 {
+		return
+	}
+	time.AfterFunc(time.Duration(p.config.Toml.RelayRoundTimeOut)*time.Millisecond, func() {
+		p.roundTimedOut(roundID)
+	})
+}
+
+// roundTimedOut is called on Client0 when the timeout of a round expires
+func (p *DissentProtocol) roundTimedOut(roundID int) {
+	p.roundsLock.Lock()
+	defer p.roundsLock.Unlock()
+
+	//the round was output in time
+	if p.HasStopped || roundID != p.nextOutput {
+		return
+	}
+	//the round was not started yet, e.g. Client0 waits before a reservation round
+	if roundID >= p.nextRound {
+		p.armRoundTimeout(roundID)
+		return
+	}
+
+	lateClients, lateTrustees := p.lateNodes(p.rounds[roundID])
+	p.failedRounds++
+	log.Error("Client0 : round", roundID, "timed out (", p.failedRounds, "consecutive failures ), late clients :", lateClients, ", late trustees :", lateTrustees)
+
+	maxFailures := p.config.Toml.RelayMaxNumberOfConsecutiveFailedRounds
+	if maxFailures < 1 {
+		maxFailures = 1
+	}
+	if p.failedRounds >= maxFailures {
+		log.Error("Client0 : too many consecutive failed rounds, resynchronizing.")
+		p.notifyTimeout(lateClients, lateTrustees, true)
+		return
+	}
+
+	if len(lateClients) > 0 && p.canContinueWithout(lateClients, lateTrustees) {
+		log.Lvl1("Client0 : continuing without clients", lateClients)
+		p.excludeClients(lateClients, roundID)
+		p.notifyTimeout(lateClients, nil, false)
+	}
+	p.armRoundTimeout(roundID)
+}
+
+// lateNodes returns the clients and trustees which did not send their ciphertext for the given round
+func (p *DissentProtocol) lateNodes(round *roundState) ([]int, []int) {
+	lateClients := make([]int, 0)
+	lateTrustees := make([]int, 0)
+	for i := 0; i < p.nClients; i++ {
+		if _, ok := round.clientCiphers[i]; !ok && !p.excludedClients[i] {
+			lateClients = append(lateClients, i)
+		}
+	}
+	for j := 0; j < p.nTrustees; j++ {
+		if _, ok := round.trusteeCiphers[j]; !ok {
+			lateTrustees = append(lateTrustees, j)
+		}
+	}
+	return lateClients, lateTrustees
+}
+
+// canContinueWithout returns true iff the session can go on without the late clients. The pads of
+// every trustee are needed, Client0 is the hub, and the blame needs the pad bits of every client.
+func (p *DissentProtocol) canContinueWithout(lateClients, lateTrustees []int) bool {
+	if len(lateTrustees) > 0 {
+		log.Lvl2("Client0 : some trustees are late, cannot continue without them")
+		return false
+	}
+	if p.config.Toml.DisruptionProtectionEnabled {
+		log.Lvl2("Client0 : disruption protection is enabled, cannot continue without some clients")
+		return false
+	}
+	for _, i := range lateClients {
+		if i == p.myID {
+			return false
+		}
+	}
+	return true
+}
+
+// excludeClients removes the late clients from the session, from the given round on. The ciphertexts
+// of the trustees include the pads of these clients, so they are dropped and recomputed.
+func (p *DissentProtocol) excludeClients(lateClients []int, fromRound int) {
+	for _, i := range lateClients {
+		p.excludedClients[i] = true
+	}
+
+	for roundID, round := range p.rounds {
+		if roundID >= p.nextRound {
+			delete(p.rounds, roundID)
+			continue
+		}
+		round.trusteeCiphers = make(map[int][]byte)
+		round.trusteeSigned = make(map[int]*TRUSTEE_CIPHER)
+		round.trusteeSigmas = make(map[int][]kyber.Scalar)
+	}
+	p.padCache = newTrusteeCache()
+
+	excluded := make([]int, 0, len(p.excludedClients))
+	for i := range p.excludedClients {
+		excluded = append(excluded, i)
+	}
+	sort.Ints(excluded)
+
+	message := &CLIENTS_EXCLUDED{FromRound: fromRound, Clients: excluded}
+	for j := range p.ms.trustees {
+		p.ms.SendToTrustee(j, message)
+	}
+}
+
+// notifyTimeout passes the late nodes to the service, which removes them and restarts the protocol if needed
+func (p *DissentProtocol) notifyTimeout(lateClients, lateTrustees []int, resync bool) {
+	if p.toHandler == nil {
+		if resync {
+			p.abort(errors.New("too many consecutive failed rounds"))
+		}
+		return
+	}
+
+	clients := make([]string, 0, len(lateClients))
+	for _, i := range lateClients {
+		if si := p.ms.serverIdentity(Client, i); si != nil {
+			clients = append(clients, si.Public.String())
+		}
+	}
+	trustees := make([]string, 0, len(lateTrustees))
+	for _, j := range lateTrustees {
+		if si := p.ms.serverIdentity(Trustee, j); si != nil {
+			trustees = append(trustees, si.Public.String())
+		}
+	}
+	go p.toHandler(clients, trustees, resync)
+}
+
+// nActiveClients returns the number of clients which were not excluded
+func (p *DissentProtocol) nActiveClients() int {
+	return p.nClients - len(p.excludedClients)
+}
+
+// sendToActiveClients sends a message to every client which was not excluded
+func (p *DissentProtocol) sendToActiveClients(msg interface{}) {
+	for i := range p.ms.clients {
+		if !p.excludedClients[i] {
+			p.ms.SendToClient(i, msg)
+		}
+	}
+}
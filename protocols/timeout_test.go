@@ -0,0 +1,145 @@
+package protocols
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// timeoutProtocol returns a Client0 with a round in flight, in which the given nodes sent their ciphertexts
+func timeoutProtocol(toml *DissentTomlConfig, clients, trustees []int) *DissentProtocol {
+	toml.PayloadSize = 4
+	p := &DissentProtocol{
+		role:            Client0,
+		nClients:        4,
+		nTrustees:       2,
+		config:          DissentProtocolConfig{Toml: toml},
+		rounds:          make(map[int]*roundState),
+		excludedClients: make(map[int]bool),
+		nextRound:       1,
+	}
+	round := newRoundState(nil)
+	for _, i := range clients {
+		round.clientCiphers[i] = []byte{}
+	}
+	for _, j := range trustees {
+		round.trusteeCiphers[j] = []byte{}
+	}
+	p.rounds[0] = round
+	return p
+}
+
+func TestLateNodes(t *testing.T) {
+	tests := []struct {
+		name         string
+		clients      []int
+		trustees     []int
+		excluded     []int
+		lateClients  []int
+		lateTrustees []int
+	}{
+		{"nobody late", []int{0, 1, 2, 3}, []int{0, 1}, nil, []int{}, []int{}},
+		{"late clients", []int{0, 2}, []int{0, 1}, nil, []int{1, 3}, []int{}},
+		{"late trustee", []int{0, 1, 2, 3}, []int{1}, nil, []int{}, []int{0}},
+		{"excluded clients are not late", []int{0, 2}, []int{0, 1}, []int{3}, []int{1}, []int{}},
+	}
+	for _, test := range tests {
+		p := timeoutProtocol(&DissentTomlConfig{}, test.clients, test.trustees)
+		for _, i := range test.excluded {
+			p.excludedClients[i] = true
+		}
+		lateClients, lateTrustees := p.lateNodes(p.rounds[0])
+		if !reflect.DeepEqual(lateClients, test.lateClients) || !reflect.DeepEqual(lateTrustees, test.lateTrustees) {
+			t.Errorf("%s: late clients %v and trustees %v, expected %v and %v", test.name, lateClients, lateTrustees, test.lateClients, test.lateTrustees)
+		}
+	}
+}
+
+func TestCanContinueWithout(t *testing.T) {
+	tests := []struct {
+		name         string
+		disruption   bool
+		lateClients  []int
+		lateTrustees []int
+		ok           bool
+	}{
+		{"late clients", false, []int{1, 3}, nil, true},
+		{"late trustee", false, []int{1}, []int{0}, false},
+		{"Client0 late", false, []int{0}, nil, false},
+		{"disruption protection", true, []int{1}, nil, false},
+	}
+	for _, test := range tests {
+		p := timeoutProtocol(&DissentTomlConfig{DisruptionProtectionEnabled: test.disruption}, nil, nil)
+		if ok := p.canContinueWithout(test.lateClients, test.lateTrustees); ok != test.ok {
+			t.Errorf("%s: continue %v, expected %v", test.name, ok, test.ok)
+		}
+	}
+}
+
+func TestExcludeClients(t *testing.T) {
+	p := timeoutProtocol(&DissentTomlConfig{}, []int{0, 1, 2}, []int{0, 1})
+	p.nextRound = 2
+	p.rounds[1] = newRoundState(nil)
+	p.rounds[1].trusteeCiphers[0] = []byte{}
+	p.rounds[5] = newRoundState(nil) //trustee ciphertexts sent ahead of time
+	p.padCache = newTrusteeCache()
+
+	p.excludeClients([]int{3}, 0)
+
+	if !p.excludedClients[3] || p.nActiveClients() != 3 {
+		t.Errorf("excluded %v, %d active clients", p.excludedClients, p.nActiveClients())
+	}
+	for roundID, round := range p.rounds {
+		if len(round.trusteeCiphers) != 0 {
+			t.Errorf("the trustee ciphertexts of round %d, which include the excluded pads, are kept", roundID)
+		}
+	}
+	if _, ok := p.rounds[5]; ok {
+		t.Error("a future round is kept")
+	}
+	if len(p.rounds[0].clientCiphers) != 3 {
+		t.Error("the client ciphertexts are dropped")
+	}
+}
+
+func TestRoundTimedOut(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxFailures int
+		failed      int // the rounds which already failed
+		clients     []int
+		trustees    []int
+		roundID     int
+		notified    bool
+		resync      bool
+		excluded    int
+	}{
+		{"round already output", 3, 0, []int{0}, []int{0, 1}, 1, false, false, 0},
+		{"late clients excluded", 3, 0, []int{0, 1}, []int{0, 1}, 0, true, false, 2},
+		{"late trustee, waiting", 3, 0, []int{0, 1, 2, 3}, []int{0}, 0, false, false, 0},
+		{"too many failures", 3, 2, []int{0, 1}, []int{0, 1}, 0, true, true, 0},
+		{"at most one failure", 0, 0, []int{0, 1}, []int{0, 1}, 0, true, true, 0},
+	}
+	for _, test := range tests {
+		p := timeoutProtocol(&DissentTomlConfig{RelayMaxNumberOfConsecutiveFailedRounds: test.maxFailures}, test.clients, test.trustees)
+		p.failedRounds = test.failed
+		resync := make(chan bool, 1)
+		p.toHandler = func(lateClients, lateTrustees []string, r bool) { resync <- r }
+
+		p.roundTimedOut(test.roundID)
+
+		select {
+		case r := <-resync:
+			if !test.notified || r != test.resync {
+				t.Errorf("%s: notified with resync %v", test.name, r)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if test.notified {
+				t.Errorf("%s: the service was not notified", test.name)
+			}
+		}
+		if len(p.excludedClients) != test.excluded {
+			t.Errorf("%s: %d clients excluded, expected %d", test.name, len(p.excludedClients), test.excluded)
+		}
+	}
+}
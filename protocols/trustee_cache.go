@@ -0,0 +1,203 @@
+package protocols
+
+// This file contains the precomputation of the trustees' ciphertexts, and the flow control between
+// the trustees and Client0.
+//
+// Once the shuffle is verified, each trustee computes its ciphertexts for the next rounds ahead of
+// time and streams them to Client0, which buffers them until the rounds are decoded. When Client0
+// holds RelayTrusteeCacheHighBound ciphertexts of a trustee, it tells that trustee to slow down;
+// the trustee then sleeps TrusteeSleepTimeBetweenMessages between its ciphertexts until Client0's
+// cache drops to RelayTrusteeCacheLowBound and it is told to resume. A slowed down trustee sends at
+// most RelayTrusteeCacheHighBound more ciphertexts, then waits, so Client0 never holds more than
+// twice the high bound; a high bound which is not positive is replaced by a default one.
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/onet.v2/log"
+)
+
+// trusteePausePollTime is the minimum time a paused trustee waits before checking if it can resume
+const trusteePausePollTime = 10 * time.Millisecond
+
+// defaultTrusteeCacheHighBound replaces a RelayTrusteeCacheHighBound which is not positive
+const defaultTrusteeCacheHighBound = 15
+
+// trusteeFlow holds whether a trustee was asked to slow down, and the clients it must leave out
+// of its pads; it is shared with the sending goroutine
+type trusteeFlow struct {
+	sync.Mutex
+	slowedDown  bool
+	sentSlowed  int // the ciphertexts sent since we were slowed down
+	excluded    map[int]bool
+	restart     bool // set when the ciphertexts must be recomputed from round restartFrom on
+	restartFrom int
+}
+
+func (f *trusteeFlow) setSlowedDown(slowedDown bool) {
+	f.Lock()
+	defer f.Unlock()
+	f.slowedDown = slowedDown
+	f.sentSlowed = 0
+}
+
+func (f *trusteeFlow) isSlowedDown() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.slowedDown
+}
+
+// mustWait returns true if we are slowed down and already sent limit ciphertexts since
+func (f *trusteeFlow) mustWait(limit int) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.slowedDown && f.sentSlowed >= limit
+}
+
+// sent counts a ciphertext sent to Client0
+func (f *trusteeFlow) sent() {
+	f.Lock()
+	defer f.Unlock()
+	if f.slowedDown {
+		f.sentSlowed++
+	}
+}
+
+// exclude leaves the given clients out of the pads from round fromRound on
+func (f *trusteeFlow) exclude(clients []int, fromRound int) {
+	f.Lock()
+	defer f.Unlock()
+	f.excluded = make(map[int]bool)
+	for _, i := range clients {
+		f.excluded[i] = true
+	}
+	f.restart = true
+	f.restartFrom = fromRound
+	f.slowedDown = false
+	f.sentSlowed = 0
+}
+
+// next returns the round whose ciphertext should be sent after roundID-1, and the clients to leave out
+func (f *trusteeFlow) next(roundID int) (int, map[int]bool) {
+	f.Lock()
+	defer f.Unlock()
+	if f.restart {
+		roundID = f.restartFrom
+		f.restart = false
+	}
+	return roundID, f.excluded
+}
+
+// trusteeCacheBounds returns the low and high bounds of Client0's cache of each trustee; the high bound
+// is always positive, and the low bound below it
+func trusteeCacheBounds(toml *DissentTomlConfig) (int, int) {
+	high := toml.RelayTrusteeCacheHighBound
+	if high <= 0 {
+		high = defaultTrusteeCacheHighBound
+	}
+	low := toml.RelayTrusteeCacheLowBound
+	if low < 0 || low >= high {
+		low = high / 2
+	}
+	return low, high
+}
+
+// trusteeCache counts, on Client0, the buffered ciphertexts of each trustee
+type trusteeCache struct {
+	cached     map[int]int
+	slowedDown map[int]bool
+}
+
+func newTrusteeCache() *trusteeCache {
+	return &trusteeCache{
+		cached:     make(map[int]int),
+		slowedDown: make(map[int]bool),
+	}
+}
+
+// trusteeCipher computes the ciphertext of this trustee for the given round, without the pads of the excluded clients
+func (p *DissentProtocol) trusteeCipher(roundID int, excluded map[int]bool) (*TRUSTEE_CIPHER, error) {
+	pads, err := p.computePads(roundID, excluded)
+	if err != nil {
+		return nil, err
+	}
+	message := &TRUSTEE_CIPHER{RoundID: roundID, Cipher: pads, NExcluded: len(excluded)}
+	if p.config.Toml.EquivocationProtectionEnabled {
+		if message.Sigmas, err = p.sumEquivocationPads(roundID, excluded); err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+// sendPads is run by the trustees in their own goroutine; it streams the ciphertexts of the next rounds to Client0
+func (p *DissentProtocol) sendPads() {
+	sleepTime := time.Duration(p.config.Toml.TrusteeSleepTimeBetweenMessages) * time.Millisecond
+	pauseTime := sleepTime
+	if pauseTime < trusteePausePollTime {
+		pauseTime = trusteePausePollTime
+	}
+	_, highBound := trusteeCacheBounds(p.config.Toml)
+
+	for roundID := 0; !p.HasStopped; roundID++ {
+		for p.flow.mustWait(highBound) && !p.HasStopped {
+			time.Sleep(pauseTime)
+		}
+		if (p.flow.isSlowedDown() || p.config.Toml.TrusteeAlwaysSlowDown) && sleepTime > 0 {
+			time.Sleep(sleepTime)
+		}
+		if p.HasStopped {
+			break
+		}
+
+		var excluded map[int]bool
+		roundID, excluded = p.flow.next(roundID)
+		message, err := p.trusteeCipher(roundID, excluded)
+		if err != nil {
+			log.Error("Could not compute the ciphertext for round", roundID, ":", err)
+			p.abort(err)
+			return
+		}
+		if err := p.ms.SendToClient0(message); err != nil {
+			log.Error("Could not send the ciphertext for round", roundID, ":", err)
+			return
+		}
+		p.flow.sent()
+	}
+	log.Lvl2("Protocol stopped, trustee stops sending ciphertexts")
+}
+
+// trusteeCipherBuffered is called on Client0 when a trustee's ciphertext is received; it asks that
+// trustee to slow down if its cache reaches the high bound
+func (p *DissentProtocol) trusteeCipherBuffered(trusteeID int) {
+	c := p.padCache
+	c.cached[trusteeID]++
+
+	_, highBound := trusteeCacheBounds(p.config.Toml)
+	if c.cached[trusteeID] >= highBound && !c.slowedDown[trusteeID] {
+		log.Lvl2("Client0 : cache of trustee", trusteeID, "is full (", c.cached[trusteeID], "ciphertexts), asking it to slow down")
+		c.slowedDown[trusteeID] = true
+		if err := p.ms.SendToTrustee(trusteeID, &TRUSTEE_RATE_CHANGE{SlowDown: true}); err != nil {
+			log.Error("Could not ask trustee", trusteeID, "to slow down:", err)
+		}
+	}
+}
+
+// trusteeCiphersConsumed is called on Client0 when a round is decoded; it asks the trustees whose
+// cache dropped to the low bound to resume
+func (p *DissentProtocol) trusteeCiphersConsumed() {
+	c := p.padCache
+	lowBound, _ := trusteeCacheBounds(p.config.Toml)
+	for trusteeID := range c.cached {
+		c.cached[trusteeID]--
+
+		if c.slowedDown[trusteeID] && c.cached[trusteeID] <= lowBound {
+			log.Lvl2("Client0 : cache of trustee", trusteeID, "is low (", c.cached[trusteeID], "ciphertexts), asking it to resume")
+			c.slowedDown[trusteeID] = false
+			if err := p.ms.SendToTrustee(trusteeID, &TRUSTEE_RATE_CHANGE{SlowDown: false}); err != nil {
+				log.Error("Could not ask trustee", trusteeID, "to resume:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package protocols
+
+import "testing"
+
+func TestTrusteeCacheBounds(t *testing.T) {
+	tests := []struct {
+		name      string
+		low, high int
+		wantLow   int
+		wantHigh  int
+	}{
+		{"valid", 10, 15, 10, 15},
+		{"no high bound", 10, 0, 10, defaultTrusteeCacheHighBound},
+		{"negative high bound", 3, -1, 3, defaultTrusteeCacheHighBound},
+		{"low above high", 20, 15, 7, 15},
+		{"low equals high", 15, 15, 7, 15},
+		{"negative low", -1, 15, 7, 15},
+		{"zero low", 0, 4, 0, 4},
+	}
+	for _, test := range tests {
+		low, high := trusteeCacheBounds(&DissentTomlConfig{RelayTrusteeCacheLowBound: test.low, RelayTrusteeCacheHighBound: test.high})
+		if low != test.wantLow || high != test.wantHigh {
+			t.Errorf("%s: got %d and %d, expected %d and %d", test.name, low, high, test.wantLow, test.wantHigh)
+		}
+	}
+}
+
+func TestTrusteeFlow(t *testing.T) {
+	const limit = 3
+	tests := []struct {
+		name       string
+		slowedDown bool
+		sent       int
+		wait       bool
+	}{
+		{"normal flow", false, 100, false},
+		{"slowed down", true, 0, false},
+		{"slowed down, below the limit", true, limit - 1, false},
+		{"slowed down, at the limit", true, limit, true},
+	}
+	for _, test := range tests {
+		f := &trusteeFlow{}
+		f.setSlowedDown(test.slowedDown)
+		for k := 0; k < test.sent; k++ {
+			f.sent()
+		}
+		if f.mustWait(limit) != test.wait {
+			t.Errorf("%s: wait %v, expected %v", test.name, !test.wait, test.wait)
+		}
+		//resuming, or being slowed down again, starts counting again
+		f.setSlowedDown(test.slowedDown)
+		if f.mustWait(limit) {
+			t.Errorf("%s: still waiting after a rate change", test.name)
+		}
+	}
+
+	//an exclusion resumes the flow
+	f := &trusteeFlow{}
+	f.setSlowedDown(true)
+	for k := 0; k < limit; k++ {
+		f.sent()
+	}
+	f.exclude([]int{1}, 7)
+	if f.mustWait(limit) || f.isSlowedDown() {
+		t.Error("the flow is still slowed down after an exclusion")
+	}
+	if roundID, excluded := f.next(3); roundID != 7 || !excluded[1] {
+		t.Errorf("next round %d, excluded %v", roundID, excluded)
+	}
+}
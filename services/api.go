@@ -0,0 +1,286 @@
+package services
+
+// This file contains the local API of the clients.
+//
+// When ClientAPIPort is set, each client, Client0 included, serves HTTP+JSON on 127.0.0.1:ClientAPIPort.
+// It is disabled by default, since two clients running on the same host need different ports:
+//
+//	POST /messages  {"data": "<base64>"}  enqueues a message for anonymous broadcast in our slot
+//	GET  /outputs                         streams the round outputs, one JSON object per line
+//	GET  /status                          returns our role, slot and protocol state
+//	POST /board     {"data": "<base64>"}  posts a message of any size to the bulletin board
+//	GET  /board?from=N                    returns the messages of the bulletin board from number N on
+//
+// The slots are shared with the SOCKS5 proxy; the first byte of each payload tells which of the
+// two it belongs to, and a message is preceded by its length.
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	dissent_protocol "github.com/lbarman/dissent-go/protocols"
+	"gopkg.in/dedis/onet.v2/log"
+)
+
+// The first byte of each payload tells which local application it belongs to
+const (
+	payloadSocks byte = iota + 1
+	payloadMessage
+)
+
+// apiSubscriberBuffer is the number of outputs buffered for a reader of /outputs before it misses some
+const apiSubscriberBuffer = 256
+
+// APIMessage is the body of POST /messages
+type APIMessage struct {
+	Data []byte `json:"data"`
+}
+
+// APIOutput is one line of GET /outputs; Messages holds the message in each slot, or null
+type APIOutput struct {
+	Round      int      `json:"round"`
+	Messages   [][]byte `json:"messages"`
+	Downstream []byte   `json:"downstream,omitempty"`
+}
+
+// APIStatus is the body of GET /status
+type APIStatus struct {
+	Role    string `json:"role"`
+	Running bool   `json:"running"`
+	State   string `json:"state"`
+	Slot    int    `json:"slot"`
+}
+
+// clientAPI serves the local API of a client
+type clientAPI struct {
+	sync.Mutex
+	service     *ServiceState
+	listener    net.Listener
+	subscribers map[chan *APIOutput]bool
+}
+
+// startClientAPI starts the local API, if ClientAPIPort is set
+func (s *ServiceState) startClientAPI() {
+	port := s.dissentTomlConfig.ClientAPIPort
+	if port <= 0 {
+		return
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		log.Error("Could not start the client API:", err)
+		return
+	}
+
+	api := &clientAPI{
+		service:     s,
+		listener:    listener,
+		subscribers: make(map[chan *APIOutput]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/messages", api.handleMessages)
+	mux.HandleFunc("/outputs", api.handleOutputs)
+	mux.HandleFunc("/status", api.handleStatus)
+	mux.HandleFunc("/board", api.handleBoard)
+	s.clientAPI = api
+
+	log.Lvl1("Client API listening on", listener.Addr())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Lvl2("Client API stopped:", err)
+		}
+	}()
+}
+
+func (api *clientAPI) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var msg APIMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "invalid message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if max := api.service.maxMessageSize(); len(msg.Data) > max {
+		http.Error(w, "message of "+strconv.Itoa(len(msg.Data))+" bytes is larger than "+strconv.Itoa(max)+" bytes",
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	p := api.service.roundsRunning()
+	if p == nil {
+		http.Error(w, "the protocol is not running", http.StatusServiceUnavailable)
+		return
+	}
+	if err := p.SendUpstream(encodeMessage(msg.Data)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (api *clientAPI) handleOutputs(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	outputs := api.subscribe()
+	defer api.unsubscribe(outputs)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case output := <-outputs:
+			if err := encoder.Encode(output); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (api *clientAPI) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s := api.service
+	status := APIStatus{
+		Role:  roleName(s.role),
+		State: s.ProtocolState().String(),
+		Slot:  -1,
+	}
+	if p := s.DissentProtocol; p != nil {
+		status.Running = true
+		status.Slot = p.Slot()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (api *clientAPI) handleBoard(w http.ResponseWriter, r *http.Request) {
+	s := api.service
+	if s.board == nil {
+		http.Error(w, "the bulletin board is disabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var msg APIMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			http.Error(w, "invalid message: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.postToBoard(msg.Data); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodGet:
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		entries, err := s.board.read(from)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+func (api *clientAPI) subscribe() chan *APIOutput {
+	api.Lock()
+	defer api.Unlock()
+	outputs := make(chan *APIOutput, apiSubscriberBuffer)
+	api.subscribers[outputs] = true
+	return outputs
+}
+
+func (api *clientAPI) unsubscribe(outputs chan *APIOutput) {
+	api.Lock()
+	defer api.Unlock()
+	delete(api.subscribers, outputs)
+}
+
+// publish passes a round output to every reader of /outputs
+func (api *clientAPI) publish(output *APIOutput) {
+	api.Lock()
+	defer api.Unlock()
+	for outputs := range api.subscribers {
+		select {
+		case outputs <- output:
+		default:
+			log.Lvl2("A reader of the client API is too slow, it misses round", output.Round)
+		}
+	}
+}
+
+// maxMessageSize returns the maximum size of a message posted to the API
+func (s *ServiceState) maxMessageSize() int {
+	return dissent_protocol.MaxUpstreamPayload(s.dissentTomlConfig) - messageHeaderSize
+}
+
+// messageHeaderSize is the size of the kind and length of a message in a slot
+const messageHeaderSize = 1 + 2
+
+func encodeMessage(data []byte) []byte {
+	buf := make([]byte, messageHeaderSize+len(data))
+	buf[0] = payloadMessage
+	binary.BigEndian.PutUint16(buf[1:3], uint16(len(data)))
+	copy(buf[messageHeaderSize:], data)
+	return buf
+}
+
+// decodeMessage returns the message contained in a payload, or nil if there is none
+func decodeMessage(payload []byte) []byte {
+	if len(payload) < messageHeaderSize || payload[0] != payloadMessage {
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(payload[1:3]))
+	if messageHeaderSize+length > len(payload) {
+		return nil
+	}
+	return payload[messageHeaderSize : messageHeaderSize+length]
+}
+
+// handleOutput is called with the output of each round; it passes the SOCKS frames to the exit and
+// to our SOCKS5 server, and the messages to the readers of the client API
+func (s *ServiceState) handleOutput(roundID int, slots [][]byte, downstream []byte) {
+	if s.socksEgress != nil {
+		for i, slot := range slots {
+			if len(slot) > 0 && slot[0] == payloadSocks {
+				s.socksEgress.handleFrames(i, slot[1:])
+			}
+		}
+	}
+	if s.socksIngress != nil && len(downstream) > 0 && downstream[0] == payloadSocks {
+		s.socksIngress.handleFrames(-1, downstream[1:])
+	}
+
+	if s.clientAPI != nil {
+		output := &APIOutput{Round: roundID, Messages: make([][]byte, len(slots))}
+		for i, slot := range slots {
+			output.Messages[i] = decodeMessage(slot)
+		}
+		output.Downstream = decodeMessage(downstream)
+		s.clientAPI.publish(output)
+	}
+}
+
+// roleName returns a printable name for a role
+func roleName(role dissent_protocol.DissentRole) string {
+	switch role {
+	case dissent_protocol.Client0:
+		return "client0"
+	case dissent_protocol.Trustee:
+		return "trustee"
+	}
+	return "client"
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dissent_protocol "github.com/lbarman/dissent-go/protocols"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	message := encodeMessage([]byte("hello"))
+	tests := []struct {
+		name    string
+		payload []byte
+		want    []byte
+	}{
+		{"message", message, []byte("hello")},
+		{"padded slot", append(append([]byte{}, message...), 0, 0, 0), []byte("hello")},
+		{"empty message", encodeMessage(nil), []byte{}},
+		{"empty slot", nil, nil},
+		{"SOCKS frames", append([]byte{payloadSocks}, message[1:]...), nil},
+		{"truncated header", message[:2], nil},
+		{"truncated message", message[:len(message)-1], nil},
+	}
+	for _, test := range tests {
+		got := decodeMessage(test.payload)
+		if (got == nil) != (test.want == nil) || !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHandleMessages(t *testing.T) {
+	s := &ServiceState{dissentTomlConfig: &dissent_protocol.DissentTomlConfig{PayloadSize: 64}}
+	api := &clientAPI{service: s}
+	max := s.maxMessageSize()
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"GET", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"invalid JSON", http.MethodPost, "{", http.StatusBadRequest},
+		{"too large", http.MethodPost, `{"data":"` + strings.Repeat("A", 4*(max+1)) + `"}`, http.StatusRequestEntityTooLarge},
+		{"not running", http.MethodPost, `{"data":"aGVsbG8="}`, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		api.handleMessages(w, httptest.NewRequest(test.method, "/messages", strings.NewReader(test.body)))
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.status)
+		}
+	}
+}
+
+func TestHandleOutput(t *testing.T) {
+	api := &clientAPI{subscribers: make(map[chan *APIOutput]bool)}
+	s := &ServiceState{clientAPI: api}
+	outputs := api.subscribe()
+
+	slots := [][]byte{
+		encodeMessage([]byte("first")),
+		nil,
+		append([]byte{payloadSocks}, 1, 2, 3),
+		encodeMessage([]byte("last")),
+	}
+	s.handleOutput(7, slots, encodeMessage([]byte("down")))
+
+	output := <-outputs
+	want := [][]byte{[]byte("first"), nil, nil, []byte("last")}
+	if output.Round != 7 || len(output.Messages) != len(want) {
+		t.Fatalf("got round %d with %d messages", output.Round, len(output.Messages))
+	}
+	for i := range want {
+		if !bytes.Equal(output.Messages[i], want[i]) || (output.Messages[i] == nil) != (want[i] == nil) {
+			t.Errorf("slot %d: got %q, want %q", i, output.Messages[i], want[i])
+		}
+	}
+	if string(output.Downstream) != "down" {
+		t.Errorf("got downstream %q", output.Downstream)
+	}
+
+	//a reader which does not keep up misses the outputs, instead of blocking the rounds
+	for i := 0; i < apiSubscriberBuffer+10; i++ {
+		s.handleOutput(i, slots, nil)
+	}
+	if len(outputs) != apiSubscriberBuffer {
+		t.Errorf("got %d buffered outputs, want %d", len(outputs), apiSubscriberBuffer)
+	}
+
+	api.unsubscribe(outputs)
+	if len(api.subscribers) != 0 {
+		t.Errorf("got %d subscribers after unsubscribing", len(api.subscribers))
+	}
+}
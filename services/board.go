@@ -0,0 +1,133 @@
+package services
+
+// This file contains the bulletin board of the clients.
+//
+// A message posted to the board is sent with DissentProtocol.SendMessage, which cuts it into
+// fragments sent in our slot over several rounds. Every client reassembles the messages of every
+// slot, and appends each complete message to a local log, BoardLogFile, as one JSON object per
+// line. The log is only appended to, and is keyed by a sequence number of its own: the round IDs
+// start again at 0 in every session, so a reader asks for the messages from the sequence number
+// after the last one it saw.
+// The board is disabled by default, since two clients running on the same host need different logs.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"gopkg.in/dedis/onet.v2/log"
+)
+
+// BoardEntry is a message of the bulletin board, as stored in the log
+type BoardEntry struct {
+	Seq   int    `json:"seq"`   // the position of the entry in the log
+	Round int    `json:"round"` // the round in which the last fragment was received, in its session
+	Slot  int    `json:"slot"`  // the anonymous slot of the sender
+	Data  []byte `json:"data"`
+}
+
+// board stores the messages of the bulletin board
+type board struct {
+	sync.Mutex
+	path    string
+	nextSeq int
+}
+
+// newBoard opens the log of the bulletin board, and numbers the new entries after the existing ones
+func newBoard(path string) (*board, error) {
+	b := &board{path: path}
+	entries, err := b.read(0)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		b.nextSeq = entries[len(entries)-1].Seq + 1
+	}
+	return b, nil
+}
+
+// store is called with each message reassembled by the protocol
+func (b *board) store(roundID, slot int, data []byte) {
+	b.Lock()
+	defer b.Unlock()
+	if err := b.append(&BoardEntry{Seq: b.nextSeq, Round: roundID, Slot: slot, Data: data}); err != nil {
+		log.Error("Could not store a message of the bulletin board:", err)
+	}
+}
+
+// append writes an entry at the end of the log
+func (b *board) append(entry *BoardEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(b.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	b.nextSeq++
+	log.Lvl2("New message on the bulletin board from slot", entry.Slot, "in round", entry.Round)
+	return nil
+}
+
+// read returns the entries of the log from the given sequence number on
+func (b *board) read(from int) ([]*BoardEntry, error) {
+	entries := make([]*BoardEntry, 0)
+	f, err := os.Open(b.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		entry := &BoardEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, err
+		}
+		if entry.Seq >= from {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// startBoard opens the bulletin board, if BoardLogFile is set
+func (s *ServiceState) startBoard() {
+	path := s.dissentTomlConfig.BoardLogFile
+	if path == "" {
+		return
+	}
+	b, err := newBoard(path)
+	if err != nil {
+		log.Error("Could not open the bulletin board:", err)
+		return
+	}
+	s.board = b
+}
+
+// postToBoard queues a message in our slot
+func (s *ServiceState) postToBoard(data []byte) error {
+	p := s.roundsRunning()
+	if p == nil {
+		return errors.New("the protocol is not running")
+	}
+	return p.SendMessage(data)
+}
+
+// handleMessage is called with each message reassembled by the protocol
+func (s *ServiceState) handleMessage(roundID int, slot int, data []byte) {
+	if s.board != nil {
+		s.board.store(roundID, slot, data)
+	}
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "board")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "board.log")
+
+	//two sessions, whose round IDs both start at 0
+	b, err := newBoard(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.store(3, 1, []byte("a"))
+	b.store(7, 0, []byte("b"))
+	b, err = newBoard(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.store(2, 1, []byte("c"))
+	b.store(5, 2, []byte("d"))
+
+	tests := []struct {
+		name string
+		from int
+		data string
+	}{
+		{"everything", 0, "abcd"},
+		{"from the second", 1, "bcd"},
+		{"across the restart", 2, "cd"},
+		{"the last one", 3, "d"},
+		{"nothing new", 4, ""},
+		{"negative", -1, "abcd"},
+	}
+	for _, test := range tests {
+		entries, err := b.read(test.from)
+		if err != nil {
+			t.Fatal(test.name, ":", err)
+		}
+		data := ""
+		for k, e := range entries {
+			if e.Seq != test.from+k && test.from >= 0 {
+				t.Errorf("%s: entry %d has number %d", test.name, k, e.Seq)
+			}
+			data += string(e.Data)
+		}
+		if data != test.data {
+			t.Errorf("%s: got %q, expected %q", test.name, data, test.data)
+		}
+	}
+}
@@ -3,11 +3,14 @@ package services
 // This file contains the logic to handle churn.
 
 import (
+	"errors"
 	"github.com/lbarman/dissent-go/protocols"
 	"gopkg.in/dedis/onet.v2"
 	"gopkg.in/dedis/onet.v2/log"
 	"gopkg.in/dedis/onet.v2/network"
+	"sort"
 	"sync"
+	"time"
 )
 
 /*
@@ -17,19 +20,78 @@ import (
  *
  * When a node connects :
  * the relay identifies him as client or trustee using the stored group.toml
+ * he rejects him if he is not in group.toml, if his claimed role does not match, or if he is not
+ * described as a client in allowlist mode
  * he adds it to the list of nodes
  * if PriFi was running, he kills it, and rerun it if > threshold
  *
  * When a node disconnect :
- * He sends STOP messages to every other node
+ * He removes it from the list of nodes, and renumbers the remaining ones
  * He kills his local instance of PriFi protocol
- * He empties the list of waiting nodes
+ * He restarts it right away with the remaining nodes, if > threshold
+ *
+ * When a round times out :
+ * He removes the late nodes from the list of nodes
+ * PriFi continues without the late clients, or he restarts it if it cannot
+ *
+ * When a node is identified as a disruptor :
+ * He removes it from the list of nodes, and refuses its future connections
+ * He restarts PriFi with the remaining nodes
  *
  * Every X seconds :
  * if the protocol is not running
  * count the number of participants, if > threshold, start prifi
+ *
+ * The threshold is the quorum policy of dissent.toml. Once it is reached, the relay waits for
+ * a grace period, so that the nodes joining meanwhile are part of the same session, and he
+ * never starts more than QuorumMaxRestartsPerMinute sessions per minute
+ *
+ * While a session runs, the nodes which connect do not interrupt it : they wait in a pending
+ * list, and are admitted at the next epoch boundary (every EpochDuration), or as soon as
+ * EpochMaxPendingJoiners of them are waiting, or when the session restarts for another reason
  */
 
+// epochPolicy tells when the relay admits the nodes which connected during a session
+type epochPolicy struct {
+	duration   time.Duration // 0 to admit the nodes right away, restarting the session each time
+	maxPending int           // 0 for no limit
+}
+
+// newEpochPolicy reads the epoch policy from dissent.toml
+func newEpochPolicy(toml *protocols.DissentTomlConfig) epochPolicy {
+	return epochPolicy{
+		duration:   time.Duration(toml.EpochDuration) * time.Millisecond,
+		maxPending: toml.EpochMaxPendingJoiners,
+	}
+}
+
+// quorumPolicy tells when the relay can start a session
+type quorumPolicy struct {
+	minClients           int // Client0 included
+	minTrustees          int
+	allTrustees          bool // wait for every trustee of group.toml
+	gracePeriod          time.Duration
+	maxRestartsPerMinute int // 0 for no limit
+}
+
+// newQuorumPolicy reads the quorum policy from dissent.toml; a session needs at least a client and a trustee
+func newQuorumPolicy(toml *protocols.DissentTomlConfig) quorumPolicy {
+	q := quorumPolicy{
+		minClients:           toml.QuorumMinClients,
+		minTrustees:          toml.QuorumMinTrustees,
+		allTrustees:          toml.QuorumWaitForAllTrustees,
+		gracePeriod:          time.Duration(toml.QuorumGracePeriod) * time.Millisecond,
+		maxRestartsPerMinute: toml.QuorumMaxRestartsPerMinute,
+	}
+	if q.minClients < 1 {
+		q.minClients = 1
+	}
+	if q.minTrustees < 1 {
+		q.minTrustees = 1
+	}
+	return q
+}
+
 type waitQueueEntry struct {
 	serverID  *network.ServerIdentity
 	numericID int
@@ -42,6 +104,7 @@ type waitQueue struct {
 	writeMutex sync.Mutex
 	trustees   map[string]*waitQueueEntry
 	clients    map[string]*waitQueueEntry
+	pending    []*waitQueueEntry // nodes which connected during a session, by order of arrival
 }
 
 func idFromMsg(msg *network.Envelope) string {
@@ -55,10 +118,17 @@ func idFromServerIdentity(si *network.ServerIdentity) string {
 
 type churnHandler struct {
 	waitQueue         *waitQueue
+	excluded          map[string]bool //nodes identified as disruptors, which cannot join anymore
+	members           map[string]bool //the nodes of the group file; the others cannot join
+	allowedClients    map[string]bool //the clients of the group file, nil if any member can join as a client
 	nextFreeClientID  int
 	nextFreeTrusteeID int
 	client0ID         *network.ServerIdentity //necessary to call createRoster
 	trusteesIDs       []*network.ServerIdentity
+	quorum            quorumPolicy
+	epoch             epochPolicy
+	startTimer        *time.Timer // pending start, during the grace period
+	restarts          []time.Time // the starts of the last minute
 
 	//to be specified when instantiated
 	startProtocol     func()
@@ -66,13 +136,13 @@ type churnHandler struct {
 	isProtocolRunning func() bool
 }
 
-func (c *churnHandler) init(client0ID *network.ServerIdentity, trusteesIDs []*network.ServerIdentity) {
+func (c *churnHandler) init(client0ID *network.ServerIdentity, trusteesIDs []*network.ServerIdentity, quorum quorumPolicy, epoch epochPolicy) error {
 
 	if client0ID == nil {
-		log.Fatal("Can't start the churnHandler without the relayID")
+		return errors.New("can't start the churnHandler without the relayID")
 	}
 	if trusteesIDs == nil {
-		log.Fatal("Can't start the churnHandler without the trusteesIDs")
+		return errors.New("can't start the churnHandler without the trusteesIDs")
 	}
 
 	c.waitQueue = &waitQueue{
@@ -85,16 +155,23 @@ func (c *churnHandler) init(client0ID *network.ServerIdentity, trusteesIDs []*ne
 		role:      protocols.Client,
 		numericID: 0,
 	}
+	c.excluded = make(map[string]bool)
 	c.nextFreeClientID = 1
 	c.nextFreeTrusteeID = 0
 	c.client0ID = client0ID
 	c.trusteesIDs = trusteesIDs
+	c.quorum = quorum
+	c.epoch = epoch
+	return nil
 }
 
 /**
- * Checks whether an ID is in the waiting clients/trustees (given isTrustee)
+ * Checks whether an ID is in the waiting clients/trustees (given isTrustee), or in the pending nodes
  */
 func (wq *waitQueue) contains(stringID string, isTrustee bool) bool {
+	if wq.pendingIndex(stringID) >= 0 {
+		return true
+	}
 	if isTrustee {
 		_, ok := wq.trustees[stringID]
 		return ok
@@ -103,6 +180,18 @@ func (wq *waitQueue) contains(stringID string, isTrustee bool) bool {
 	return ok
 }
 
+/**
+ * Returns the position of an ID in the pending nodes, or -1
+ */
+func (wq *waitQueue) pendingIndex(stringID string) int {
+	for i, v := range wq.pending {
+		if idFromServerIdentity(v.serverID) == stringID {
+			return i
+		}
+	}
+	return -1
+}
+
 /**
  * Returns nClients, nTrustees waiting
  */
@@ -111,22 +200,31 @@ func (wq *waitQueue) count() (int, int) {
 }
 
 /**
- * Creates a roster from waiting nodes, used by SDA
+ * Returns the entries ordered by numericID, i.e., by order of arrival
  */
-func (c *churnHandler) createRoster() *onet.Roster {
+func sortedEntries(entries map[string]*waitQueueEntry) []*waitQueueEntry {
+	res := make([]*waitQueueEntry, 0, len(entries))
+	for _, v := range entries {
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].numericID < res[j].numericID
+	})
+	return res
+}
 
-	n, m := c.waitQueue.count()
-	nParticipants := n + m
+/**
+ * Creates a roster from waiting nodes, used by SDA. The clients come first, then the trustees,
+ * both in the order of the IDs given to the protocol
+ */
+func (c *churnHandler) createRoster() *onet.Roster {
 
-	participants := make([]*network.ServerIdentity, nParticipants)
-	i := 0
-	for _, v := range c.waitQueue.clients {
-		participants[i] = v.serverID
-		i++
+	participants := make([]*network.ServerIdentity, 0)
+	for _, v := range sortedEntries(c.waitQueue.clients) {
+		participants = append(participants, v.serverID)
 	}
-	for _, v := range c.waitQueue.trustees {
-		participants[i] = v.serverID
-		i++
+	for _, v := range sortedEntries(c.waitQueue.trustees) {
+		participants = append(participants, v.serverID)
 	}
 
 	roster := onet.NewRoster(participants)
@@ -151,7 +249,9 @@ func (c *churnHandler) isATrustee(ID *network.ServerIdentity) bool {
 }
 
 /**
- * Creates an IdentityMap from the waiting nodes, used by PriFi-lib
+ * Creates an IdentityMap from the waiting nodes, used by the protocol. The IDs are contiguous and
+ * follow the numericIDs; Client0 participates as client 0. The protocol sends this numbering to
+ * every node in ALL_ALL_PARAMETERS
  */
 func (c *churnHandler) createIdentitiesMap() map[string]protocols.DissentIdentity {
 	res := make(map[string]protocols.DissentIdentity)
@@ -163,20 +263,20 @@ func (c *churnHandler) createIdentitiesMap() map[string]protocols.DissentIdentit
 		ServerID: c.client0ID,
 	}
 
-	//add clients
-	for _, v := range c.waitQueue.clients {
+	//add clients, Client0 included
+	for i, v := range sortedEntries(c.waitQueue.clients) {
 		res[idFromServerIdentity(v.serverID)] = protocols.DissentIdentity{
 			Role:     protocols.Client,
-			ID:       v.numericID,
+			ID:       i,
 			ServerID: v.serverID,
 		}
 	}
 
 	//add trustees
-	for _, v := range c.waitQueue.trustees {
+	for i, v := range sortedEntries(c.waitQueue.trustees) {
 		res[idFromServerIdentity(v.serverID)] = protocols.DissentIdentity{
 			Role:     protocols.Trustee,
-			ID:       v.numericID,
+			ID:       i,
 			ServerID: v.serverID,
 		}
 	}
@@ -185,39 +285,64 @@ func (c *churnHandler) createIdentitiesMap() map[string]protocols.DissentIdentit
 }
 
 func (c *churnHandler) getClientsIdentities() []*network.ServerIdentity {
-	nClients := len(c.waitQueue.clients)
-	clients := make([]*network.ServerIdentity, nClients)
-	i := 0
-	for _, v := range c.waitQueue.clients {
-		clients[i] = v.serverID
-		i++
+	clients := make([]*network.ServerIdentity, 0, len(c.waitQueue.clients))
+	for _, v := range sortedEntries(c.waitQueue.clients) {
+		clients = append(clients, v.serverID)
 	}
 	return clients
 }
 
 func (c *churnHandler) getTrusteesIdentities() []*network.ServerIdentity {
-	nTrustees := len(c.waitQueue.trustees)
-	trustees := make([]*network.ServerIdentity, nTrustees)
-	i := 0
-	for _, v := range c.waitQueue.trustees {
-		trustees[i] = v.serverID
-		i++
+	trustees := make([]*network.ServerIdentity, 0, len(c.waitQueue.trustees))
+	for _, v := range sortedEntries(c.waitQueue.trustees) {
+		trustees = append(trustees, v.serverID)
 	}
 	return trustees
 }
 
 /**
- * Handles a "Connection" message
+ * Checks the role claimed by a connecting node against the group file : the trustees are the
+ * nodes described as "trustee", nodes absent from the group file are refused, and if allowedClients
+ * is set, the clients must be described as "client"
  */
-func (c *churnHandler) handleConnection(msg *network.Envelope) {
+func (c *churnHandler) authorize(si *network.ServerIdentity, claimsTrustee bool) error {
+	ID := idFromServerIdentity(si)
+	if ID == idFromServerIdentity(c.client0ID) {
+		return errors.New("the relay cannot connect to itself")
+	}
+	if c.excluded[ID] {
+		return errors.New("excluded as a disruptor")
+	}
+	if !c.members[ID] {
+		return errors.New("unknown identity, not in the group file")
+	}
+	isTrustee := c.isATrustee(si)
+	if claimsTrustee && !isTrustee {
+		return errors.New("not a trustee of the group file")
+	}
+	if !claimsTrustee && isTrustee {
+		return errors.New("a trustee of the group file cannot join as a client")
+	}
+	if !isTrustee && c.allowedClients != nil && !c.allowedClients[ID] {
+		return errors.New("not described as a client in the group file")
+	}
+	return nil
+}
+
+/**
+ * Handles a "Connection" message. Returns an error if the node is not allowed to join
+ */
+func (c *churnHandler) handleConnection(msg *network.Envelope) error {
 
 	c.waitQueue.writeMutex.Lock()
 	defer c.waitQueue.writeMutex.Unlock()
 
 	ID := idFromMsg(msg)
-	isTrustee := c.isATrustee(msg.ServerIdentity)
 	connectionMessage := msg.Msg.(*ConnectionRequest)
-	isTrustee  = connectionMessage.AmIATrustee
+	if err := c.authorize(msg.ServerIdentity, connectionMessage.AmIATrustee); err != nil {
+		return err
+	}
+	isTrustee := connectionMessage.AmIATrustee
 	node := "client"
 	if isTrustee {
 		node = "trustee"
@@ -225,14 +350,40 @@ func (c *churnHandler) handleConnection(msg *network.Envelope) {
 
 	if c.waitQueue.contains(ID, isTrustee) {
 		log.Lvl4("Ignored new connection request from", node, ID, "already in the list")
-		return
+		return nil
 	}
 
 	log.Lvl2("Received new connection request from", node, ID)
 
+	role := protocols.Client
 	if isTrustee {
+		role = protocols.Trustee
+	}
+
+	//do not interrupt a running session, the node joins at the next epoch
+	if c.epoch.duration > 0 && c.isProtocolRunning() {
+		c.waitQueue.pending = append(c.waitQueue.pending, &waitQueueEntry{serverID: msg.ServerIdentity, role: role})
+		log.Lvl2(node, ID, "will join at the next epoch,", len(c.waitQueue.pending), "nodes pending")
+		if c.epoch.maxPending > 0 && len(c.waitQueue.pending) >= c.epoch.maxPending {
+			c.admitPending()
+			c.tryStartProtocol()
+		}
+		return nil
+	}
+
+	c.admit(msg.ServerIdentity, role)
+	c.tryStartProtocol()
+	return nil
+}
+
+/**
+ * Adds a node to the waiting clients/trustees, with the next free numericID
+ */
+func (c *churnHandler) admit(si *network.ServerIdentity, role protocols.DissentRole) {
+	ID := idFromServerIdentity(si)
+	if role == protocols.Trustee {
 		c.waitQueue.trustees[ID] = &waitQueueEntry{
-			serverID:  msg.ServerIdentity,
+			serverID:  si,
 			role:      protocols.Trustee,
 			numericID: c.nextFreeTrusteeID,
 		}
@@ -240,15 +391,44 @@ func (c *churnHandler) handleConnection(msg *network.Envelope) {
 		c.nextFreeTrusteeID++
 	} else {
 		c.waitQueue.clients[ID] = &waitQueueEntry{
-			serverID:  msg.ServerIdentity,
+			serverID:  si,
 			role:      protocols.Client,
 			numericID: c.nextFreeClientID,
 		}
 		log.Lvl3("ID ", ID, " assigned to client #", c.nextFreeClientID)
 		c.nextFreeClientID++
 	}
+}
 
-	c.tryStartProtocol()
+/**
+ * Admits the pending nodes, by order of arrival. Must be called with the waitQueue locked
+ */
+func (c *churnHandler) admitPending() {
+	if len(c.waitQueue.pending) == 0 {
+		return
+	}
+	log.Lvl2("New epoch, admitting", len(c.waitQueue.pending), "pending nodes")
+	for _, v := range c.waitQueue.pending {
+		c.admit(v.serverID, v.role)
+	}
+	c.waitQueue.pending = nil
+}
+
+/**
+ * Admits the pending nodes at every epoch boundary. Runs in its own goroutine on the relay
+ */
+func (c *churnHandler) runEpochs() {
+	if c.epoch.duration <= 0 {
+		return
+	}
+	for range time.Tick(c.epoch.duration) {
+		c.waitQueue.writeMutex.Lock()
+		if len(c.waitQueue.pending) > 0 {
+			c.admitPending()
+			c.tryStartProtocol()
+		}
+		c.waitQueue.writeMutex.Unlock()
+	}
 }
 
 func (c *churnHandler) handleUnknownDisconnection() {
@@ -258,6 +438,7 @@ func (c *churnHandler) handleUnknownDisconnection() {
 
 	c.waitQueue.clients = make(map[string]*waitQueueEntry)
 	c.waitQueue.trustees = make(map[string]*waitQueueEntry)
+	c.waitQueue.pending = nil
 
 	ID0 := idFromServerIdentity(c.client0ID)
 	c.waitQueue.clients[ID0] = &waitQueueEntry{
@@ -274,58 +455,210 @@ func (c *churnHandler) handleUnknownDisconnection() {
 }
 
 /**
- * Handles a "Disconnection" message
+ * Excludes a node identified as a disruptor : it is removed from the waiting nodes,
+ * cannot connect again, and the protocol restarts without it
  */
-func (c *churnHandler) handleDisconnection(msg *network.Envelope) {
+func (c *churnHandler) excludeNode(si *network.ServerIdentity) {
 
-	ID := idFromMsg(msg)
-	isTrustee := c.isATrustee(msg.ServerIdentity)
+	c.waitQueue.writeMutex.Lock()
+	defer c.waitQueue.writeMutex.Unlock()
 
-	if !c.waitQueue.contains(ID, isTrustee) {
-		log.Lvl4("Ignored new disconnection request from", ID, " (isATrustee:", isTrustee, "), not in the list")
+	ID := idFromServerIdentity(si)
+	if ID == idFromServerIdentity(c.client0ID) {
+		log.Error("Cannot exclude Client0 from its own session")
 		return
 	}
+	c.excluded[ID] = true
+	delete(c.waitQueue.clients, ID)
+	delete(c.waitQueue.trustees, ID)
 
-	log.Lvl3("Received new disconnection request from", ID, " (isATrustee:", isTrustee, ")")
+	c.stopProtocol()
+	c.tryStartProtocol()
+}
 
-	/* This is the smart way. Dumb way first
-	if isTrustee {
-		delete(c.waitQueue.trustees, ID)
-		c.nextFreeTrusteeID = 0
-		for k := range c.waitQueue.trustees {
-			c.waitQueue.trustees[k].numericID = c.nextFreeTrusteeID
-			c.nextFreeTrusteeID++
+/**
+ * Removes the nodes which were late in a round, and returns their identities. If resync is
+ * true, the protocol restarts without them; otherwise it continues on its own
+ */
+func (c *churnHandler) removeLateNodes(IDs []string, resync bool) []*network.ServerIdentity {
+
+	c.waitQueue.writeMutex.Lock()
+	defer c.waitQueue.writeMutex.Unlock()
+
+	removed := make([]*network.ServerIdentity, 0)
+	for _, ID := range IDs {
+		if ID == idFromServerIdentity(c.client0ID) {
+			continue
 		}
-		c.nextFreeTrusteeID += 1
-	} else {
+		if v, ok := c.waitQueue.clients[ID]; ok {
+			removed = append(removed, v.serverID)
+			delete(c.waitQueue.clients, ID)
+		}
+		if v, ok := c.waitQueue.trustees[ID]; ok {
+			removed = append(removed, v.serverID)
+			delete(c.waitQueue.trustees, ID)
+		}
+	}
+
+	if resync {
+		c.stopProtocol()
+		c.tryStartProtocol()
+	}
+	return removed
+}
+
+/**
+ * Removes a node which left, and renumbers the remaining nodes of its role by order of arrival,
+ * so that the IDs stay contiguous. The protocol restarts right away with the remaining nodes.
+ * Returns false if the node was not in the list
+ */
+func (c *churnHandler) removeNode(si *network.ServerIdentity) bool {
+
+	c.waitQueue.writeMutex.Lock()
+	defer c.waitQueue.writeMutex.Unlock()
+
+	ID := idFromServerIdentity(si)
+	if ID == idFromServerIdentity(c.client0ID) {
+		log.Lvl3("Ignored the departure of Client0 itself")
+		return false
+	}
+
+	//a pending node is not part of the session, which goes on
+	if i := c.waitQueue.pendingIndex(ID); i >= 0 {
+		c.waitQueue.pending = append(c.waitQueue.pending[:i], c.waitQueue.pending[i+1:]...)
+		log.Lvl2("Removed pending node", ID)
+		return true
+	}
+
+	if _, ok := c.waitQueue.trustees[ID]; ok {
+		delete(c.waitQueue.trustees, ID)
+		c.nextFreeTrusteeID = renumber(c.waitQueue.trustees)
+		log.Lvl2("Removed trustee", ID, ",", len(c.waitQueue.trustees), "trustees left")
+	} else if _, ok := c.waitQueue.clients[ID]; ok {
 		delete(c.waitQueue.clients, ID)
-		c.nextFreeClientID = 0
-		for k := range c.waitQueue.clients {
-			c.waitQueue.clients[k].numericID = c.nextFreeClientID
-			c.nextFreeClientID++
+		c.nextFreeClientID = renumber(c.waitQueue.clients)
+		log.Lvl2("Removed client", ID, ",", len(c.waitQueue.clients), "clients left")
+	} else {
+		return false
+	}
+
+	c.stopProtocol()
+	c.tryStartProtocol()
+	return true
+}
+
+/**
+ * Gives contiguous numericIDs from 0 on to the entries, keeping their order of arrival.
+ * Client0 keeps the ID 0, since it is the first client. Returns the next free ID
+ */
+func renumber(entries map[string]*waitQueueEntry) int {
+	next := 0
+	for _, v := range sortedEntries(entries) {
+		v.numericID = next
+		next++
+	}
+	return next
+}
+
+/**
+ * Handles a "Disconnection" message
+ */
+func (c *churnHandler) handleDisconnection(msg *network.Envelope) {
+
+	ID := idFromMsg(msg)
+	if !c.removeNode(msg.ServerIdentity) {
+		log.Lvl4("Ignored new disconnection request from", ID, ", not in the list")
+		return
+	}
+	log.Lvl3("Received new disconnection request from", ID)
+}
+
+/**
+ * Returns true if the waiting nodes satisfy the quorum policy
+ */
+func (c *churnHandler) hasQuorum() bool {
+	nClients, nTrustees := c.waitQueue.count()
+	if c.quorum.allTrustees && nTrustees < len(c.trusteesIDs) {
+		return false
+	}
+	return nClients >= c.quorum.minClients && nTrustees >= c.quorum.minTrustees
+}
+
+/**
+ * Returns how long to wait before the next start to respect maxRestartsPerMinute
+ */
+func (c *churnHandler) restartDelay(now time.Time) time.Duration {
+	recent := make([]time.Time, 0, len(c.restarts))
+	for _, t := range c.restarts {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
 		}
-		c.nextFreeClientID += 1
 	}
-	*/
-	c.handleUnknownDisconnection()
+	c.restarts = recent
+	if c.quorum.maxRestartsPerMinute <= 0 || len(recent) < c.quorum.maxRestartsPerMinute {
+		return 0
+	}
+	return recent[len(recent)-c.quorum.maxRestartsPerMinute].Add(time.Minute).Sub(now)
 }
 
 /**
- * restarts the protocol (stop + start) if nClients waiting & nTrustees waiting both > 1
+ * restarts the protocol (stop + start) once the quorum policy is satisfied, after the grace period
+ * and no faster than the maximum restart rate. Must be called with the waitQueue locked
  */
 func (c *churnHandler) tryStartProtocol() {
 	nClients, nTrustees := c.waitQueue.count()
 
-	if nClients >= 1 && nTrustees >= 1 {
-		if c.isProtocolRunning() {
-			c.stopProtocol()
+	if !c.hasQuorum() {
+		if c.startTimer != nil {
+			c.startTimer.Stop()
+			c.startTimer = nil
 		}
-		if c.startProtocol == nil {
-			log.Lvl1("Enough participants (", nClients, "clients and", nTrustees, "trustees), but no handler to start.")
+		log.Lvl1("Too few participants (", nClients, "clients and", nTrustees, "trustees), waiting...")
+		return
+	}
+	if c.startTimer != nil {
+		log.Lvl3("A start is already scheduled, the new participants will be part of it")
+		return
+	}
+
+	delay := c.quorum.gracePeriod
+	if wait := c.restartDelay(time.Now()); wait > delay {
+		log.Lvl2("Too many restarts in the last minute, waiting", wait)
+		delay = wait
+	}
+	if delay <= 0 {
+		c.startNow()
+		return
+	}
+
+	log.Lvl2("Enough participants (", nClients, "clients and", nTrustees, "trustees), starting in", delay)
+	c.startTimer = time.AfterFunc(delay, func() {
+		c.waitQueue.writeMutex.Lock()
+		defer c.waitQueue.writeMutex.Unlock()
+
+		c.startTimer = nil
+		if !c.hasQuorum() {
+			log.Lvl1("Participants left during the grace period, waiting...")
 			return
 		}
-		c.startProtocol()
-	} else {
-		log.Lvl1("Too few participants (", nClients, "clients and", nTrustees, "trustees), waiting...")
+		c.startNow()
+	})
+}
+
+/**
+ * restarts the protocol (stop + start) with the waiting nodes. Must be called with the waitQueue locked
+ */
+func (c *churnHandler) startNow() {
+	nClients, nTrustees := c.waitQueue.count()
+	if c.isProtocolRunning() {
+		c.stopProtocol()
+	}
+	if c.startProtocol == nil {
+		log.Lvl1("Enough participants (", nClients, "clients and", nTrustees, "trustees), but no handler to start.")
+		return
 	}
+	//every restart is a new epoch
+	c.admitPending()
+	c.restarts = append(c.restarts, time.Now())
+	c.startProtocol()
 }
@@ -0,0 +1,448 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+	"github.com/lbarman/dissent-go/protocols"
+	"gopkg.in/dedis/onet.v2"
+	"gopkg.in/dedis/onet.v2/app"
+	"gopkg.in/dedis/onet.v2/network"
+)
+
+// churnIdentities returns n identities with distinct keys and addresses
+func churnIdentities(n int) []*network.ServerIdentity {
+	suite := config.CryptoSuite
+	res := make([]*network.ServerIdentity, n)
+	for i := range res {
+		pub := suite.Point().Pick(suite.RandomStream())
+		res[i] = network.NewServerIdentity(pub, network.NewTCPAddress("127.0.0.1:"+strconv.Itoa(7000+2*i)))
+	}
+	return res
+}
+
+// entries returns a waitQueue map with the given numericIDs, keyed by strings ordered like them
+func entries(numericIDs ...int) map[string]*waitQueueEntry {
+	res := make(map[string]*waitQueueEntry)
+	for _, id := range numericIDs {
+		res["node"+strconv.Itoa(id)] = &waitQueueEntry{numericID: id}
+	}
+	return res
+}
+
+func TestSortedEntries(t *testing.T) {
+	tests := []struct {
+		numericIDs []int
+		want       []int
+	}{
+		{nil, []int{}},
+		{[]int{0}, []int{0}},
+		{[]int{2, 0, 1}, []int{0, 1, 2}},
+		{[]int{7, 3, 0, 12}, []int{0, 3, 7, 12}},
+	}
+	for _, test := range tests {
+		sorted := sortedEntries(entries(test.numericIDs...))
+		if len(sorted) != len(test.want) {
+			t.Errorf("%v: got %d entries, want %d", test.numericIDs, len(sorted), len(test.want))
+			continue
+		}
+		for i, v := range sorted {
+			if v.numericID != test.want[i] {
+				t.Errorf("%v: entry %d has ID %d, want %d", test.numericIDs, i, v.numericID, test.want[i])
+			}
+		}
+	}
+}
+
+func TestCreateIdentitiesMap(t *testing.T) {
+	tests := []struct {
+		name       string
+		clientIDs  []int // numericIDs of the clients other than Client0
+		trusteeIDs []int
+	}{
+		{"contiguous", []int{1, 2, 3}, []int{0, 1}},
+		{"gaps after departures", []int{2, 5, 9}, []int{1, 4}},
+		{"Client0 alone", nil, []int{0}},
+	}
+	for _, test := range tests {
+		identities := churnIdentities(1 + len(test.clientIDs) + len(test.trusteeIDs))
+		c := &churnHandler{}
+		if err := c.init(identities[0], identities[1+len(test.clientIDs):], quorumPolicy{}, epochPolicy{}); err != nil {
+			t.Fatal(err)
+		}
+		for i, id := range test.clientIDs {
+			si := identities[1+i]
+			c.waitQueue.clients[idFromServerIdentity(si)] = &waitQueueEntry{serverID: si, numericID: id, role: protocols.Client}
+		}
+		for i, id := range test.trusteeIDs {
+			si := identities[1+len(test.clientIDs)+i]
+			c.waitQueue.trustees[idFromServerIdentity(si)] = &waitQueueEntry{serverID: si, numericID: id, role: protocols.Trustee}
+		}
+
+		res := c.createIdentitiesMap()
+		if len(res) != len(identities) {
+			t.Errorf("%s: got %d identities, want %d", test.name, len(res), len(identities))
+			continue
+		}
+		//Client0 is client 0, and the others follow their order of arrival
+		for i, si := range identities[:1+len(test.clientIDs)] {
+			id := res[idFromServerIdentity(si)]
+			if id.Role != protocols.Client || id.ID != i {
+				t.Errorf("%s: client %d got role %v and ID %d", test.name, i, id.Role, id.ID)
+			}
+		}
+		for i, si := range identities[1+len(test.clientIDs):] {
+			id := res[idFromServerIdentity(si)]
+			if id.Role != protocols.Trustee || id.ID != i {
+				t.Errorf("%s: trustee %d got role %v and ID %d", test.name, i, id.Role, id.ID)
+			}
+		}
+	}
+}
+
+// churnCounter counts the starts and stops of the protocol by a churnHandler
+type churnCounter struct {
+	starts  int
+	stops   int
+	running bool
+}
+
+// testChurn returns the churnHandler of identities[0], for which identities[1:1+nTrustees] are the
+// trustees and every identity is a member of the group
+func testChurn(t *testing.T, identities []*network.ServerIdentity, nTrustees int, quorum quorumPolicy, epoch epochPolicy) (*churnHandler, *churnCounter) {
+	counter := &churnCounter{}
+	c := &churnHandler{
+		members: make(map[string]bool),
+		startProtocol: func() {
+			counter.starts++
+			counter.running = true
+		},
+		stopProtocol: func() {
+			counter.stops++
+			counter.running = false
+		},
+		isProtocolRunning: func() bool { return counter.running },
+	}
+	if err := c.init(identities[0], identities[1:1+nTrustees], quorum, epoch); err != nil {
+		t.Fatal(err)
+	}
+	for _, si := range identities {
+		c.members[idFromServerIdentity(si)] = true
+	}
+	return c, counter
+}
+
+func TestRenumber(t *testing.T) {
+	tests := []struct {
+		numericIDs []int
+		want       int
+	}{
+		{nil, 0},
+		{[]int{0}, 1},
+		{[]int{0, 2, 3}, 3},
+		{[]int{4, 9, 1, 7}, 4},
+	}
+	for _, test := range tests {
+		e := entries(test.numericIDs...)
+		if next := renumber(e); next != test.want {
+			t.Errorf("%v: got next ID %d, want %d", test.numericIDs, next, test.want)
+		}
+		//the order of arrival is kept : an entry gets the number of entries which arrived before it
+		for _, id := range test.numericIDs {
+			before := 0
+			for _, other := range test.numericIDs {
+				if other < id {
+					before++
+				}
+			}
+			if got := e["node"+strconv.Itoa(id)].numericID; got != before {
+				t.Errorf("%v: entry %d got ID %d, want %d", test.numericIDs, id, got, before)
+			}
+		}
+	}
+}
+
+func TestRemoveNode(t *testing.T) {
+	// identities: Client0, 2 trustees, 3 clients, a pending client, an unknown node
+	const (
+		client0  = 0
+		trustee0 = 1
+		trustee1 = 2
+		client1  = 3
+		client2  = 4
+		client3  = 5
+		pending  = 6
+		unknown  = 7
+	)
+	tests := []struct {
+		name     string
+		remove   int
+		ok       bool
+		restart  bool
+		clients  []int // the remaining clients, by order of ID
+		trustees []int
+	}{
+		{"client", client2, true, true, []int{client0, client1, client3}, []int{trustee0, trustee1}},
+		{"last client", client3, true, true, []int{client0, client1, client2}, []int{trustee0, trustee1}},
+		{"trustee", trustee0, true, true, []int{client0, client1, client2, client3}, []int{trustee1}},
+		{"pending client", pending, true, false, []int{client0, client1, client2, client3}, []int{trustee0, trustee1}},
+		{"Client0", client0, false, false, []int{client0, client1, client2, client3}, []int{trustee0, trustee1}},
+		{"unknown node", unknown, false, false, []int{client0, client1, client2, client3}, []int{trustee0, trustee1}},
+	}
+	for _, test := range tests {
+		identities := churnIdentities(8)
+		c, counter := testChurn(t, identities, 2, quorumPolicy{minClients: 1, minTrustees: 1}, epochPolicy{})
+		c.admit(identities[trustee0], protocols.Trustee)
+		c.admit(identities[trustee1], protocols.Trustee)
+		for _, i := range []int{client1, client2, client3} {
+			c.admit(identities[i], protocols.Client)
+		}
+		c.waitQueue.pending = []*waitQueueEntry{{serverID: identities[pending], role: protocols.Client}}
+		counter.running = true
+
+		if ok := c.removeNode(identities[test.remove]); ok != test.ok {
+			t.Errorf("%s: got %v, want %v", test.name, ok, test.ok)
+		}
+		if restarted := counter.starts > 0; restarted != test.restart {
+			t.Errorf("%s: got restart %v, want %v", test.name, restarted, test.restart)
+		}
+		ID := idFromServerIdentity(identities[test.remove])
+		if test.ok && (c.waitQueue.contains(ID, false) || c.waitQueue.contains(ID, true)) {
+			t.Errorf("%s: the node is still waiting", test.name)
+		}
+
+		//the remaining nodes keep their order, with contiguous IDs
+		check := func(role string, entries map[string]*waitQueueEntry, want []int, next int) {
+			if len(entries) != len(want) || next != len(want) {
+				t.Errorf("%s: got %d %ss and next ID %d, want %d", test.name, len(entries), role, next, len(want))
+				return
+			}
+			for id, i := range want {
+				if e := entries[idFromServerIdentity(identities[i])]; e == nil || e.numericID != id {
+					t.Errorf("%s: %s %d is not in its place", test.name, role, i)
+				}
+			}
+		}
+		check("client", c.waitQueue.clients, test.clients, c.nextFreeClientID)
+		check("trustee", c.waitQueue.trustees, test.trustees, c.nextFreeTrusteeID)
+	}
+}
+
+func TestNewQuorumPolicy(t *testing.T) {
+	tests := []struct {
+		minClients  int
+		minTrustees int
+		want        quorumPolicy
+	}{
+		{2, 3, quorumPolicy{minClients: 2, minTrustees: 3, gracePeriod: time.Second, maxRestartsPerMinute: 10}},
+		{0, 0, quorumPolicy{minClients: 1, minTrustees: 1, gracePeriod: time.Second, maxRestartsPerMinute: 10}},
+		{-1, 1, quorumPolicy{minClients: 1, minTrustees: 1, gracePeriod: time.Second, maxRestartsPerMinute: 10}},
+	}
+	for _, test := range tests {
+		toml := &protocols.DissentTomlConfig{
+			QuorumMinClients:           test.minClients,
+			QuorumMinTrustees:          test.minTrustees,
+			QuorumGracePeriod:          1000,
+			QuorumMaxRestartsPerMinute: 10,
+		}
+		if q := newQuorumPolicy(toml); q != test.want {
+			t.Errorf("%d clients and %d trustees: got %+v, want %+v", test.minClients, test.minTrustees, q, test.want)
+		}
+	}
+}
+
+func TestHasQuorum(t *testing.T) {
+	tests := []struct {
+		name      string
+		quorum    quorumPolicy
+		nClients  int // Client0 included
+		nTrustees int
+		want      bool
+	}{
+		{"minimum reached", quorumPolicy{minClients: 3, minTrustees: 2}, 3, 2, true},
+		{"more than the minimum", quorumPolicy{minClients: 3, minTrustees: 2}, 5, 3, true},
+		{"too few clients", quorumPolicy{minClients: 3, minTrustees: 2}, 2, 3, false},
+		{"too few trustees", quorumPolicy{minClients: 3, minTrustees: 2}, 3, 1, false},
+		{"all trustees", quorumPolicy{minClients: 1, minTrustees: 1, allTrustees: true}, 1, 4, true},
+		{"not all trustees", quorumPolicy{minClients: 1, minTrustees: 1, allTrustees: true}, 1, 3, false},
+	}
+	for _, test := range tests {
+		c := &churnHandler{
+			quorum:      test.quorum,
+			trusteesIDs: make([]*network.ServerIdentity, 4),
+			waitQueue: &waitQueue{
+				clients:  make(map[string]*waitQueueEntry),
+				trustees: make(map[string]*waitQueueEntry),
+			},
+		}
+		for i := 0; i < test.nClients; i++ {
+			c.waitQueue.clients["client"+strconv.Itoa(i)] = &waitQueueEntry{numericID: i}
+		}
+		for i := 0; i < test.nTrustees; i++ {
+			c.waitQueue.trustees["trustee"+strconv.Itoa(i)] = &waitQueueEntry{numericID: i}
+		}
+		if got := c.hasQuorum(); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	now := time.Now()
+	ago := func(seconds ...int) []time.Time {
+		res := make([]time.Time, len(seconds))
+		for i, s := range seconds {
+			res[i] = now.Add(-time.Duration(s) * time.Second)
+		}
+		return res
+	}
+	tests := []struct {
+		name     string
+		max      int
+		restarts []time.Time
+		want     time.Duration
+		kept     int
+	}{
+		{"no limit", 0, ago(50, 40, 30, 20, 10), 0, 5},
+		{"below the limit", 3, ago(50, 10), 0, 2},
+		{"at the limit", 3, ago(50, 40, 10), 10 * time.Second, 3},
+		{"above the limit", 2, ago(50, 40, 10), 20 * time.Second, 3},
+		{"old restarts", 2, ago(90, 70, 10), 0, 1},
+	}
+	for _, test := range tests {
+		c := &churnHandler{quorum: quorumPolicy{maxRestartsPerMinute: test.max}, restarts: test.restarts}
+		if got := c.restartDelay(now); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+		if len(c.restarts) != test.kept {
+			t.Errorf("%s: kept %d restarts, want %d", test.name, len(c.restarts), test.kept)
+		}
+	}
+}
+
+func TestHandleConnectionEpochs(t *testing.T) {
+	tests := []struct {
+		name        string
+		epoch       epochPolicy
+		running     bool
+		connects    []int // the clients which connect, in this order
+		wantPending int
+		wantClients int // Client0 included
+		wantStarts  int
+	}{
+		{"no epochs", epochPolicy{}, true, []int{0, 1, 2}, 0, 4, 3},
+		{"not running", epochPolicy{duration: time.Minute}, false, []int{0, 1, 2}, 2, 2, 1},
+		{"running", epochPolicy{duration: time.Minute}, true, []int{0, 1, 2}, 3, 1, 0},
+		{"reconnection of a pending client", epochPolicy{duration: time.Minute}, true, []int{0, 1, 0}, 2, 1, 0},
+		{"enough pending clients", epochPolicy{duration: time.Minute, maxPending: 2}, true, []int{0, 1, 2}, 1, 3, 1},
+	}
+	for _, test := range tests {
+		// identities: Client0, a trustee, 3 clients
+		identities := churnIdentities(5)
+		c, counter := testChurn(t, identities, 1, quorumPolicy{minClients: 1, minTrustees: 1}, test.epoch)
+		c.admit(identities[1], protocols.Trustee)
+		counter.running = test.running
+
+		for _, i := range test.connects {
+			msg := &network.Envelope{ServerIdentity: identities[2+i], Msg: &ConnectionRequest{}}
+			if err := c.handleConnection(msg); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		nClients, _ := c.waitQueue.count()
+		if len(c.waitQueue.pending) != test.wantPending || nClients != test.wantClients || counter.starts != test.wantStarts {
+			t.Errorf("%s: got %d pending, %d clients and %d starts, want %d, %d and %d", test.name,
+				len(c.waitQueue.pending), nClients, counter.starts, test.wantPending, test.wantClients, test.wantStarts)
+		}
+	}
+}
+
+func TestAdmitPending(t *testing.T) {
+	identities := churnIdentities(6)
+	c, _ := testChurn(t, identities, 1, quorumPolicy{}, epochPolicy{})
+	c.admit(identities[2], protocols.Client)
+
+	//the pending nodes are admitted by order of arrival, after the waiting ones
+	order := []struct {
+		identity  int
+		role      protocols.DissentRole
+		numericID int
+	}{
+		{4, protocols.Client, 2},
+		{1, protocols.Trustee, 0},
+		{3, protocols.Client, 3},
+		{5, protocols.Client, 4},
+	}
+	for _, o := range order {
+		c.waitQueue.pending = append(c.waitQueue.pending, &waitQueueEntry{serverID: identities[o.identity], role: o.role})
+	}
+	c.admitPending()
+
+	if len(c.waitQueue.pending) != 0 {
+		t.Errorf("got %d nodes still pending", len(c.waitQueue.pending))
+	}
+	for _, o := range order {
+		entries := c.waitQueue.clients
+		if o.role == protocols.Trustee {
+			entries = c.waitQueue.trustees
+		}
+		if e := entries[idFromServerIdentity(identities[o.identity])]; e == nil || e.numericID != o.numericID {
+			t.Errorf("node %d was not admitted with ID %d", o.identity, o.numericID)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	// identities: the relay, 2 trustees, a listed client, a member without role, an outsider, an excluded client
+	identities := churnIdentities(7)
+	members := []*network.ServerIdentity{identities[0], identities[1], identities[2], identities[3], identities[4], identities[6]}
+	group := &app.Group{
+		Roster: onet.NewRoster(members),
+		Description: map[*network.ServerIdentity]string{
+			identities[0]: "relay",
+			identities[1]: "trustee",
+			identities[2]: "trustee",
+			identities[3]: "client",
+			identities[6]: "client",
+		},
+	}
+	relay, trustees := mapIdentities(group)
+
+	tests := []struct {
+		name          string
+		identity      int
+		claimsTrustee bool
+		allowlist     bool
+		ok            bool
+	}{
+		{"trustee", 1, true, false, true},
+		{"trustee as a client", 2, false, false, false},
+		{"listed client", 3, false, false, true},
+		{"listed client claiming to be a trustee", 3, true, false, false},
+		{"member as a client", 4, false, false, true},
+		{"member as a client, allowlist", 4, false, true, false},
+		{"listed client, allowlist", 3, false, true, true},
+		{"outsider", 5, false, false, false},
+		{"outsider claiming to be a trustee", 5, true, false, false},
+		{"excluded client", 6, false, false, false},
+		{"relay", 0, false, false, false},
+	}
+	for _, test := range tests {
+		c := &churnHandler{}
+		if err := c.init(relay, trustees, quorumPolicy{}, epochPolicy{}); err != nil {
+			t.Fatal(err)
+		}
+		c.members = groupMembers(group)
+		if test.allowlist {
+			c.allowedClients = listedClients(group)
+		}
+		c.excluded[idFromServerIdentity(identities[6])] = true
+
+		err := c.authorize(identities[test.identity], test.claimsTrustee)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v, want success %v", test.name, err, test.ok)
+		}
+	}
+}
@@ -62,6 +62,27 @@ func mapIdentities(group *app.Group) (*network.ServerIdentity, []*network.Server
 	return relay, trustees
 }
 
+// groupMembers returns the identities of every node of the group configuration
+func groupMembers(group *app.Group) map[string]bool {
+	members := make(map[string]bool)
+	for _, si := range group.Roster.List {
+		members[idFromServerIdentity(si)] = true
+	}
+	return members
+}
+
+// listedClients reads the group configuration to find the clients allowed to join,
+// i.e., the nodes whose description is "client"
+func listedClients(group *app.Group) map[string]bool {
+	clients := make(map[string]bool)
+	for _, si := range group.Roster.List {
+		if group.GetDescription(si) == "client" {
+			clients[idFromServerIdentity(si)] = true
+		}
+	}
+	return clients
+}
+
 func (s *ServiceState) setConfigToDissentProtocol(wrapper *dissent_protocol.DissentProtocol) {
 
 	//normal nodes only needs the relay in their identity map
@@ -81,6 +102,14 @@ func (s *ServiceState) setConfigToDissentProtocol(wrapper *dissent_protocol.Diss
 		Identities: identitiesMap,
 		Role:       s.role,
 	}
+	if s.role == dissent_protocol.Client0 {
+		configMsg.BlameHandler = s.handleDisruptor
+		configMsg.TimeoutHandler = s.handleTimeout
+	}
 
 	wrapper.SetConfigFromDissentService(configMsg)
+	if s.role != dissent_protocol.Trustee {
+		wrapper.SetOutputHandler(s.handleOutput)
+		wrapper.SetMessageHandler(s.handleMessage)
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/dedis/prifi/utils"
 	"gopkg.in/dedis/onet.v2/log"
 	"gopkg.in/dedis/onet.v2/network"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +22,14 @@ type ConnectionRequest struct {
 	ProtocolVersion string
 }
 
+// ConnectionRejected messages are sent by the relay to
+// nodes whose ConnectionRequest was refused. ExpectedVersion
+// is set if the ProtocolVersion of the node differs.
+type ConnectionRejected struct {
+	Reason          string
+	ExpectedVersion string
+}
+
 // HelloMsg messages are sent by the relay to the trustee;
 // if they are up, they answer with a ConnectionRequest
 type HelloMsg struct{}
@@ -43,6 +52,14 @@ func (s *ServiceState) IsDissentProtocolRunning() bool {
 	return false
 }
 
+// ProtocolState returns the phase of the running Dissent protocol, or StateStopped if none is running
+func (s *ServiceState) ProtocolState() dissent_protocol.ProtocolState {
+	if s.DissentProtocol == nil {
+		return dissent_protocol.StateStopped
+	}
+	return s.DissentProtocol.State()
+}
+
 // Packet send by relay; when we get it, we stop the protocol
 func (s *ServiceState) HandleStop(msg *network.Envelope) {
 	log.Lvl1("Received a Handle Stop (I'm ", s.role, ")")
@@ -69,31 +86,85 @@ func (s *ServiceState) HandleHelloMsg(msg *network.Envelope) {
 // Packet received by relay when some node connects
 func (s *ServiceState) HandleConnection(msg *network.Envelope) {
 	if s.churnHandler == nil {
-		log.Fatal("Can't handle a connection without a churnHandler")
+		log.Error("Can't handle a connection without a churnHandler, ignoring.")
+		return
+	}
+
+	request := msg.Msg.(*ConnectionRequest)
+	if rejection := s.checkVersion(request); rejection != nil {
+		log.Error("Rejected the connection of", msg.ServerIdentity, ": ProtocolVersion", request.ProtocolVersion, "instead of", s.dissentTomlConfig.ProtocolVersion)
+		if err := s.SendRaw(msg.ServerIdentity, rejection); err != nil {
+			log.Lvl3("Could not tell", msg.ServerIdentity, "that it was rejected:", err)
+		}
+		return
 	}
 
-	if s.dissentTomlConfig.ProtocolVersion != msg.Msg.(*ConnectionRequest).ProtocolVersion {
-		log.Fatal("Different CommitID between relay and ", msg.ServerIdentity.String())
+	if err := s.churnHandler.handleConnection(msg); err != nil {
+		log.Lvl2("Rejected the connection of", msg.ServerIdentity, ":", err)
+		if err := s.SendRaw(msg.ServerIdentity, &ConnectionRejected{Reason: err.Error()}); err != nil {
+			log.Lvl3("Could not tell", msg.ServerIdentity, "that it was rejected:", err)
+		}
 	}
+}
 
-	s.churnHandler.handleConnection(msg)
+// checkVersion returns the rejection of a ConnectionRequest with another ProtocolVersion than ours, or nil
+func (s *ServiceState) checkVersion(request *ConnectionRequest) *ConnectionRejected {
+	if request.ProtocolVersion == s.dissentTomlConfig.ProtocolVersion {
+		return nil
+	}
+	return &ConnectionRejected{
+		Reason:          "different ProtocolVersion",
+		ExpectedVersion: s.dissentTomlConfig.ProtocolVersion,
+	}
+}
+
+// Packet send by relay when our ConnectionRequest was refused; we stop retrying
+func (s *ServiceState) HandleConnectionRejected(msg *network.Envelope) {
+	if s.relayIdentity == nil || !msg.ServerIdentity.Equal(s.relayIdentity) {
+		log.Error("Received a ConnectionRejected from", msg.ServerIdentity, ", which is not the relay ! ignoring.")
+		return
+	}
+	rejection := msg.Msg.(*ConnectionRejected)
+	if rejection.ExpectedVersion != "" {
+		log.Error("The relay rejected our connection: our ProtocolVersion is", s.dissentTomlConfig.ProtocolVersion,
+			"but the relay expects", rejection.ExpectedVersion, ". Not retrying.")
+	} else {
+		log.Error("The relay rejected our connection:", rejection.Reason, ". Not retrying.")
+	}
+	atomic.StoreInt32(&s.rejected, 1)
 }
 
 // Packet send by relay when some node disconnected
 func (s *ServiceState) HandleDisconnection(msg *network.Envelope) {
 	if s.churnHandler == nil {
-		log.Fatal("Can't handle a disconnection without a churnHandler")
+		log.Error("Can't handle a disconnection without a churnHandler, ignoring.")
+		return
 	}
 	s.churnHandler.handleDisconnection(msg)
 }
 
-// handleTimeout is a callback that should be called on the relay
-// when a round times out. It tries to restart PriFi with the nodes
-// that sent their ciphertext in time.
-func (s *ServiceState) handleTimeout(lateClients []string, lateTrustees []string) {
+// handleTimeout is a callback that is called on the relay when a
+// round times out. The late nodes are removed from the waiting nodes
+// and told to stop; if resync is true, the protocol restarts with the
+// nodes that sent their ciphertext in time, otherwise it continues
+// without the late clients.
+func (s *ServiceState) handleTimeout(lateClients []string, lateTrustees []string, resync bool) {
+	log.Error("A round timed out, removing late clients", lateClients, "and late trustees", lateTrustees, "(resync:", resync, ")")
+
+	lateNodes := s.churnHandler.removeLateNodes(append(lateClients, lateTrustees...), resync)
+	for _, si := range lateNodes {
+		if err := s.SendRaw(si, &StopProtocol{}); err != nil {
+			log.Lvl3("Could not tell late node", si, "to stop:", err)
+		}
+	}
+}
 
-	// we can probably do something more clever here, since we know who disconnected. Yet let's just restart everything
-	s.NetworkErrorHappened(nil)
+// handleDisruptor is a callback that is called on the relay when
+// the blame protocol identified a disruptor. The disruptor is excluded,
+// and the protocol restarts without it.
+func (s *ServiceState) handleDisruptor(disruptor *network.ServerIdentity) {
+	log.Error("Node", disruptor, "was identified as a disruptor, excluding it.")
+	s.churnHandler.excludeNode(disruptor)
 }
 
 // This is a handler passed to the SDA when starting a host. The SDA usually handle all the network by itself,
@@ -107,18 +178,21 @@ func (s *ServiceState) NetworkErrorHappened(si *network.ServerIdentity) {
 		return
 	}
 	if s.churnHandler == nil {
-		log.Fatal("Can't handle a network error without a churnHandler")
+		log.Error("Can't handle a network error without a churnHandler, ignoring.")
+		return
 	}
 
-	log.Error("A network error occurred with node", si, ", warning other clients.")
-	s.churnHandler.handleUnknownDisconnection()
+	if !s.churnHandler.removeNode(si) {
+		log.Lvl3("A network error occurred with node", si, ", which is not participating, nothing to do.")
+		return
+	}
+	log.Error("A network error occurred with node", si, ", it was removed and the protocol restarted without it.")
 }
 
-// HasEnoughParticipants returns true iff
-// nTrustees >= 1 & nClients >= 1
+// HasEnoughParticipants returns true iff the connected nodes
+// satisfy the quorum policy of dissent.toml
 func (s *ServiceState) HasEnoughParticipants() bool {
-	t, c := s.churnHandler.CountParticipants()
-	return (t >= 1) && (c >= 1)
+	return s.churnHandler.hasQuorum()
 }
 
 // CountParticipants returns ntrustees, nclients already connected
@@ -149,7 +223,8 @@ func (s *ServiceState) StartPriFiCommunicateProtocol() {
 	pi, err := s.CreateProtocol(dissent_protocol.ProtocolName, tree)
 
 	if err != nil {
-		log.Fatal("Unable to start Prifi protocol:", err)
+		log.Error("Unable to start Prifi protocol:", err)
+		return
 	}
 
 	// Assert that pi has type PriFiSDAWrapper
@@ -176,6 +251,11 @@ func (s *ServiceState) StopDissentProtocol() {
 		s.DissentProtocol.Stop()
 	}
 	s.DissentProtocol = nil
+
+	//the next run shuffles the slots again, and the streams are bound to the slots
+	if s.socksEgress != nil {
+		s.socksEgress.closeStreams()
+	}
 }
 
 // TODO : change function comment
@@ -213,6 +293,10 @@ func (s *ServiceState) connectToRelay(relayID *network.ServerIdentity, stopChan
 	tick := time.Tick(DELAY_BEFORE_CONNECT_TO_RELAY)
 	for range tick {
 		//log.Info("Service", s, ": Still pinging relay", !s.IsDissentProtocolRunning())
+		if atomic.LoadInt32(&s.rejected) == 1 {
+			log.Lvl3("Stopping connectToRelay subroutine, the relay rejected us.")
+			return
+		}
 		if !s.IsDissentProtocolRunning() {
 			s.sendConnectionRequest(relayID)
 		}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"sync/atomic"
+	"testing"
+
+	dissent_protocol "github.com/lbarman/dissent-go/protocols"
+	"gopkg.in/dedis/onet.v2/network"
+)
+
+func TestCheckVersion(t *testing.T) {
+	s := &ServiceState{dissentTomlConfig: &dissent_protocol.DissentTomlConfig{ProtocolVersion: "v2"}}
+	tests := []struct {
+		version string
+		ok      bool
+	}{
+		{"v2", true},
+		{"v1", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		rejection := s.checkVersion(&ConnectionRequest{ProtocolVersion: test.version})
+		if (rejection == nil) != test.ok {
+			t.Errorf("%q: got rejection %v, want success %v", test.version, rejection, test.ok)
+			continue
+		}
+		if rejection != nil && rejection.ExpectedVersion != "v2" {
+			t.Errorf("%q: got expected version %q", test.version, rejection.ExpectedVersion)
+		}
+	}
+}
+
+func TestHandleConnectionRejected(t *testing.T) {
+	// identities: the relay, another node
+	identities := churnIdentities(2)
+	tests := []struct {
+		name      string
+		relay     *network.ServerIdentity
+		sender    *network.ServerIdentity
+		rejection *ConnectionRejected
+		stop      bool
+	}{
+		{"different version", identities[0], identities[0], &ConnectionRejected{Reason: "different ProtocolVersion", ExpectedVersion: "v2"}, true},
+		{"unknown identity", identities[0], identities[0], &ConnectionRejected{Reason: "unknown identity"}, true},
+		{"not from the relay", identities[0], identities[1], &ConnectionRejected{Reason: "unknown identity"}, false},
+		{"relay not known yet", nil, identities[0], &ConnectionRejected{Reason: "unknown identity"}, false},
+	}
+	for _, test := range tests {
+		s := &ServiceState{
+			dissentTomlConfig: &dissent_protocol.DissentTomlConfig{ProtocolVersion: "v1"},
+			relayIdentity:     test.relay,
+		}
+		s.HandleConnectionRejected(&network.Envelope{ServerIdentity: test.sender, Msg: test.rejection})
+		if stopped := atomic.LoadInt32(&s.rejected) == 1; stopped != test.stop {
+			t.Errorf("%s: got stop %v, want %v", test.name, stopped, test.stop)
+		}
+	}
+}
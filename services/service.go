@@ -42,6 +42,7 @@ type ServiceState struct {
 	relayIdentity     *network.ServerIdentity
 	trusteeIDs        []*network.ServerIdentity
 	receivedHello     bool
+	rejected          int32 // set atomically to 1 once the relay sent us a ConnectionRejected, we stop trying to connect
 
 	connectToRelayStopChan    chan bool //spawned at init
 	connectToRelay2StopChan   chan bool //spawned after receiving a HELLO message
@@ -56,6 +57,16 @@ type ServiceState struct {
 
 	//this hold the running protocol (when it runs)
 	DissentProtocol *dissent_protocol.DissentProtocol
+
+	//the SOCKS5 server of a client, and the exit of Client0
+	socksIngress *socksProxy
+	socksEgress  *socksProxy
+
+	//the local API of a client, nil unless ClientAPIPort is set
+	clientAPI *clientAPI
+
+	//the bulletin board of a client, nil unless BoardLogFile is set
+	board *board
 }
 
 // Storage will be saved, on the contrary of the 'Service'-structure
@@ -75,11 +86,15 @@ func newService(c *onet.Context) (onet.Service, error) {
 	stopMsg := network.RegisterMessage(StopProtocol{})
 	connMsg := network.RegisterMessage(ConnectionRequest{})
 	disconnectMsg := network.RegisterMessage(DisconnectionRequest{})
+	rejectedMsg := network.RegisterMessage(ConnectionRejected{})
+	stopSocksMsg := network.RegisterMessage(StopSOCKS{})
 
 	c.RegisterProcessorFunc(helloMsg, s.HandleHelloMsg)
 	c.RegisterProcessorFunc(stopMsg, s.HandleStop)
 	c.RegisterProcessorFunc(connMsg, s.HandleConnection)
 	c.RegisterProcessorFunc(disconnectMsg, s.HandleDisconnection)
+	c.RegisterProcessorFunc(rejectedMsg, s.HandleConnectionRejected)
+	c.RegisterProcessorFunc(stopSocksMsg, s.HandleStopSOCKS)
 
 	if err := s.tryLoad(); err != nil {
 		log.Fatal(err)
@@ -102,6 +117,11 @@ func (s *ServiceState) NewProtocol(tn *onet.TreeNodeInstance, conf *onet.Generic
 		return nil, err
 	}
 
+	//after a resync, the previous instance is still running (e.g., a trustee streaming its ciphertexts)
+	if s.DissentProtocol != nil {
+		s.DissentProtocol.Stop()
+	}
+
 	wrapper := pi.(*dissent_protocol.DissentProtocol)
 	s.setConfigToDissentProtocol(wrapper)
 	s.DissentProtocol = wrapper
@@ -131,7 +151,9 @@ func (s *ServiceState) StartClient0(group *app.Group) error {
 
 	//creates the ChurnHandler, part of the Client0's Service, that will start/stop the protocol
 	s.churnHandler = new(churnHandler)
-	s.churnHandler.init(relayID, trusteesIDs)
+	if err := s.churnHandler.init(relayID, trusteesIDs, newQuorumPolicy(s.dissentTomlConfig), newEpochPolicy(s.dissentTomlConfig)); err != nil {
+		return err
+	}
 	s.churnHandler.isProtocolRunning = s.IsDissentProtocolRunning
 	if s.AutoStart || true {
 		s.churnHandler.startProtocol = s.StartPriFiCommunicateProtocol
@@ -139,10 +161,20 @@ func (s *ServiceState) StartClient0(group *app.Group) error {
 		s.churnHandler.startProtocol = nil
 	}
 	s.churnHandler.stopProtocol = s.StopDissentProtocol
+	s.churnHandler.members = groupMembers(group)
+	if s.dissentTomlConfig.ClientAllowlistEnabled {
+		s.churnHandler.allowedClients = listedClients(group)
+		log.Lvl1("Only the", len(s.churnHandler.allowedClients), "clients of the group file are allowed to join")
+	}
+	go s.churnHandler.runEpochs()
 
 	s.connectToTrusteesStopChan = make(chan bool)
 	go s.connectToTrustees(trusteesIDs, s.connectToTrusteesStopChan)
 
+	s.startSocks()
+	s.startBoard()
+	s.startClientAPI()
+
 	return nil
 }
 
@@ -158,6 +190,10 @@ func (s *ServiceState) StartClient(group *app.Group, delay time.Duration) error
 	s.connectToRelayStopChan = make(chan bool)
 	s.trusteeIDs = trusteeIDs
 
+	s.startSocks()
+	s.startBoard()
+	s.startClientAPI()
+
 	go func() {
 		if delay > 0 {
 			log.Lvl1("Client sleeping for", (delay * time.Second))
@@ -0,0 +1,611 @@
+package services
+
+// This file contains the SOCKS5 proxy running on top of Dissent, enabled by SocksEnabled.
+//
+// Each client runs a SOCKS5 server on 127.0.0.1:SocksClientPort (Client0 on SocksServerPort, so that
+// both can run on the same host). The TCP streams it accepts are multiplexed into the anonymous slot
+// of the client, as frames tagged with a random stream ID. Client0 is the exit: it opens the real
+// connections, and sends the data it receives back in the downstream cells, which every client
+// receives; each client keeps the frames of its own streams. The stream IDs are visible in the
+// downstream cells, so the exit binds each stream to the slot which opened it, and drops the frames
+// of that stream coming from any other slot.
+//
+// The SOCKS server replies to a CONNECT as soon as the request is sent upstream; if Client0 cannot
+// open the connection, the stream is closed. Unless SocksExitAllowLocal is set, the exit refuses the
+// loopback, link-local, unspecified and private addresses (RFC 1918, unique local, carrier-grade
+// NAT), which would reach the services of Client0's host or of its network;
+// the check is done on the resolved address, so a hostname cannot point there either. The slots
+// are shuffled again when the protocol restarts, so the exit then closes all streams; it answers
+// the data of a stream it does not know with a close frame, which closes the stream of the client.
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	dissent_protocol "github.com/lbarman/dissent-go/protocols"
+	"gopkg.in/dedis/onet.v2/log"
+	"gopkg.in/dedis/onet.v2/network"
+)
+
+// The kind of a frame; the zero byte marks the padding at the end of a slot
+const (
+	socksFramePadding byte = iota
+	socksFrameOpen
+	socksFrameData
+	socksFrameClose
+)
+
+const (
+	// socksFrameHeaderSize is the size of the header of a frame: stream ID, kind, length
+	socksFrameHeaderSize = 8 + 1 + 2
+	// socksMaxQueued is the number of payloads queued in the protocol before the proxy stops reading its connections
+	socksMaxQueued = 32
+	// socksPollTime is the time the proxy waits when the protocol cannot take more data
+	socksPollTime = 10 * time.Millisecond
+	// socksStreamBuffer is the number of frames buffered for a stream before it is closed
+	socksStreamBuffer = 256
+	// socksDialTimeout is the time Client0 waits for a connection to open
+	socksDialTimeout = 10 * time.Second
+)
+
+// socksFrame is the unit multiplexed in the slots and downstream cells
+type socksFrame struct {
+	stream uint64
+	kind   byte
+	data   []byte
+}
+
+func encodeFrame(f socksFrame) []byte {
+	buf := make([]byte, socksFrameHeaderSize+len(f.data))
+	binary.BigEndian.PutUint64(buf[0:8], f.stream)
+	buf[8] = f.kind
+	binary.BigEndian.PutUint16(buf[9:11], uint16(len(f.data)))
+	copy(buf[socksFrameHeaderSize:], f.data)
+	return buf
+}
+
+// decodeFrames returns the frames contained in a payload, up to the padding
+func decodeFrames(payload []byte) []socksFrame {
+	frames := make([]socksFrame, 0)
+	for len(payload) >= socksFrameHeaderSize {
+		f := socksFrame{
+			stream: binary.BigEndian.Uint64(payload[0:8]),
+			kind:   payload[8],
+		}
+		length := int(binary.BigEndian.Uint16(payload[9:11]))
+		if f.kind == socksFramePadding || socksFrameHeaderSize+length > len(payload) {
+			break
+		}
+		f.data = payload[socksFrameHeaderSize : socksFrameHeaderSize+length]
+		frames = append(frames, f)
+		payload = payload[socksFrameHeaderSize+length:]
+	}
+	return frames
+}
+
+// socksStream is one proxied TCP connection
+type socksStream struct {
+	conn   net.Conn
+	writes chan []byte
+	slot   int // the slot which opened the stream, only used by the exit
+}
+
+// socksProxy is one end of the proxy: the SOCKS server of a client, or the exit on Client0
+type socksProxy struct {
+	sync.Mutex
+	isExit     bool
+	streams    map[uint64]*socksStream
+	opening    map[uint64]int                  // the streams being opened by the exit, and the slot which opened them
+	queue      func(data []byte) (bool, error) // queues a payload in the protocol, or returns false if it cannot yet
+	maxData    int                             // the maximum size of the data of a frame
+	listener   net.Listener
+	verbose    bool
+	allowLocal bool // the exit may open connections to the local addresses
+	stopped    bool
+}
+
+// newSocksIngress starts the SOCKS5 server of a client
+func newSocksIngress(port int, maxPayload int, queue func([]byte) (bool, error), verbose bool) (*socksProxy, error) {
+	p := newSocksProxy(false, maxPayload, queue, verbose)
+	listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		return nil, err
+	}
+	p.listener = listener
+	log.Lvl1("SOCKS5 server listening on", listener.Addr())
+
+	go p.accept()
+	return p, nil
+}
+
+// newSocksEgress creates the exit of Client0
+func newSocksEgress(maxPayload int, queue func([]byte) (bool, error), verbose bool, allowLocal bool) *socksProxy {
+	p := newSocksProxy(true, maxPayload, queue, verbose)
+	p.allowLocal = allowLocal
+	return p
+}
+
+func newSocksProxy(isExit bool, maxPayload int, queue func([]byte) (bool, error), verbose bool) *socksProxy {
+	return &socksProxy{
+		isExit:  isExit,
+		streams: make(map[uint64]*socksStream),
+		opening: make(map[uint64]int),
+		queue:   queue,
+		maxData: maxPayload - socksFrameHeaderSize,
+		verbose: verbose,
+	}
+}
+
+// logStream logs the life of the streams, at level 1 if VerboseIngressEgressServers is set
+func (p *socksProxy) logStream(args ...interface{}) {
+	if p.verbose {
+		log.Lvl1(args...)
+	} else {
+		log.Lvl3(args...)
+	}
+}
+
+// stop closes the SOCKS server and all streams
+func (p *socksProxy) stop() {
+	p.Lock()
+	p.stopped = true
+	streams := p.streams
+	p.streams = make(map[uint64]*socksStream)
+	p.Unlock()
+
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	for _, s := range streams {
+		close(s.writes)
+	}
+}
+
+// closeStreams closes all streams without telling the other end, when the protocol stops
+func (p *socksProxy) closeStreams() {
+	p.Lock()
+	streams := p.streams
+	p.streams = make(map[uint64]*socksStream)
+	p.Unlock()
+
+	for _, s := range streams {
+		close(s.writes)
+	}
+}
+
+func (p *socksProxy) isStopped() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.stopped
+}
+
+// send queues a frame in the protocol, waiting while the protocol cannot take more data
+func (p *socksProxy) send(f socksFrame) error {
+	data := encodeFrame(f)
+	for !p.isStopped() {
+		queued, err := p.queue(data)
+		if err != nil {
+			return err
+		}
+		if queued {
+			return nil
+		}
+		time.Sleep(socksPollTime)
+	}
+	return errors.New("the SOCKS proxy is stopped")
+}
+
+// accept is run by the SOCKS server in its own goroutine
+func (p *socksProxy) accept() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if !p.isStopped() {
+				log.Error("SOCKS5 server stopped:", err)
+			}
+			return
+		}
+		go p.serveSocks(conn)
+	}
+}
+
+// serveSocks reads the SOCKS5 handshake of a connection, and opens a stream to the requested address
+func (p *socksProxy) serveSocks(conn net.Conn) {
+	address, err := socksHandshake(conn)
+	if err != nil {
+		p.logStream("SOCKS5 handshake failed:", err)
+		conn.Close()
+		return
+	}
+
+	id, err := newStreamID()
+	if err != nil {
+		log.Error("Could not create a stream ID:", err)
+		conn.Close()
+		return
+	}
+	p.logStream("SOCKS5 stream", id, "to", address)
+
+	s := p.addStream(id, conn, -1)
+	if s == nil {
+		conn.Close()
+		return
+	}
+	//the connection is opened by Client0; we reply success with an unspecified bound address, before
+	//writing the data received for the stream
+	err = p.send(socksFrame{stream: id, kind: socksFrameOpen, data: []byte(address)})
+	if err == nil {
+		_, err = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	}
+	go p.writeLoop(s)
+	if err != nil {
+		p.closeStream(id)
+		return
+	}
+	p.readLoop(id, conn)
+}
+
+// socksHandshake reads the greeting and the CONNECT request of a SOCKS5 client, and returns the requested address
+func socksHandshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != 5 {
+		return "", errors.New("unsupported SOCKS version " + strconv.Itoa(int(header[0])))
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	noAuth := false
+	for _, m := range methods {
+		if m == 0 {
+			noAuth = true
+		}
+	}
+	if !noAuth {
+		conn.Write([]byte{5, 0xff})
+		return "", errors.New("the client does not support connecting without authentication")
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[1] != 1 {
+		conn.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+		return "", errors.New("unsupported SOCKS command " + strconv.Itoa(int(request[1])))
+	}
+
+	var host string
+	switch request[3] {
+	case 1, 4:
+		ip := make([]byte, 4)
+		if request[3] == 4 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		return "", errors.New("unsupported address type " + strconv.Itoa(int(request[3])))
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// newStreamID returns a random stream ID; the streams of all clients share the downstream cells
+func newStreamID() (uint64, error) {
+	buf := make([]byte, 8)
+	for {
+		if _, err := rand.Read(buf); err != nil {
+			return 0, err
+		}
+		if id := binary.BigEndian.Uint64(buf); id != 0 {
+			return id, nil
+		}
+	}
+}
+
+// addStream registers a stream opened by a slot, and returns nil if the proxy is stopped or the ID is taken
+func (p *socksProxy) addStream(id uint64, conn net.Conn, slot int) *socksStream {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.streams[id]; ok || p.stopped {
+		return nil
+	}
+	s := &socksStream{conn: conn, writes: make(chan []byte, socksStreamBuffer), slot: slot}
+	p.streams[id] = s
+	return s
+}
+
+// removeStream forgets a stream, and closes its connection once the pending data is written; it
+// returns false if the stream was already removed
+func (p *socksProxy) removeStream(id uint64) bool {
+	p.Lock()
+	s, ok := p.streams[id]
+	delete(p.streams, id)
+	p.Unlock()
+
+	if ok {
+		close(s.writes)
+	}
+	return ok
+}
+
+// closeStream removes a stream closed on our side, and tells the other end
+func (p *socksProxy) closeStream(id uint64) {
+	if p.removeStream(id) {
+		p.logStream("SOCKS5 stream", id, "closed")
+		p.send(socksFrame{stream: id, kind: socksFrameClose})
+	}
+}
+
+// readLoop sends the data read from a connection until it is closed
+func (p *socksProxy) readLoop(id uint64, conn net.Conn) {
+	buf := make([]byte, p.maxData)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			data := append([]byte{}, buf[:n]...)
+			if err := p.send(socksFrame{stream: id, kind: socksFrameData, data: data}); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	p.closeStream(id)
+}
+
+// writeLoop writes the data received for a stream, then closes its connection
+func (p *socksProxy) writeLoop(s *socksStream) {
+	for data := range s.writes {
+		if _, err := s.conn.Write(data); err != nil {
+			break
+		}
+	}
+	s.conn.Close()
+	//drain the frames received before the stream was removed
+	for range s.writes {
+	}
+}
+
+// handleFrames is called with each slot received by the exit, and each downstream cell received by a
+// client; slot is the slot of the payload, or -1 for a downstream cell
+func (p *socksProxy) handleFrames(slot int, payload []byte) {
+	for _, f := range decodeFrames(payload) {
+		switch f.kind {
+		case socksFrameOpen:
+			if p.isExit && p.startOpening(f.stream, slot) {
+				go p.dial(f.stream, slot, string(f.data))
+			}
+		case socksFrameData:
+			p.Lock()
+			s, ok := p.streams[f.stream]
+			_, opening := p.opening[f.stream]
+			if ok && s.slot != slot {
+				p.Unlock()
+				log.Lvl2("Dropped a frame of SOCKS5 stream", f.stream, "from slot", slot, ", which did not open it")
+				continue
+			}
+			if ok {
+				select {
+				case s.writes <- append([]byte{}, f.data...):
+				default:
+					ok = false
+				}
+			}
+			p.Unlock()
+			if s != nil && !ok {
+				log.Error("SOCKS5 stream", f.stream, "is too slow, closing it")
+				go p.closeStream(f.stream)
+			}
+			//a stream of the previous run of the protocol
+			if s == nil && p.isExit && !opening {
+				go p.send(socksFrame{stream: f.stream, kind: socksFrameClose})
+			}
+		case socksFrameClose:
+			if !p.ownsStream(f.stream, slot) {
+				log.Lvl2("Dropped a frame of SOCKS5 stream", f.stream, "from slot", slot, ", which did not open it")
+				continue
+			}
+			if p.removeStream(f.stream) {
+				p.logStream("SOCKS5 stream", f.stream, "closed by the other end")
+			}
+		}
+	}
+}
+
+// startOpening binds a new stream to the slot which opened it, and returns false if the ID is taken
+func (p *socksProxy) startOpening(id uint64, slot int) bool {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.streams[id]; ok {
+		return false
+	}
+	if _, ok := p.opening[id]; ok {
+		return false
+	}
+	p.opening[id] = slot
+	return true
+}
+
+// ownsStream returns whether a slot may close a stream; on the clients, the frames come from the exit
+func (p *socksProxy) ownsStream(id uint64, slot int) bool {
+	if !p.isExit {
+		return true
+	}
+	p.Lock()
+	defer p.Unlock()
+	s, ok := p.streams[id]
+	return !ok || s.slot == slot
+}
+
+// dial is called on the exit to open the connection of a new stream
+func (p *socksProxy) dial(id uint64, slot int, address string) {
+	p.logStream("Exit : opening stream", id, "to", address)
+	dialer := &net.Dialer{Timeout: socksDialTimeout}
+	if !p.allowLocal {
+		dialer.Control = refuseLocal
+	}
+	conn, err := dialer.Dial("tcp", address)
+	p.Lock()
+	delete(p.opening, id)
+	p.Unlock()
+	if err != nil {
+		p.logStream("Exit : could not open stream", id, "to", address, ":", err)
+		p.send(socksFrame{stream: id, kind: socksFrameClose})
+		return
+	}
+	s := p.addStream(id, conn, slot)
+	if s == nil {
+		conn.Close()
+		return
+	}
+	go p.writeLoop(s)
+	p.readLoop(id, conn)
+}
+
+// privateNetworks are the ranges which are not reachable from the Internet, besides the loopback and link-local ones
+var privateNetworks = parseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// isLocal returns whether an address reaches Client0's host or its network
+func isLocal(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// refuseLocal is called by the exit with each resolved address before connecting, and refuses the local ones
+func refuseLocal(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errors.New("cannot parse the address " + host)
+	}
+	if isLocal(ip) {
+		return errors.New("the destination " + host + " is local, and SocksExitAllowLocal is not set")
+	}
+	return nil
+}
+
+// startSocks starts the SOCKS5 server of a client, or the exit of Client0
+func (s *ServiceState) startSocks() {
+	toml := s.dissentTomlConfig
+	if !toml.SocksEnabled {
+		return
+	}
+	verbose := toml.VerboseIngressEgressServers
+
+	if s.role == dissent_protocol.Client0 {
+		s.socksEgress = newSocksEgress(toml.CellSizeDown-1, s.queueDownstream, verbose, toml.SocksExitAllowLocal)
+	}
+	port := toml.SocksClientPort
+	if s.role == dissent_protocol.Client0 {
+		port = toml.SocksServerPort
+	}
+	ingress, err := newSocksIngress(port, dissent_protocol.MaxUpstreamPayload(toml)-1, s.queueUpstream, verbose)
+	if err != nil {
+		log.Error("Could not start the SOCKS5 server:", err)
+		return
+	}
+	s.socksIngress = ingress
+}
+
+// roundsRunning returns the protocol if it runs rounds, nil otherwise
+func (s *ServiceState) roundsRunning() *dissent_protocol.DissentProtocol {
+	p := s.DissentProtocol
+	if p == nil {
+		return nil
+	}
+	if state := p.State(); state != dissent_protocol.StateRounds && state != dissent_protocol.StateBlame {
+		return nil
+	}
+	return p
+}
+
+// queueUpstream queues a frame of our SOCKS5 server in our slot
+func (s *ServiceState) queueUpstream(data []byte) (bool, error) {
+	p := s.roundsRunning()
+	if p == nil || p.PendingUpstream() >= socksMaxQueued {
+		return false, nil
+	}
+	return true, p.SendUpstream(append([]byte{payloadSocks}, data...))
+}
+
+// queueDownstream queues a frame of the exit in the downstream cells
+func (s *ServiceState) queueDownstream(data []byte) (bool, error) {
+	p := s.roundsRunning()
+	if p == nil || p.PendingDownstream() >= socksMaxQueued {
+		return false, nil
+	}
+	return true, p.SendDownstream(append([]byte{payloadSocks}, data...))
+}
+
+// HandleStopSOCKS is called when Client0 tells us to stop the SOCKS proxy
+func (s *ServiceState) HandleStopSOCKS(msg *network.Envelope) {
+	fromRelay := s.relayIdentity != nil && msg.ServerIdentity.Equal(s.relayIdentity)
+	if !fromRelay && !msg.ServerIdentity.Equal(s.ServerIdentity()) {
+		log.Error("Received a StopSOCKS from", msg.ServerIdentity, ", which is not the relay ! ignoring.")
+		return
+	}
+	log.Lvl1("Received a StopSOCKS, stopping the SOCKS proxy")
+	if s.socksIngress != nil {
+		s.socksIngress.stop()
+		s.socksIngress = nil
+	}
+	if s.socksEgress != nil {
+		s.socksEgress.stop()
+		s.socksEgress = nil
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRefuseLocal(t *testing.T) {
+	tests := []struct {
+		address string
+		local   bool
+	}{
+		{"93.184.216.34:80", false},
+		{"[2606:2800:220:1::248]:443", false},
+		{"127.0.0.1:22", true},
+		{"[::1]:22", true},
+		{"0.0.0.0:80", true},
+		{"169.254.169.254:80", true},
+		{"[fe80::1]:80", true},
+		{"10.1.2.3:80", true},
+		{"172.16.0.1:80", true},
+		{"172.31.255.255:80", true},
+		{"172.32.0.1:80", false},
+		{"192.168.1.1:80", true},
+		{"100.64.0.1:80", true},
+		{"100.127.255.255:80", true},
+		{"100.128.0.1:80", false},
+		{"[fc00::1]:80", true},
+		{"[fd12:3456::1]:80", true},
+		{"[::ffff:10.0.0.1]:80", true},
+	}
+	for _, test := range tests {
+		err := refuseLocal("tcp", test.address, nil)
+		if (err != nil) != test.local {
+			t.Errorf("%s: got %v, expected local %v", test.address, err, test.local)
+		}
+	}
+	if refuseLocal("tcp", "not an address", nil) == nil {
+		t.Error("an invalid address is accepted")
+	}
+}
+
+func TestFrames(t *testing.T) {
+	frames := []socksFrame{
+		{stream: 1, kind: socksFrameOpen, data: []byte("example.com:80")},
+		{stream: 2, kind: socksFrameData, data: []byte("hello")},
+		{stream: 1, kind: socksFrameClose, data: []byte{}},
+	}
+	payload := make([]byte, 0)
+	for _, f := range frames {
+		payload = append(payload, encodeFrame(f)...)
+	}
+	truncated := encodeFrame(socksFrame{stream: 3, kind: socksFrameData, data: []byte("cut")})
+
+	tests := []struct {
+		name    string
+		payload []byte
+		frames  int
+	}{
+		{"frames", payload, 3},
+		{"padded", append(append([]byte{}, payload...), make([]byte, 20)...), 3},
+		{"truncated", append(append([]byte{}, payload...), truncated[:len(truncated)-1]...), 3},
+		{"empty", []byte{}, 0},
+		{"padding only", make([]byte, 64), 0},
+	}
+	for _, test := range tests {
+		decoded := decodeFrames(test.payload)
+		if len(decoded) != test.frames {
+			t.Errorf("%s: %d frames, expected %d", test.name, len(decoded), test.frames)
+			continue
+		}
+		for k, f := range decoded {
+			if f.stream != frames[k].stream || f.kind != frames[k].kind || string(f.data) != string(frames[k].data) {
+				t.Errorf("%s: frame %d is %+v, expected %+v", test.name, k, f, frames[k])
+			}
+		}
+	}
+}
+
+func TestStreamBinding(t *testing.T) {
+	const owner = 2
+	tests := []struct {
+		name    string
+		slot    int
+		frame   socksFrame
+		written string // what reaches the connection of the stream
+		open    bool   // whether the stream is still open
+		sent    []byte // the kinds of the frames sent back by the exit
+	}{
+		{"data from the owner", owner, socksFrame{stream: 1, kind: socksFrameData, data: []byte("hi")}, "hi", true, nil},
+		{"data from another slot", 3, socksFrame{stream: 1, kind: socksFrameData, data: []byte("hi")}, "", true, nil},
+		{"close from the owner", owner, socksFrame{stream: 1, kind: socksFrameClose}, "", false, nil},
+		{"close from another slot", 3, socksFrame{stream: 1, kind: socksFrameClose}, "", true, nil},
+		{"reopen from another slot", 3, socksFrame{stream: 1, kind: socksFrameOpen, data: []byte("example.com:80")}, "", true, nil},
+		{"data of an unknown stream", 3, socksFrame{stream: 9, kind: socksFrameData, data: []byte("hi")}, "", true, []byte{socksFrameClose}},
+	}
+	for _, test := range tests {
+		sent := make(chan socksFrame, 4)
+		queue := func(data []byte) (bool, error) {
+			sent <- decodeFrames(data)[0]
+			return true, nil
+		}
+		p := newSocksEgress(100, queue, false, false)
+		exitSide, remote := net.Pipe()
+		s := p.addStream(1, exitSide, owner)
+		go p.writeLoop(s)
+
+		p.handleFrames(test.slot, encodeFrame(test.frame))
+
+		if test.written != "" {
+			buf := make([]byte, len(test.written))
+			remote.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(remote, buf); err != nil || string(buf) != test.written {
+				t.Errorf("%s: read %q (%v), expected %q", test.name, buf, err, test.written)
+			}
+		}
+		p.Lock()
+		_, open := p.streams[1]
+		_, opening := p.opening[1]
+		p.Unlock()
+		if open != test.open || opening {
+			t.Errorf("%s: stream open %v and opening %v, expected open %v", test.name, open, opening, test.open)
+		}
+		for _, kind := range test.sent {
+			select {
+			case f := <-sent:
+				if f.kind != kind {
+					t.Errorf("%s: the exit sent a frame of kind %d, expected %d", test.name, f.kind, kind)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("%s: the exit sent no frame", test.name)
+			}
+		}
+		p.stop()
+		remote.Close()
+	}
+}
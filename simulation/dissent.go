@@ -11,6 +11,7 @@ import (
 	"gopkg.in/dedis/onet.v2/app"
 	"gopkg.in/dedis/onet.v2/log"
 	"gopkg.in/dedis/onet.v2/network"
+	"gopkg.in/dedis/onet.v2/simul/monitor"
 	"io/ioutil"
 	"os"
 	"path"
@@ -207,6 +208,11 @@ func (s *SimulationService) Run(config *onet.SimulationConfig) error {
 		time.Sleep(10 * time.Millisecond)
 	}
 
+	//record the latency tests while the experiment runs
+	if s.DoLatencyTests {
+		go recordLatencies(service.DissentProtocol.LatencyChannel)
+	}
+
 	//block and get the result from the channel
 	var resStringArray []string
 
@@ -219,11 +225,20 @@ func (s *SimulationService) Run(config *onet.SimulationConfig) error {
 		}
 	}
 
+	//the replay has no result before it is over
+	var replayChannel chan []dissent_protocol.ReplayedPacket
+	if s.ReplayPCAP {
+		replayChannel = service.DissentProtocol.ReplayChannel
+	}
+
 	log.Lvl1("Giving the experiment", SIMULATION_ROUND_TIMEOUT_SECONDS, "seconds to finish before aborting...")
 	select {
 	case res := <-service.DissentProtocol.ResultChannel:
 		resStringArray = res.([]string)
 
+	case packets := <-replayChannel:
+		resStringArray = recordReplay(packets)
+
 	case <-time.After(time.Duration(SIMULATION_ROUND_TIMEOUT_SECONDS) * time.Second):
 		resStringArray = make([]string, 1)
 		resStringArray[0] = "<shutdown from simul> simulation timed out"
@@ -248,6 +263,34 @@ func (s *SimulationService) Run(config *onet.SimulationConfig) error {
 	return nil
 }
 
+// recordLatencies writes the percentiles of each latency report to the monitor
+func recordLatencies(reports chan []dissent_protocol.LatencyReport) {
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	for report := range reports {
+		for _, r := range report {
+			name := "latency_" + strings.Replace(r.Name, " ", "_", -1)
+			monitor.RecordSingleMeasure(name+"_min", ms(r.Min))
+			monitor.RecordSingleMeasure(name+"_p50", ms(r.P50))
+			monitor.RecordSingleMeasure(name+"_p90", ms(r.P90))
+			monitor.RecordSingleMeasure(name+"_p99", ms(r.P99))
+			monitor.RecordSingleMeasure(name+"_max", ms(r.Max))
+			log.Lvl1(r.String())
+		}
+	}
+}
+
+// recordReplay writes the latency of each replayed packet to the monitor, and returns them as the result of the experiment
+func recordReplay(packets []dissent_protocol.ReplayedPacket) []string {
+	lines := make([]string, len(packets))
+	for k, packet := range packets {
+		monitor.RecordSingleMeasure("pcap_latency", float64(packet.Latency)/float64(time.Millisecond))
+		lines[k] = packet.String()
+	}
+	return lines
+}
+
 func writeExperimentResult(data []string, simulationID string, config *onet.SimulationConfig) {
 	//create folder for this experiment
 	folderName := "output_" + simulationID + "/" + hashString(config.Config)